@@ -1,3 +1,2 @@
-cd server
-podman build -t "venom1270/rps-0.9" .
+podman build -f server/Dockerfile -t "venom1270/rps-0.9" .
 podman push "venom1270/rps-0.9"
\ No newline at end of file
@@ -2,15 +2,22 @@ package client
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coder/websocket"
-	"github.com/venom1270/RPS/messaging"
+	"github.com/venom1270/RPS/protocol/messaging"
 )
 
 type ClientState int
@@ -20,6 +27,7 @@ const (
 	IN_LOBBY                          // 1
 	IN_LOBBY_READY                    // 2
 	IN_GAME                           // 3
+	SPECTATING                        // 4
 )
 
 type Client struct {
@@ -30,6 +38,42 @@ type Client struct {
 	State ClientState
 	Lobby string
 	ctx   context.Context
+
+	// Subprotocols offered to the server, preferred first. The negotiated one decides the wire format.
+	Subprotocols []string
+	codec        messaging.Codec
+
+	// Messages read from the connection, replies to requests go to the waiting Request instead
+	incoming chan messaging.Message
+	done     chan struct{}
+	readErr  error
+
+	pendingMu sync.Mutex
+	pending   map[string]chan messaging.Message
+	requestId int
+
+	// Latest lobby state, game details and round result received from the server
+	LobbyState  *messaging.LobbyState
+	GameDetails *messaging.GameDetails
+	LastRound   *messaging.RoundResult
+
+	// Session of the joined lobby, used to rejoin after the connection dropped
+	Session *messaging.Session
+	// State received after the last rejoin
+	Resync *messaging.Resync
+	// Latest chat message in the lobby
+	LastChat *messaging.ChatMessage
+	// Invite code of the joined lobby
+	InviteCode string
+	// Host of the joined lobby and its settings as a query string, e.g. "rules=classic&target=3"
+	Host     string
+	Settings string
+	// Results of the games played in the lobby so far
+	Series *messaging.Series
+
+	// Commit-reveal: choice and nonce of the last commitment
+	commitChoice int
+	commitNonce  string
 }
 
 func NewClient(url string, clientId string) *Client {
@@ -38,31 +82,34 @@ func NewClient(url string, clientId string) *Client {
 		id:    clientId,
 		State: CONNECTED,
 		url:   url,
+
+		Subprotocols: messaging.Subprotocols,
+		codec:        messaging.LegacyCodec,
+		pending:      map[string]chan messaging.Message{},
 	}
 
 	return cl
 }
 
-func (cl *Client) Connect(ctx context.Context, url string, method, lobby string) error {
-
-	log.Printf("Trying to connect client '%d' to lobby '%s'", cl.id, lobby)
+// Id returns the client's id.
+func (cl *Client) Id() string {
+	return cl.id
+}
 
-	finalUrl := url + "/" + method + "/" + lobby + "/" + cl.id
+// Connect opens the websocket connection of a lobby method, e.g. joinLobby. The query holds lobby settings,
+// a password or an invite code, it may be nil.
+func (cl *Client) Connect(ctx context.Context, url string, method, lobby string, query neturl.Values) error {
 
-	log.Printf("Final URL: %s", finalUrl)
+	log.Printf("Trying to connect client '%s' to lobby '%s'", cl.id, lobby)
 
-	c, _, err := websocket.Dial(ctx, finalUrl, nil)
-	if err != nil {
-		return err
+	finalUrl := url + "/" + method + "/" + lobby + "/" + cl.id
+	if len(query) > 0 {
+		finalUrl += "?" + query.Encode()
 	}
-
-	/*c, _, err := websocket.Dial(ctx, url+"/subscribe/"+lobby, nil)
-	if err != nil {
+	if err := cl.dial(ctx, finalUrl); err != nil {
 		return err
-	}*/
-
-	cl.c = c
-	cl.ctx = ctx
+	}
+	var err error
 
 	log.Printf("Client  with id '%s' connected to lobby '%s'", cl.id, cl.Lobby)
 
@@ -93,14 +140,216 @@ func (cl *Client) Connect(ctx context.Context, url string, method, lobby string)
 	return nil
 }
 
+// Rejoin reconnects to the lobby of the current session after the connection dropped.
+// The server answers with a resync message holding the lobby and game state, see Resync.
+func (cl *Client) Rejoin(ctx context.Context) error {
+	if cl.Session == nil {
+		return errors.New("no session to rejoin")
+	}
+	log.Printf("Trying to rejoin client '%s' to lobby '%s'", cl.id, cl.Session.Lobby)
+	return cl.dial(ctx, cl.url+"/rejoin/"+cl.Session.Lobby+"/"+cl.id+"?token="+neturl.QueryEscape(cl.Session.Token))
+}
+
+// dial opens the websocket connection and starts reading messages from it.
+func (cl *Client) dial(ctx context.Context, finalUrl string) error {
+	log.Printf("Final URL: %s", finalUrl)
+
+	c, resp, err := websocket.Dial(ctx, finalUrl, &websocket.DialOptions{Subprotocols: cl.Subprotocols})
+	if err != nil {
+		if resp != nil && resp.Body != nil {
+			return responseError(resp)
+		}
+		return err
+	}
+	cl.codec = messaging.CodecFor(c.Subprotocol())
+	log.Printf("Using wire format %s", cl.codec.Subprotocol())
+
+	cl.incoming = make(chan messaging.Message, 256)
+	cl.done = make(chan struct{})
+	go cl.readLoop(c, cl.codec, cl.incoming, cl.done)
+
+	cl.c = c
+	cl.ctx = ctx
+	return nil
+}
+
+// SendMessage sends a message written in the legacy `type:cmd:content` format, converted to the negotiated wire format.
 func (cl *Client) SendMessage(msg string) error {
 	log.Printf("SENDING MESSAGE: %s", msg)
-	return cl.c.Write(cl.ctx, websocket.MessageText, []byte(msg))
+	return cl.SendMessage2(messaging.ToMessage([]byte(msg)))
 }
 
 func (cl *Client) SendMessage2(msg messaging.Message) error {
 	log.Printf("SENDING MESSAGE: %v", msg)
-	return cl.c.Write(cl.ctx, websocket.MessageText, []byte(msg.Parse()))
+	return cl.c.Write(cl.ctx, websocket.MessageText, cl.codec.Encode(msg))
+}
+
+// Commit sends a commitment to the choice with a random nonce, the choice is sent later with Reveal.
+func (cl *Client) Commit(choice int) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+	cl.commitChoice = choice
+	cl.commitNonce = hex.EncodeToString(nonceBytes)
+
+	return cl.SendMessage2(*messaging.CreateCommandMessage(messaging.CommandCommit, messaging.CommitHash(choice, cl.commitNonce)))
+}
+
+// Reveal sends the committed choice and nonce.
+func (cl *Client) Reveal() error {
+	if cl.commitNonce == "" {
+		return fmt.Errorf("nothing to reveal")
+	}
+	content := fmt.Sprintf("%d %s", cl.commitChoice, cl.commitNonce)
+	cl.commitNonce = ""
+
+	return cl.SendMessage2(*messaging.CreateCommandMessage(messaging.CommandReveal, content))
+}
+
+// AddBot asks the server to fill an empty seat in the lobby with a bot using the given strategy (empty for default).
+func (cl *Client) AddBot(strategy string) error {
+	return cl.Send(messaging.CommandLobbyAddBot, messaging.TextPayload{Content: strategy})
+}
+
+// Chat sends a chat message to everyone in the lobby.
+func (cl *Client) Chat(text string) error {
+	return cl.Send(messaging.CommandChat, messaging.TextPayload{Content: text})
+}
+
+// Kick removes a player from the lobby, only the host can do that.
+func (cl *Client) Kick(clientId string) error {
+	return cl.Send(messaging.CommandLobbyKick, messaging.TextPayload{Content: clientId})
+}
+
+// TransferHost makes another player the lobby's host, only the host can do that.
+func (cl *Client) TransferHost(clientId string) error {
+	return cl.Send(messaging.CommandLobbyHost, messaging.TextPayload{Content: clientId})
+}
+
+// ChangeSettings changes lobby settings before the game starts, e.g. "rules=classic&target=5". Only the host can do that.
+func (cl *Client) ChangeSettings(query string) error {
+	return cl.Send(messaging.CommandLobbySettings, messaging.TextPayload{Content: query})
+}
+
+// Rematch votes for another game after the game ended, with swap the players change seats.
+func (cl *Client) Rematch(swap bool) error {
+	content := ""
+	if swap {
+		content = "swap"
+	}
+	return cl.Send(messaging.CommandRematch, messaging.TextPayload{Content: content})
+}
+
+// Choose sends the choice for the current round.
+func (cl *Client) Choose(choice string) error {
+	if cl.codec != messaging.JSONCodec {
+		return cl.SendMessage2(messaging.Message{
+			Type:    messaging.MessageText,
+			Cmd:     messaging.CommandChoice,
+			Content: choice,
+		})
+	}
+	return cl.Send(messaging.CommandChoice, messaging.TextPayload{Content: choice})
+}
+
+// How long Send waits for the server's reply
+const requestTimeout = 5 * time.Second
+
+// Send sends a command. With the JSON wire format it waits for the reply and returns the request's error,
+// the legacy format has no replies so it returns right after sending.
+func (cl *Client) Send(cmd messaging.Command, payload messaging.Payload) error {
+	if cl.codec != messaging.JSONCodec {
+		if payload == nil {
+			return cl.SendMessage2(*messaging.CreateCommandMessage(cmd, ""))
+		}
+		return cl.SendMessage2(*messaging.CreatePayloadMessage(cmd, payload))
+	}
+
+	ctx, cancel := context.WithTimeout(cl.ctx, requestTimeout)
+	defer cancel()
+	_, err := cl.Request(ctx, cmd, payload)
+	return err
+}
+
+// Request sends a command with a request id and waits for the server's reply to it.
+// It returns the reply message and the request's error if it failed. Requests need the JSON wire format.
+func (cl *Client) Request(ctx context.Context, cmd messaging.Command, payload messaging.Payload) (messaging.Message, error) {
+	if cl.codec != messaging.JSONCodec {
+		return messaging.Message{}, errors.New("requests need the JSON wire format")
+	}
+
+	msg := messaging.CreateCommandMessage(cmd, "")
+	if payload != nil {
+		msg = messaging.CreatePayloadMessage(cmd, payload)
+	}
+
+	cl.pendingMu.Lock()
+	cl.requestId++
+	msg.Id = strconv.Itoa(cl.requestId)
+	replyCh := make(chan messaging.Message, 1)
+	cl.pending[msg.Id] = replyCh
+	done := cl.done
+	cl.pendingMu.Unlock()
+
+	defer func() {
+		cl.pendingMu.Lock()
+		delete(cl.pending, msg.Id)
+		cl.pendingMu.Unlock()
+	}()
+
+	if err := cl.SendMessage2(*msg); err != nil {
+		return messaging.Message{}, err
+	}
+
+	select {
+	case reply := <-replyCh:
+		if reply.Cmd != messaging.CommandReply {
+			// The reply is the requested data, e.g. the game state
+			return reply, nil
+		}
+		var r messaging.Reply
+		if err := reply.DecodePayload(&r); err != nil {
+			return reply, err
+		}
+		return reply, r.Err()
+	case <-ctx.Done():
+		return messaging.Message{}, ctx.Err()
+	case <-done:
+		return messaging.Message{}, errors.New("connection closed")
+	}
+}
+
+// readLoop reads messages until the connection closes. Replies to pending requests are handed to
+// the waiting Request, everything else is queued for NextMessage.
+func (cl *Client) readLoop(c *websocket.Conn, codec messaging.Codec, incoming chan messaging.Message, done chan struct{}) {
+	defer close(done)
+	defer close(incoming)
+
+	for {
+		typ, b, err := c.Read(context.Background())
+		if err != nil {
+			cl.readErr = err
+			return
+		}
+		if typ != websocket.MessageText {
+			c.Close(websocket.StatusUnsupportedData, "expected text message")
+			cl.readErr = fmt.Errorf("expected text message but got %v", typ)
+			return
+		}
+
+		msg := codec.Decode(b)
+		if msg.Id != "" {
+			cl.pendingMu.Lock()
+			replyCh, ok := cl.pending[msg.Id]
+			cl.pendingMu.Unlock()
+			if ok {
+				replyCh <- msg
+				continue
+			}
+		}
+		incoming <- msg
+	}
 }
 
 func (cl *Client) CallMethod(ctx context.Context, msg string, method string) (body string, err error) {
@@ -118,12 +367,8 @@ func (cl *Client) CallMethod(ctx context.Context, msg string, method string) (bo
 		return "", err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("publish request failed: %v", resp.StatusCode)
-	}
-	if resp.StatusCode == http.StatusBadRequest {
-		// TODO: send soemthing to client that says invalid request
-		return "", fmt.Errorf("Bad request")
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -135,17 +380,145 @@ func (cl *Client) CallMethod(ctx context.Context, msg string, method string) (bo
 	return string(bodyBytes), nil
 }
 
-func (cl *Client) NextMessage() (string, error) {
-	typ, b, err := cl.c.Read(context.Background())
+// Invite returns the name of the lobby the invite code belongs to. Join it with the code as the "invite" query value.
+func (cl *Client) Invite(ctx context.Context, code string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cl.url+"/invite/"+neturl.PathEscape(code), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	var invite struct {
+		Lobby string `json:"lobby"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&invite); err != nil {
+		return "", err
+	}
+	return invite.Lobby, nil
+}
+
+// Lobbies returns a page of the open lobbies, query filters them, e.g. state=CREATED&rules=classic&limit=10
+func (cl *Client) Lobbies(ctx context.Context, query neturl.Values) (messaging.LobbyList, error) {
+	var list messaging.LobbyList
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cl.url+"/api/v1/lobbies?"+query.Encode(), nil)
+	if err != nil {
+		return list, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return list, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return list, responseError(resp)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	return list, err
+}
+
+// responseError reads the error code and message the server sent with a failed HTTP request.
+func responseError(resp *http.Response) error {
+	var e messaging.Error
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+		return fmt.Errorf("request failed: %v", resp.Status)
+	}
+	return &e
+}
+
+// RequestGameState asks the server for the game details, they are stored in GameDetails when the reply arrives.
+func (cl *Client) RequestGameState() error {
+	return cl.SendMessage2(*messaging.CreateCommandMessage(messaging.CommandGameState, ""))
+}
+
+// NextMessage returns the next message from the server that isn't a reply to a Request.
+// Lobby state, game details and round results are also decoded into the client's fields.
+func (cl *Client) NextMessage() (messaging.Message, error) {
+	msg, ok := <-cl.incoming
+	if !ok {
+		return messaging.Message{}, cl.readErr
+	}
 
-	if typ != websocket.MessageText {
-		cl.c.Close(websocket.StatusUnsupportedData, "expected text message")
-		return "", fmt.Errorf("expected text message but got %v", typ)
+	if err := cl.decodePayload(msg); err != nil {
+		log.Printf("Error decoding %v payload: %v", msg.Cmd, err)
 	}
-	return string(b), nil
+	return msg, nil
+}
+
+func (cl *Client) decodePayload(msg messaging.Message) error {
+	if msg.Type != messaging.MessageCommand {
+		return nil
+	}
+
+	switch msg.Cmd {
+	case messaging.CommandLobbyState:
+		var state messaging.LobbyState
+		if err := msg.DecodePayload(&state); err != nil {
+			return err
+		}
+		cl.LobbyState = &state
+	case messaging.CommandGameState:
+		var details messaging.GameDetails
+		if err := msg.DecodePayload(&details); err != nil {
+			return err
+		}
+		cl.GameDetails = &details
+	case messaging.CommandRoundResult:
+		var result messaging.RoundResult
+		if err := msg.DecodePayload(&result); err != nil {
+			return err
+		}
+		cl.LastRound = &result
+	case messaging.CommandSession:
+		var session messaging.Session
+		if err := msg.DecodePayload(&session); err != nil {
+			return err
+		}
+		// The legacy format only has the token
+		if session.Lobby == "" {
+			session.Lobby = cl.Lobby
+		}
+		if session.ClientId == "" {
+			session.ClientId = cl.id
+		}
+		cl.Session = &session
+	case messaging.CommandResync:
+		var resync messaging.Resync
+		if err := msg.DecodePayload(&resync); err != nil {
+			return err
+		}
+		cl.Resync = &resync
+		cl.LobbyState = &resync.Lobby
+		if resync.Game != nil {
+			cl.GameDetails = resync.Game
+		}
+	case messaging.CommandInvite:
+		cl.InviteCode = msg.Content
+	case messaging.CommandLobbyHost:
+		cl.Host = msg.Content
+	case messaging.CommandLobbySettings:
+		cl.Settings = msg.Content
+	case messaging.CommandSeries:
+		var series messaging.Series
+		if err := msg.DecodePayload(&series); err != nil {
+			return err
+		}
+		cl.Series = &series
+	case messaging.CommandChat:
+		var chat messaging.ChatMessage
+		if err := msg.DecodePayload(&chat); err != nil {
+			return err
+		}
+		cl.LastChat = &chat
+	}
+	return nil
 }
 
 func (cl *Client) Close() error {
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// listPayload isn't an object, the server can't decode requests carrying it.
+type listPayload []int
+
+func (listPayload) LegacyString() string {
+	return ""
+}
+
+// testServer answers requests like the game server: choices fail, lobby_exit closes the connection without
+// a reply and undecodable requests get a BAD_REQUEST reply. Everything else succeeds after an unrelated message.
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{messaging.SubprotocolJSON}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.CloseNow()
+
+		ctx := context.Background()
+		write := func(msg *messaging.Message) {
+			c.Write(ctx, websocket.MessageText, messaging.JSONCodec.Encode(*msg))
+		}
+		for {
+			_, b, err := c.Read(ctx)
+			if err != nil {
+				return
+			}
+			req := messaging.JSONCodec.Decode(b)
+
+			var replyErr error
+			switch {
+			case req.Type == messaging.MessageCorrupted:
+				replyErr = messaging.NewError(messaging.ErrBadRequest, req.Content)
+			case req.Cmd == messaging.CommandLobbyExit:
+				c.Close(websocket.StatusNormalClosure, "")
+				return
+			case req.Cmd == messaging.CommandChoice:
+				replyErr = messaging.NewError(messaging.ErrGameNotRunning, "no game is running")
+			default:
+				write(messaging.CreateTextMessage("Player joined"))
+			}
+			reply := messaging.CreatePayloadMessage(messaging.CommandReply, messaging.NewReply(req.Cmd, replyErr))
+			reply.Id = req.Id
+			write(reply)
+		}
+	}))
+}
+
+func connectTestClient(t *testing.T, srv *httptest.Server) *Client {
+	t.Helper()
+	cl := NewClient(srv.URL, "a")
+	if err := cl.dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")); err != nil {
+		t.Fatal(err)
+	}
+	if cl.codec != messaging.JSONCodec {
+		t.Fatalf("negotiated %s, want the JSON wire format", cl.codec.Subprotocol())
+	}
+	return cl
+}
+
+func TestRequest(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+	cl := connectTestClient(t, srv)
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		reply, err := cl.Request(ctx, messaging.CommandLobbyReady, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Cmd != messaging.CommandReply || reply.Id == "" {
+			t.Errorf("reply %+v, want the reply to the request", reply)
+		}
+	}
+
+	// Messages that aren't replies are left for NextMessage
+	for i := 0; i < 2; i++ {
+		if msg, err := cl.NextMessage(); err != nil || msg.Content != "Player joined" {
+			t.Errorf("next message %+v (%v), want the message sent before the reply", msg, err)
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+	cl := connectTestClient(t, srv)
+	defer cl.Close()
+
+	tests := []struct {
+		cmd     messaging.Command
+		payload messaging.Payload
+		want    messaging.ErrorCode
+	}{
+		{messaging.CommandChoice, messaging.TextPayload{Content: "1"}, messaging.ErrGameNotRunning},
+		// The server can't decode the request, the reply still carries its id
+		{messaging.CommandLobbyAddBot, listPayload{1}, messaging.ErrBadRequest},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := cl.Request(ctx, tt.cmd, tt.payload)
+		cancel()
+		if messaging.CodeOf(err) != tt.want {
+			t.Errorf("%v request: %v, want %s", tt.cmd, err, tt.want)
+		}
+	}
+}
+
+func TestRequestConnectionClosed(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+	cl := connectTestClient(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := cl.Request(ctx, messaging.CommandLobbyExit, nil)
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("request on a closed connection: %v, want it to return right away", err)
+	}
+}
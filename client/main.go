@@ -5,10 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	neturl "net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/venom1270/RPS/client"
-	"github.com/venom1270/RPS/messaging"
+	"github.com/venom1270/RPS/client/client"
+	"github.com/venom1270/RPS/protocol/messaging"
 )
 
 var ctx context.Context
@@ -44,8 +48,6 @@ func startClient(url string, clientId string) error {
 
 	cl = client.NewClient(url, clientId)
 
-	response := ""
-
 	for {
 
 		switch cl.State {
@@ -57,9 +59,11 @@ func startClient(url string, clientId string) error {
 			fmt.Println("STATE: READY, in lobby:", cl.Lobby)
 		case client.IN_GAME:
 			fmt.Println("STATE: IN GAME, lobby:", cl.Lobby)
+		case client.SPECTATING:
+			fmt.Println("STATE: SPECTATING, lobby:", cl.Lobby)
 		}
 
-		fmt.Printf("\n *** OPTIONS ***\n1: getLobbyList\n2: createLobby [name]\n3: joinLobby [name]\n4: exitLobby\n5: SET READY (final, if in lobby)\n*******\n")
+		fmt.Printf("\n *** OPTIONS ***\n1: getLobbyList [filter] (- for all, or state=CREATED&rules=classic)\n2: createLobby [name] (options: name?private=true&password=secret)\n3: joinLobby [name]\n4: exitLobby\n5: SET READY (final, if in lobby)\n6: spectateLobby [name]\n7: joinInvite [code]\n*******\nIn a lobby, type bot or bot=<strategy> (random, frequency, markov, nojoker) to add a bot player and say <message> to chat\n")
 
 		var err error
 		method := ""
@@ -69,27 +73,49 @@ func startClient(url string, clientId string) error {
 		fmt.Scan(&msg)
 		switch method {
 		case "1":
-			response, err = cl.CallMethod(ctx, msg, "getLobbyList")
+			// "-" lists all lobbies, otherwise msg filters them, e.g. state=CREATED&rules=classic
+			query, err := neturl.ParseQuery(strings.TrimPrefix(msg, "-"))
 			if err != nil {
-				fmt.Println(err)
-			} else {
-				fmt.Println(response)
+				fmt.Println("Invalid filter!", err)
+				break
+			}
+			list, err := cl.Lobbies(ctx, query)
+			if err != nil {
+				fmt.Println(describeError(err))
+				break
+			}
+			fmt.Printf("%d lobbies\n", list.Total)
+			for _, l := range list.Lobbies {
+				fmt.Printf("%s: %s, %s, %d/%d players, %d spectators", l.Name, l.State, l.Rules, len(l.Players), l.MaxPlayers, l.Spectators)
+				if l.Locked {
+					fmt.Print(", password")
+				}
+				if l.Tournament != "" {
+					fmt.Print(", tournament ", l.Tournament)
+				}
+				fmt.Println()
 			}
 		case "2":
-			err = cl.Connect(ctx, url, "createLobby", msg)
+			name, options, _ := strings.Cut(msg, "?")
+			query, err := neturl.ParseQuery(options)
 			if err != nil {
-				log.Printf("ERROR CREATING AND JOINING TO LOBBY!!! %v", err)
+				fmt.Println("Invalid lobby options!", err)
+				break
+			}
+			err = cl.Connect(ctx, url, "createLobby", name, query)
+			if err != nil {
+				log.Printf("ERROR CREATING AND JOINING TO LOBBY!!! %s", describeError(err))
 				break
 			}
 			cl.State = client.IN_LOBBY
-			cl.Lobby = msg
+			cl.Lobby = name
 
 			websocketHandling()
 
 		case "3":
-			err = cl.Connect(ctx, url, "joinLobby", msg)
+			err = connectLobby(url, "joinLobby", msg, nil)
 			if err != nil {
-				log.Printf("ERROR JOINING TO LOBBY!!! %v", err)
+				log.Printf("ERROR JOINING TO LOBBY!!! %s", describeError(err))
 				break
 			}
 
@@ -99,19 +125,19 @@ func startClient(url string, clientId string) error {
 			websocketHandling()
 
 		case "4":
-			response, err = cl.CallMethod(ctx, msg, "exitLobby")
+			_, err = cl.CallMethod(ctx, msg, "exitLobby")
 			if err != nil {
 				fmt.Println(err)
 			}
 			cl.State = client.CONNECTED
 		case "5":
-			response, err = cl.CallMethod(ctx, msg, "ready")
+			_, err = cl.CallMethod(ctx, msg, "ready")
 			if err != nil {
 				fmt.Println(err)
 			}
 			cl.State = client.IN_LOBBY_READY
 
-			err = cl.Connect(ctx, url, "TODO_DELETE THIS", cl.Lobby)
+			err = cl.Connect(ctx, url, "TODO_DELETE THIS", cl.Lobby, nil)
 			if err != nil {
 				log.Printf("ERROR CONNECTING TO LOBBY!!! %v", err)
 				break
@@ -120,13 +146,40 @@ func startClient(url string, clientId string) error {
 			// Start game - look at websocket messages
 			websocketHandling()
 
+		case "6":
+			err = connectLobby(url, "spectateLobby", msg, nil)
+			if err != nil {
+				log.Printf("ERROR SPECTATING LOBBY!!! %s", describeError(err))
+				break
+			}
+
+			cl.State = client.SPECTATING
+			cl.Lobby = msg
+
+			websocketHandling()
+
+		case "7":
+			lobby, err := cl.Invite(ctx, msg)
+			if err != nil {
+				log.Printf("ERROR JOINING WITH INVITE!!! %s", describeError(err))
+				break
+			}
+			err = cl.Connect(ctx, url, "joinLobby", lobby, neturl.Values{"invite": {msg}})
+			if err != nil {
+				log.Printf("ERROR JOINING TO LOBBY!!! %s", describeError(err))
+				break
+			}
+
+			cl.State = client.IN_LOBBY
+			cl.Lobby = lobby
+
+			websocketHandling()
+
 		default:
 			fmt.Println("INVALID METHOD!")
 		}
 
 	}
-
-	return nil
 }
 
 func websocketHandling() {
@@ -141,25 +194,89 @@ func websocketHandling() {
 		go func() {
 			for {
 				msg, err := cl.NextMessage()
-				log.Printf("GOT MESSAGE: %s", msg)
+				log.Printf("GOT MESSAGE: %v", msg)
 				if err != nil {
+					if gameEnd || cl.Session == nil {
+						return
+					}
+					fmt.Println("Connection lost, trying to rejoin...")
+					if err := rejoin(); err != nil {
+						fmt.Printf("Could not rejoin the game! %s\n", describeError(err))
+						return
+					}
 					continue
 				}
-				if msg == "0" {
-					fmt.Println("Input signal recived. Please input your choice (0-3)\n0 - ROCK\n1 - PAPER\n2 - SCISSORS\n3 - JOKER (dangerous card, defeated by SCISSORS and sometimes JOKER)\n")
-					break
-				} else if msg == "1" {
-					fmt.Println("Game ended. Disconnecting...")
+				if msg.Type == messaging.MessageCommand {
+					switch msg.Cmd {
+					case messaging.CommandLobbyState:
+						if cl.LobbyState == nil {
+							break
+						}
+						fmt.Println("Lobby", cl.LobbyState.Lobby)
+						for _, p := range cl.LobbyState.Players {
+							fmt.Printf("  %s ready=%t\n", p.ClientId, p.Ready)
+						}
+					case messaging.CommandGameState:
+						if cl.GameDetails == nil {
+							break
+						}
+						for _, p := range cl.GameDetails.Players {
+							fmt.Printf("  %s: %d points, choices %v\n", p.ClientId, p.Score, p.Choices)
+						}
+					case messaging.CommandResync:
+						if cl.Resync == nil {
+							break
+						}
+						fmt.Printf("Rejoined lobby %s (%s), round %d\n", cl.Resync.Lobby.Lobby, cl.Resync.State, cl.Resync.Round)
+						if cl.Resync.Input {
+							printInputPrompt()
+						} else if cl.Resync.Reveal {
+							fmt.Println("Reveal your choice with r")
+						}
+					case messaging.CommandSession:
+						// Kept by the client for rejoining
+					case messaging.CommandInvite:
+						fmt.Println("Invite code:", cl.InviteCode)
+					case messaging.CommandLobbyHost:
+						if cl.Host == cl.Id() {
+							fmt.Println("You are the host: kick <clientId>, host <clientId> and settings <query> (e.g. settings target=5&timeout=10)")
+						} else {
+							fmt.Println("Host:", cl.Host)
+						}
+					case messaging.CommandLobbySettings:
+						fmt.Println("Settings:", cl.Settings)
+					case messaging.CommandSeries:
+						// The server also sends a summary as text
+					case messaging.CommandLobbyGameStarting:
+						gameEnd = false
+						fmt.Println("Game is starting!")
+					case messaging.CommandChat:
+						if cl.LastChat == nil {
+							break
+						}
+						fmt.Printf("[%s] %s: %s\n", time.UnixMilli(cl.LastChat.Time).Format("15:04"), cl.LastChat.From, cl.LastChat.Text)
+					case messaging.CommandRoundResult:
+						if cl.LastRound == nil {
+							break
+						}
+						fmt.Printf("Round %d: choices %v, points %v\n", cl.LastRound.Round, cl.LastRound.Choices, cl.LastRound.Deltas)
+					default:
+						fmt.Printf("%v: %s\n", msg.Cmd, msg.Content)
+					}
+				} else if msg.Content == "0" {
+					if cl.State == client.SPECTATING {
+						fmt.Println("New round started")
+						continue
+					}
+					printInputPrompt()
+				} else if msg.Content == "1" {
+					// The lobby stays open for a rematch
+					fmt.Println("Game ended. Type rematch (or rematch swap to change seats) to play again.")
 					gameEnd = true
-					break
 				} else {
-					fmt.Println(msg)
+					fmt.Println(msg.Content)
 				}
 			}
-
-			if gameEnd {
-				//break
-			}
 		}()
 
 		for {
@@ -170,25 +287,80 @@ func websocketHandling() {
 				continue
 			}
 
-			// To simulate ready
+			// Chat: "say <message>", the message is the rest of the line
+			if choice == "say" {
+				if err := cl.Chat(readLine()); err != nil {
+					fmt.Printf("Message not sent! %s\n", describeError(err))
+				}
+				continue
+			}
+
+			// Host commands: "kick <clientId>", "host <clientId>" and "settings <query>"
+			if choice == "kick" || choice == "host" || choice == "settings" {
+				arg := readLine()
+				var err error
+				switch choice {
+				case "kick":
+					err = cl.Kick(arg)
+				case "host":
+					err = cl.TransferHost(arg)
+				case "settings":
+					err = cl.ChangeSettings(arg)
+				}
+				if err != nil {
+					fmt.Println(describeError(err))
+				}
+				continue
+			}
+
+			// Rematch after the game: "rematch" or "rematch swap"
+			if choice == "rematch" {
+				if err := cl.Rematch(readLine() == "swap"); err != nil {
+					fmt.Println(describeError(err))
+				}
+				continue
+			}
+
+			// Bot players: "bot" or "bot=<strategy>"
+			if strings.HasPrefix(choice, "bot") {
+				if err := cl.AddBot(strings.TrimPrefix(strings.TrimPrefix(choice, "bot"), "=")); err != nil {
+					fmt.Println(err)
+				}
+				continue
+			}
+
+			// Commit-reveal
+			if len(choice) > 1 && choice[0] == 'c' {
+				c, err := strconv.Atoi(choice[1:])
+				if err != nil {
+					fmt.Println("Invalid choice to commit!")
+					continue
+				}
+				if err := cl.Commit(c); err != nil {
+					fmt.Println(err)
+				}
+				continue
+			}
+			if choice == "r" {
+				if err := cl.Reveal(); err != nil {
+					fmt.Println(err)
+				}
+				continue
+			}
+
+			// Raw commands, e.g. 0:1: to get ready
 			if len(choice) > 1 && choice[0:2] == "0:" {
-				// If it's a CMD message, server won't respond so we have to continue.
-				cl.SendMessage(choice)
-				// Get scores (test)
-				//if choice == "CMD:555" || choice == "CMD:556" || choice == "CMD:0" {
-				//r, _ := cl.NextMessage()
-				//fmt.Println(r)
-				//}
-
-				// TODO: this is ugly :(
+				msg := messaging.ToMessage([]byte(choice))
+				if err := cl.Send(msg.Cmd, messaging.TextPayload{Content: msg.Content}); err != nil {
+					fmt.Println(err)
+				}
 				continue
 			}
 
-			cl.SendMessage2(messaging.Message{
-				Type:    messaging.MessageText,
-				Cmd:     messaging.CommandChoice,
-				Content: choice,
-			})
+			if err := cl.Choose(choice); err != nil {
+				fmt.Printf("Choice was not accepted! %v\n", err)
+				continue
+			}
 
 			/*response, err := cl.NextMessage()
 			if err != nil {
@@ -214,3 +386,89 @@ func websocketHandling() {
 	// Create new context
 	ctx, cancel = context.WithCancel(context.Background())
 }
+
+// connectLobby connects to the lobby and asks for the password if the lobby has one.
+func connectLobby(url string, method string, lobby string, query neturl.Values) error {
+	err := cl.Connect(ctx, url, method, lobby, query)
+	if messaging.CodeOf(err) != messaging.ErrWrongPassword {
+		return err
+	}
+
+	fmt.Println("The lobby has a password, please enter it:")
+	password := ""
+	fmt.Scan(&password)
+	if query == nil {
+		query = neturl.Values{}
+	}
+	query.Set("password", password)
+	return cl.Connect(ctx, url, method, lobby, query)
+}
+
+// describeError turns the server's error codes into messages for the player.
+func describeError(err error) string {
+	switch messaging.CodeOf(err) {
+	case messaging.ErrLobbyExists:
+		return "A lobby with this name already exists, join it instead."
+	case messaging.ErrLobbyNotFound:
+		return "There is no lobby with this name."
+	case messaging.ErrLobbyFull:
+		return "The lobby is full."
+	case messaging.ErrAlreadyInLobby:
+		return "You are already in this lobby."
+	case messaging.ErrWrongPassword:
+		return "Wrong password."
+	case messaging.ErrNotHost:
+		return "Only the host can do that."
+	case messaging.ErrGameNotFinished:
+		return "The game isn't over yet."
+	case messaging.ErrNotInMatch:
+		return "The lobby is reserved for a tournament match of other players."
+	case messaging.ErrInvalidSettings:
+		return "Invalid lobby settings: " + messaging.MessageOf(err)
+	case messaging.ErrRateLimited:
+		return "You are sending messages too fast."
+	case messaging.ErrMessageTooLong, messaging.ErrMessageRejected:
+		return messaging.MessageOf(err)
+	default:
+		return err.Error()
+	}
+}
+
+// readLine reads the rest of the current input line. It reads stdin directly, without buffering,
+// so that fmt.Scan keeps working afterwards.
+func readLine() string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if err != nil || (n == 1 && b[0] == '\n') {
+			break
+		}
+		line = append(line, b[:n]...)
+	}
+	return strings.TrimSpace(string(line))
+}
+
+func printInputPrompt() {
+	fmt.Println("Input signal recived. Please input your choice (0-3)\n0 - ROCK\n1 - PAPER\n2 - SCISSORS\n3 - JOKER (dangerous card, defeated by SCISSORS and sometimes JOKER)\nIn commit-reveal lobbies, commit with c<choice> (e.g. c2) and reveal with r when asked to")
+}
+
+// How long to wait between rejoin attempts
+const rejoinInterval = 2 * time.Second
+
+// rejoin takes the seat back after the connection dropped, retrying until the server's grace period runs out.
+func rejoin() error {
+	deadline := time.Now().Add(time.Duration(cl.Session.Grace) * time.Millisecond)
+	for {
+		err := cl.Rejoin(ctx)
+		if err == nil {
+			return nil
+		}
+		if messaging.CodeOf(err) != messaging.ErrRejected || time.Now().Add(rejoinInterval).After(deadline) {
+			// The server refused, or there is no time left
+			return err
+		}
+		log.Printf("Rejoin failed, retrying: %v", err)
+		time.Sleep(rejoinInterval)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/venom1270/RPS/protocol
+
+go 1.22.2
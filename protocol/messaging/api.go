@@ -0,0 +1,49 @@
+package messaging
+
+// Request and response bodies of the server's REST API under /api/v1. Failed requests answer with an Error.
+
+// LobbyInfo describes a lobby, see GET /api/v1/lobbies/{name}.
+type LobbyInfo struct {
+	Name       string        `json:"name"`
+	State      string        `json:"state"` // CREATED, STARTING, IN_GAME or FINISHED
+	Players    []PlayerState `json:"players"`
+	MaxPlayers int           `json:"maxPlayers"`
+	Spectators int           `json:"spectators"`
+	Rules      string        `json:"rules"`
+	Format     string        `json:"format"` // match format, e.g. first-to-3
+	Private    bool          `json:"private"`
+	Locked     bool          `json:"locked"` // joining needs a password or the invite code
+	Host       string        `json:"host,omitempty"`
+	Tournament string        `json:"tournament,omitempty"` // the lobby is a match of this tournament
+	// Invite is only sent to the creator of the lobby, everyone else gets it after joining
+	Invite string `json:"invite,omitempty"`
+	// Token is only sent to the creator of the lobby, it is the host's session token they get after joining
+	Token string `json:"token,omitempty"`
+}
+
+// LobbyList is a page of lobbies, see GET /api/v1/lobbies.
+type LobbyList struct {
+	Lobbies []LobbyInfo `json:"lobbies"`
+	Total   int         `json:"total"` // matching lobbies on all pages
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+}
+
+// CreateLobby is the body of POST /api/v1/lobbies. Settings that are left out keep their defaults,
+// see the lobby creation options.
+type CreateLobby struct {
+	Name         string `json:"name"`
+	ClientId     string `json:"clientId"` // the creator, the lobby's host
+	Rules        string `json:"rules,omitempty"`
+	Players      int    `json:"players,omitempty"`
+	Scoring      string `json:"scoring,omitempty"`
+	Format       string `json:"format,omitempty"`
+	Target       int    `json:"target,omitempty"`
+	MaxRounds    int    `json:"maxRounds,omitempty"`
+	SuddenDeath  bool   `json:"suddenDeath,omitempty"`
+	Timeout      int    `json:"timeout,omitempty"` // seconds
+	OnTimeout    string `json:"onTimeout,omitempty"`
+	CommitReveal bool   `json:"commitReveal,omitempty"`
+	Private      bool   `json:"private,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
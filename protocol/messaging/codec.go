@@ -0,0 +1,225 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Websocket subprotocols. Clients that don't ask for a subprotocol (e.g. the Unity client) get the legacy format.
+const (
+	SubprotocolLegacy = "rps.v1"
+	SubprotocolJSON   = "rps.v2"
+)
+
+// Subprotocols lists the supported subprotocols, preferred first. Pass it to websocket.Accept and websocket.Dial.
+var Subprotocols = []string{SubprotocolJSON, SubprotocolLegacy}
+
+// ProtocolVersion is the version of the JSON envelope.
+const ProtocolVersion = 2
+
+// Codec encodes and decodes messages in one wire format.
+type Codec interface {
+	Subprotocol() string
+	Encode(msg Message) []byte
+	Decode(b []byte) Message
+}
+
+var (
+	LegacyCodec Codec = legacyCodec{}
+	JSONCodec   Codec = jsonCodec{}
+)
+
+// CodecFor returns the codec of a negotiated subprotocol, the legacy codec if none was negotiated.
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolJSON {
+		return JSONCodec
+	}
+	return LegacyCodec
+}
+
+// legacyCodec is the colon-delimited `type:cmd:content` format.
+type legacyCodec struct{}
+
+func (legacyCodec) Subprotocol() string {
+	return SubprotocolLegacy
+}
+
+func (legacyCodec) Encode(msg Message) []byte {
+	return msg.Parse()
+}
+
+func (legacyCodec) Decode(b []byte) Message {
+	return ToMessage(b)
+}
+
+// Envelope is a message in the JSON format: {"v":2,"type":"command","cmd":"lobby_ready","id":"1","payload":{...}}
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Command string          `json:"cmd,omitempty"`
+	Id      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// TextPayload is the payload of text messages and commands with plain content.
+type TextPayload struct {
+	Content string `json:"content"`
+}
+
+func (p TextPayload) LegacyString() string {
+	return p.Content
+}
+
+func (p *TextPayload) ParseLegacy(s string) error {
+	p.Content = s
+	return nil
+}
+
+var messageTypeNames = map[MessageType]string{
+	MessageCommand:   "command",
+	MessageText:      "text",
+	MessageCorrupted: "corrupted",
+}
+
+// jsonCodec is the versioned JSON envelope format.
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string {
+	return SubprotocolJSON
+}
+
+func (jsonCodec) Encode(msg Message) []byte {
+	env := Envelope{
+		Version: ProtocolVersion,
+		Type:    messageTypeNames[msg.Type],
+		Id:      msg.Id,
+	}
+	if msg.Type == MessageCommand {
+		env.Command = msg.Cmd.String()
+	}
+	if msg.Payload != nil {
+		env.Payload, _ = json.Marshal(msg.Payload)
+	} else if msg.Content != "" {
+		env.Payload, _ = json.Marshal(TextPayload{Content: msg.Content})
+	}
+
+	b, _ := json.Marshal(env)
+	return b
+}
+
+func (jsonCodec) Decode(b []byte) Message {
+	var env Envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return corrupted(env.Id, fmt.Sprintf("invalid JSON message: %v", err))
+	}
+	if env.Version != ProtocolVersion {
+		return corrupted(env.Id, fmt.Sprintf("unsupported protocol version %d", env.Version))
+	}
+
+	msg := Message{Type: MessageCorrupted, Cmd: CommandNil, Id: env.Id}
+	switch env.Type {
+	case "command":
+		cmd, ok := ParseCommand(env.Command)
+		if !ok {
+			return corrupted(env.Id, fmt.Sprintf("unknown command '%s'", env.Command))
+		}
+		msg.Type = MessageCommand
+		msg.Cmd = cmd
+	case "text":
+		msg.Type = MessageText
+	default:
+		return corrupted(env.Id, fmt.Sprintf("unknown message type '%s'", env.Type))
+	}
+
+	if len(env.Payload) > 0 {
+		msg.raw = env.Payload
+		var p TextPayload
+		if err := json.Unmarshal(env.Payload, &p); err != nil {
+			return corrupted(env.Id, fmt.Sprintf("invalid payload: %v", err))
+		}
+		msg.Content = p.Content
+	}
+	return msg
+}
+
+// corrupted keeps the request id, if there is one, so the error reply reaches the request.
+func corrupted(id string, reason string) Message {
+	fmt.Println("CORRUPTED MESSAGE (Decode()):", reason)
+	return Message{Type: MessageCorrupted, Cmd: CommandNil, Id: id, Content: reason}
+}
+
+var commandNames = map[Command]string{
+	CommandLobbyExit:         "lobby_exit",
+	CommandLobbyReady:        "lobby_ready",
+	CommandLobbyUnready:      "lobby_unready",
+	CommandLobbyGameStarting: "lobby_game_starting",
+	CommandLobbyState:        "lobby_state",
+	CommandChoice:            "choice",
+	CommandGameState:         "game_state",
+	CommandNil:               "nil",
+	CommandRoundTimer:        "round_timer",
+	CommandCommit:            "commit",
+	CommandReveal:            "reveal",
+	CommandLobbyAddBot:       "lobby_add_bot",
+	CommandQueueStatus:       "queue_status",
+	CommandQueueMatched:      "queue_matched",
+	CommandRoundResult:       "round_result",
+	CommandReply:             "reply",
+	CommandSession:           "session",
+	CommandResync:            "resync",
+	CommandChat:              "chat",
+	CommandInvite:            "invite",
+	CommandLobbyKick:         "lobby_kick",
+	CommandLobbyHost:         "lobby_host",
+	CommandLobbySettings:     "lobby_settings",
+	CommandRematch:           "rematch",
+	CommandSeries:            "series",
+	CommandTournament:        "tournament",
+	CommandTournamentMatch:   "tournament_match",
+}
+
+// Commands returns all named commands, ordered by number.
+func Commands() []Command {
+	commands := []Command{}
+	for c := range commandNames {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i] < commands[j] })
+	return commands
+}
+
+// String returns the command's name used in the JSON format. Commands without a name use their number.
+func (c Command) String() string {
+	if name, ok := commandNames[c]; ok {
+		return name
+	}
+	return strconv.Itoa(int(c))
+}
+
+func (c Command) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Command) UnmarshalText(b []byte) error {
+	cmd, ok := ParseCommand(string(b))
+	if !ok {
+		return fmt.Errorf("unknown command '%s'", b)
+	}
+	*c = cmd
+	return nil
+}
+
+// ParseCommand returns the command with the given name or number.
+func ParseCommand(s string) (Command, bool) {
+	for c, name := range commandNames {
+		if name == s {
+			return c, true
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return Command(n), true
+	}
+	return CommandNil, false
+}
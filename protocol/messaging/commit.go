@@ -0,0 +1,14 @@
+package messaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// CommitHash returns the hash a player commits to in commit-reveal games (CommandCommit):
+// hex encoded SHA-256 of "<choice>|<nonce>". The choice and nonce are sent with CommandReveal.
+func CommitHash(choice int, nonce string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s", choice, nonce)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,296 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Command numbers are part of the legacy format and must never change.
+var wireNumbers = map[Command]int{
+	CommandLobbyExit:         0,
+	CommandLobbyReady:        1,
+	CommandLobbyUnready:      2,
+	CommandLobbyGameStarting: 3,
+	CommandLobbyState:        4,
+	CommandChoice:            5,
+	CommandGameState:         6,
+	CommandNil:               7,
+	CommandRoundTimer:        8,
+	CommandCommit:            9,
+	CommandReveal:            10,
+	CommandLobbyAddBot:       11,
+	CommandQueueStatus:       12,
+	CommandQueueMatched:      13,
+	CommandRoundResult:       14,
+	CommandReply:             15,
+	CommandSession:           16,
+	CommandResync:            17,
+	CommandChat:              18,
+	CommandInvite:            19,
+	CommandLobbyKick:         20,
+	CommandLobbyHost:         21,
+	CommandLobbySettings:     22,
+	CommandRematch:           23,
+	CommandSeries:            24,
+	CommandTournament:        25,
+	CommandTournamentMatch:   26,
+}
+
+var codecs = []Codec{LegacyCodec, JSONCodec}
+
+func TestCommandNumbers(t *testing.T) {
+	commands := Commands()
+	if len(commands) != len(wireNumbers) {
+		t.Fatalf("%d commands registered, %d have a wire number - add new commands to wireNumbers", len(commands), len(wireNumbers))
+	}
+	for _, c := range commands {
+		n, ok := wireNumbers[c]
+		if !ok {
+			t.Errorf("command %v has no wire number", c)
+			continue
+		}
+		if int(c) != n {
+			t.Errorf("command %v is %d, want %d", c, int(c), n)
+		}
+	}
+}
+
+func TestCommandNames(t *testing.T) {
+	for _, c := range Commands() {
+		parsed, ok := ParseCommand(c.String())
+		if !ok || parsed != c {
+			t.Errorf("ParseCommand(%q) = %v, %t, want %v", c.String(), parsed, ok, c)
+		}
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		for _, c := range Commands() {
+			for _, content := range []string{"", "content", "with:colons:in it", "lobby#a_1;b_0"} {
+				msg := CreateCommandMessage(c, content)
+				got := codec.Decode(codec.Encode(*msg))
+				if got.Type != MessageCommand || got.Cmd != c || got.Content != content {
+					t.Errorf("%s: %v %q decoded as type %d, %v %q", codec.Subprotocol(), c, content, got.Type, got.Cmd, got.Content)
+				}
+			}
+		}
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		for _, content := range []string{"0", "Winner: 1", "http://example.com:8080"} {
+			got := codec.Decode(codec.Encode(*CreateTextMessage(content)))
+			if got.Type != MessageText || got.Content != content {
+				t.Errorf("%s: text %q decoded as type %d %q", codec.Subprotocol(), content, got.Type, got.Content)
+			}
+		}
+	}
+}
+
+func TestRequestIdRoundTrip(t *testing.T) {
+	msg := CreateCommandMessage(CommandLobbyReady, "")
+	msg.Id = "42"
+	got := JSONCodec.Decode(JSONCodec.Encode(*msg))
+	if got.Id != "42" {
+		t.Errorf("request id %q, want 42", got.Id)
+	}
+}
+
+func TestCorruptedKeepsRequestId(t *testing.T) {
+	for _, b := range []string{
+		`{"v":1,"type":"command","cmd":"dance","id":"7"}`,
+		`{"v":1,"type":"shout","id":"7"}`,
+		`{"v":1,"type":"text","id":"7","payload":[1]}`,
+		`{"v":99,"type":"text","id":"7"}`,
+	} {
+		got := JSONCodec.Decode([]byte(b))
+		if got.Type != MessageCorrupted || got.Id != "7" {
+			t.Errorf("%s decoded as type %d with id %q, want corrupted with id 7", b, got.Type, got.Id)
+		}
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	payloads := []struct {
+		cmd     Command
+		payload Payload
+		decoded func() interface{}
+	}{
+		{CommandLobbyState, LobbyState{
+			Lobby:   "myLobby",
+			Players: []PlayerState{{ClientId: "a", Ready: true}, {ClientId: "b"}},
+		}, func() interface{} { return &LobbyState{} }},
+		{CommandGameState, GameDetails{
+			Players:   []PlayerScore{{ClientId: "a", Score: 2, Choices: []int{0, 3}}, {ClientId: "b", Score: 1, Choices: []int{2, 1}}},
+			Format:    "first-to-3",
+			Seed:      42,
+			CoinFlips: []CoinFlip{{Round: 1, PlayerA: 0, PlayerB: 1, Winner: 1}},
+			Rounds:    []RoundResult{},
+		}, func() interface{} { return &GameDetails{} }},
+		{CommandRoundResult, RoundResult{
+			Round:   3,
+			Choices: []int{0, 2},
+			Deltas:  []int{1, 0},
+			Winners: []int{0},
+		}, func() interface{} { return &RoundResult{} }},
+		{CommandReply, NewReply(CommandLobbyReady, nil), func() interface{} { return &Reply{} }},
+		{CommandReply, NewReply(CommandChoice, NewError(ErrInvalidChoice, "Invalid choice; try 0-3")), func() interface{} { return &Reply{} }},
+		{CommandChoice, TextPayload{Content: "2"}, func() interface{} { return &TextPayload{} }},
+		{CommandChat, ChatMessage{From: "a", Text: "gg; well played: 3-1", Time: 1700000000123}, func() interface{} { return &ChatMessage{} }},
+		{CommandSeries, Series{Games: 3, Draws: 1, Players: []SeriesScore{{ClientId: "a", Wins: 2}, {ClientId: "b=c", Wins: 0}}}, func() interface{} { return &Series{} }},
+		{CommandTournament, Tournament{
+			Name:      "office",
+			Format:    "swiss",
+			State:     "REGISTERING",
+			Standings: []TournamentStanding{{ClientId: "a"}},
+			Matches:   []TournamentMatch{},
+		}, func() interface{} { return &Tournament{} }},
+		{CommandTournament, Tournament{
+			Name:   "office",
+			Format: "double-elimination",
+			State:  "RUNNING",
+			Round:  2,
+			Standings: []TournamentStanding{
+				{ClientId: "a", Points: 1.5, Wins: 1, Draws: 1},
+				{ClientId: "b=c", Points: 0, Losses: 2, Eliminated: true},
+			},
+			Matches: []TournamentMatch{
+				{Id: 0, Round: 1, Bracket: "winners", Players: []string{"a", "b=c"}, Lobby: "office-m0", Winner: "a", Draws: 1, Done: true},
+				{Id: 1, Round: 2, Players: []string{"a"}, Winner: "a", Done: true},
+			},
+		}, func() interface{} { return &Tournament{} }},
+		{CommandTournamentMatch, TournamentMatch{Id: 3, Round: 2, Bracket: "losers", Players: []string{"a", "b"}, Lobby: "office-m3"}, func() interface{} { return &TournamentMatch{} }},
+		{CommandSession, Session{Lobby: "myLobby", ClientId: "a", Token: "0f3a", Grace: 30000}, func() interface{} { return &Session{} }},
+		{CommandResync, Resync{
+			State: "CREATED",
+			Lobby: LobbyState{Lobby: "myLobby", Players: []PlayerState{{ClientId: "a"}}},
+		}, func() interface{} { return &Resync{} }},
+		{CommandResync, Resync{
+			State:    "IN_GAME",
+			Lobby:    LobbyState{Lobby: "myLobby", Players: []PlayerState{{ClientId: "a", Ready: true}, {ClientId: "b", Ready: true}}},
+			Game:     &GameDetails{Players: []PlayerScore{{ClientId: "a", Score: 1, Choices: []int{0}}, {ClientId: "b", Score: 0, Choices: []int{2}}}, Format: "best-of-3", Seed: 7, CoinFlips: []CoinFlip{}},
+			Round:    2,
+			Input:    true,
+			TimeLeft: 4500,
+		}, func() interface{} { return &Resync{} }},
+	}
+
+	for _, codec := range codecs {
+		for _, p := range payloads {
+			msg := codec.Decode(codec.Encode(*CreatePayloadMessage(p.cmd, p.payload)))
+			if msg.Cmd != p.cmd {
+				t.Errorf("%s: %v decoded as %v", codec.Subprotocol(), p.cmd, msg.Cmd)
+			}
+
+			decoded := p.decoded()
+			if err := msg.DecodePayload(decoded); err != nil {
+				t.Errorf("%s: decoding %v: %v", codec.Subprotocol(), p.cmd, err)
+				continue
+			}
+			got := reflect.ValueOf(decoded).Elem().Interface()
+			want := p.payload
+			if codec == LegacyCodec {
+				// Rounds are only part of the JSON format
+				if d, ok := want.(GameDetails); ok {
+					d.Rounds = nil
+					want = d
+				}
+				// The client knows its own lobby and id
+				if s, ok := want.(Session); ok {
+					s.Lobby, s.ClientId = "", ""
+					want = s
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %v payload\n got %+v\nwant %+v", codec.Subprotocol(), p.cmd, got, want)
+			}
+		}
+	}
+}
+
+// Error codes are matched by clients and must never change.
+var errorCodes = map[ErrorCode]string{
+	ErrBadRequest:       "BAD_REQUEST",
+	ErrUnknownCommand:   "UNKNOWN_COMMAND",
+	ErrMethodNotAllowed: "METHOD_NOT_ALLOWED",
+	ErrNotFound:         "NOT_FOUND",
+	ErrUnauthorized:     "UNAUTHORIZED",
+	ErrInternal:         "INTERNAL",
+	ErrLobbyExists:      "LOBBY_EXISTS",
+	ErrLobbyNotFound:    "LOBBY_NOT_FOUND",
+	ErrLobbyFull:        "LOBBY_FULL",
+	ErrLobbyClosed:      "LOBBY_CLOSED",
+	ErrAlreadyInLobby:   "ALREADY_IN_LOBBY",
+	ErrNotInLobby:       "NOT_IN_LOBBY",
+	ErrInvalidSettings:  "INVALID_SETTINGS",
+	ErrUnknownBot:       "UNKNOWN_BOT",
+	ErrGameStarted:      "GAME_STARTED",
+	ErrInvalidSession:   "INVALID_SESSION",
+	ErrReadOnly:         "READ_ONLY",
+	ErrWrongPassword:    "WRONG_PASSWORD",
+	ErrNotHost:          "NOT_HOST",
+	ErrGameNotFinished:  "GAME_NOT_FINISHED",
+	ErrGameNotRunning:   "GAME_NOT_RUNNING",
+	ErrInvalidChoice:    "INVALID_CHOICE",
+	ErrAlreadyChose:     "ALREADY_CHOSE",
+	ErrCommitRequired:   "COMMIT_REQUIRED",
+	ErrRevealRequired:   "REVEAL_REQUIRED",
+	ErrAlreadyCommitted: "ALREADY_COMMITTED",
+	ErrCommitsPending:   "COMMITS_PENDING",
+	ErrHashMismatch:     "HASH_MISMATCH",
+	ErrRateLimited:      "RATE_LIMITED",
+	ErrMessageTooLong:   "MESSAGE_TOO_LONG",
+	ErrMessageRejected:  "MESSAGE_REJECTED",
+	ErrPlayerNotFound:   "PLAYER_NOT_FOUND",
+	ErrRejected:         "REJECTED",
+}
+
+func TestErrorCodes(t *testing.T) {
+	for code, want := range errorCodes {
+		if string(code) != want {
+			t.Errorf("error code %q, want %q", code, want)
+		}
+
+		err := fmt.Errorf("wrapped: %w", NewError(code, "message"))
+		if CodeOf(err) != code || MessageOf(err) != "message" {
+			t.Errorf("CodeOf/MessageOf(%v) = %q, %q", err, CodeOf(err), MessageOf(err))
+		}
+
+		reply := NewReply(CommandChoice, err)
+		for _, codec := range codecs {
+			msg := codec.Decode(codec.Encode(*CreatePayloadMessage(CommandReply, reply)))
+			var decoded Reply
+			if err := msg.DecodePayload(&decoded); err != nil {
+				t.Fatalf("%s: decoding reply: %v", codec.Subprotocol(), err)
+			}
+			if CodeOf(decoded.Err()) != code {
+				t.Errorf("%s: reply code %q, want %q", codec.Subprotocol(), CodeOf(decoded.Err()), code)
+			}
+		}
+	}
+
+	if CodeOf(errors.New("plain")) != ErrRejected {
+		t.Errorf("errors without a code should be %s", ErrRejected)
+	}
+}
+
+// Clients of other languages compute the same hashes, the format must never change.
+func TestCommitHash(t *testing.T) {
+	tests := []struct {
+		choice int
+		nonce  string
+		want   string
+	}{
+		{1, "nonce", "22a4cd8e4f70c850e0a6826b42a280ac470ee1b5bee37bd8279ad2b9a74ea700"},
+		{3, "k7PX2M", "143a09e75c1f9571bb46da5316bc100a0d8b9d1bb5961ee518fa1cc57e409ab1"},
+	}
+	for _, tt := range tests {
+		if got := CommitHash(tt.choice, tt.nonce); got != tt.want {
+			t.Errorf("CommitHash(%d, %q) = %s, want %s", tt.choice, tt.nonce, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode identifies why a request failed, clients can react to it or show a localised message.
+type ErrorCode string
+
+// Error codes are part of the protocol, existing ones must not be renamed.
+const (
+	ErrBadRequest       ErrorCode = "BAD_REQUEST"     // the request couldn't be parsed
+	ErrUnknownCommand   ErrorCode = "UNKNOWN_COMMAND" // the server doesn't handle the command
+	ErrMethodNotAllowed ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrNotFound         ErrorCode = "NOT_FOUND"    // the server has no such endpoint
+	ErrInternal         ErrorCode = "INTERNAL"     // something went wrong on the server
+	ErrUnauthorized     ErrorCode = "UNAUTHORIZED" // the request has no credentials, e.g. no session token
+
+	// Lobbies
+	ErrLobbyExists     ErrorCode = "LOBBY_EXISTS"
+	ErrLobbyNotFound   ErrorCode = "LOBBY_NOT_FOUND"
+	ErrLobbyFull       ErrorCode = "LOBBY_FULL"
+	ErrLobbyClosed     ErrorCode = "LOBBY_CLOSED" // the lobby was disbanded
+	ErrAlreadyInLobby  ErrorCode = "ALREADY_IN_LOBBY"
+	ErrNotInLobby      ErrorCode = "NOT_IN_LOBBY"
+	ErrInvalidSettings ErrorCode = "INVALID_SETTINGS" // lobby or matchmaking options
+	ErrUnknownBot      ErrorCode = "UNKNOWN_BOT"
+	ErrGameStarted     ErrorCode = "GAME_STARTED"      // the request is only allowed while no game is running
+	ErrInvalidSession  ErrorCode = "INVALID_SESSION"   // the session token doesn't match the player's seat
+	ErrReadOnly        ErrorCode = "READ_ONLY"         // spectators can't send game or lobby commands
+	ErrWrongPassword   ErrorCode = "WRONG_PASSWORD"    // the lobby has a password and it is missing or wrong
+	ErrNotHost         ErrorCode = "NOT_HOST"          // only the lobby's host can send the command
+	ErrGameNotFinished ErrorCode = "GAME_NOT_FINISHED" // rematches can only be voted for after a game
+
+	// Game input
+	ErrGameNotRunning   ErrorCode = "GAME_NOT_RUNNING"
+	ErrInvalidChoice    ErrorCode = "INVALID_CHOICE"
+	ErrAlreadyChose     ErrorCode = "ALREADY_CHOSE"
+	ErrCommitRequired   ErrorCode = "COMMIT_REQUIRED" // commit-reveal games need a commitment first
+	ErrRevealRequired   ErrorCode = "REVEAL_REQUIRED"
+	ErrAlreadyCommitted ErrorCode = "ALREADY_COMMITTED"
+	ErrCommitsPending   ErrorCode = "COMMITS_PENDING" // reveals are only accepted once all players committed
+	ErrHashMismatch     ErrorCode = "HASH_MISMATCH"   // the revealed choice doesn't match the commitment
+
+	// Chat
+	ErrRateLimited     ErrorCode = "RATE_LIMITED" // too many messages, try again later
+	ErrMessageTooLong  ErrorCode = "MESSAGE_TOO_LONG"
+	ErrMessageRejected ErrorCode = "MESSAGE_REJECTED" // refused by the server's chat filter
+
+	// Players
+	ErrPlayerNotFound ErrorCode = "PLAYER_NOT_FOUND"
+
+	// Tournaments
+	ErrTournamentExists   ErrorCode = "TOURNAMENT_EXISTS"
+	ErrTournamentNotFound ErrorCode = "TOURNAMENT_NOT_FOUND"
+	ErrTournamentStarted  ErrorCode = "TOURNAMENT_STARTED" // registration is closed
+	ErrTournamentFull     ErrorCode = "TOURNAMENT_FULL"
+	ErrAlreadyRegistered  ErrorCode = "ALREADY_REGISTERED"
+	ErrTooFewPlayers      ErrorCode = "TOO_FEW_PLAYERS" // a tournament needs at least 2 players to start
+	ErrNotOrganizer       ErrorCode = "NOT_ORGANIZER"   // only the player who created the tournament can start it
+	ErrNotInMatch         ErrorCode = "NOT_IN_MATCH"    // lobbies of tournament matches are reserved for the match's players
+
+	ErrRejected ErrorCode = "REJECTED" // any other failure, see the error message
+)
+
+// Error is a failed request with its error code.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message,omitempty"`
+}
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf creates an error with a formatted message.
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return NewError(code, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// CodeOf returns the error code of err, ErrRejected if it has none.
+func CodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ErrRejected
+}
+
+// MessageOf returns the human readable message of err without the error code.
+func MessageOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return err.Error()
+}
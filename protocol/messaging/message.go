@@ -1,6 +1,7 @@
 package messaging
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -30,6 +31,28 @@ const (
 	CommandGameState
 
 	CommandNil
+
+	// New commands are added below CommandNil so existing command numbers don't change
+
+	CommandRoundTimer      // content: remaining time to make a choice in milliseconds
+	CommandCommit          // client: commitment hash of the choice, server: OK
+	CommandReveal          // client: "<choice> <nonce>", server: all players committed, reveal now
+	CommandLobbyAddBot     // client: bot strategy name (empty for default), server: OK or error
+	CommandQueueStatus     // matchmaking: "<position>,<estimated wait in milliseconds>"
+	CommandQueueMatched    // matchmaking: opponent found, content is the lobby name
+	CommandRoundResult     // payload: RoundResult
+	CommandReply           // payload: Reply, answers the request with the same id (JSON format only)
+	CommandSession         // payload: Session, sent after joining a lobby
+	CommandResync          // payload: Resync, sent after rejoining a lobby
+	CommandChat            // client: the text to send, server: payload ChatMessage
+	CommandInvite          // content: the lobby's invite code, sent after joining a lobby
+	CommandLobbyKick       // host: client id of the player to remove from the lobby
+	CommandLobbyHost       // host: client id of the new host, server: client id of the current host
+	CommandLobbySettings   // host: settings to change as a query string, server: all settings, e.g. "rules=classic&target=3"
+	CommandRematch         // client: vote for another game after the game ended, "swap" to also change seats
+	CommandSeries          // payload: Series, sent after every game
+	CommandTournament      // payload: Tournament, sent on the tournament feed whenever the bracket changes
+	CommandTournamentMatch // payload: TournamentMatch, sent on the tournament feed when a match's lobby is open
 )
 
 //var commandList = []int{CommandLobbyExit, CommandLobbyReady, CommandLobbyUnready, CommandNil}
@@ -38,6 +61,13 @@ type Message struct {
 	Type    MessageType
 	Cmd     Command
 	Content string
+	// Id is an optional request id, only sent in the JSON format
+	Id string
+	// Payload is the typed content of the message, see CreatePayloadMessage
+	Payload Payload
+
+	// raw is the payload object of a received JSON message, see DecodePayload
+	raw json.RawMessage
 }
 
 func (msg *Message) Parse() []byte {
@@ -77,7 +107,8 @@ func CreateCommandMessage(cmd Command, s string) *Message {
 
 func ToMessage(b []byte) Message {
 	s := string(b)
-	parts := strings.Split(s, TERMINATOR)
+	// Content may contain the terminator
+	parts := strings.SplitN(s, TERMINATOR, 3)
 	var mType MessageType = MessageCorrupted
 	var cmd Command = CommandNil
 	var content string = ""
@@ -110,9 +141,9 @@ func ToMessage(b []byte) Message {
 	}
 
 	return Message{
-		mType,
-		cmd,
-		content,
+		Type:    mType,
+		Cmd:     cmd,
+		Content: content,
 	}
 
 }
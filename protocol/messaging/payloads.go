@@ -0,0 +1,596 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Payload is the typed content of a message. In the JSON format it is sent as the payload object,
+// in the legacy format as the string returned by LegacyString.
+type Payload interface {
+	LegacyString() string
+}
+
+// legacyParser is implemented by payloads that can be read from the legacy format.
+type legacyParser interface {
+	ParseLegacy(s string) error
+}
+
+// CreatePayloadMessage creates a command message with a typed payload.
+func CreatePayloadMessage(cmd Command, p Payload) *Message {
+	return &Message{
+		Type:    MessageCommand,
+		Cmd:     cmd,
+		Content: p.LegacyString(),
+		Payload: p,
+	}
+}
+
+// DecodePayload decodes the message's payload into v, from the payload object of a JSON message
+// or from the content of a legacy message.
+func (msg *Message) DecodePayload(v interface{}) error {
+	if len(msg.raw) > 0 {
+		return json.Unmarshal(msg.raw, v)
+	}
+	if p, ok := v.(legacyParser); ok {
+		return p.ParseLegacy(msg.Content)
+	}
+	return fmt.Errorf("can't decode %T from legacy content", v)
+}
+
+// Reply statuses
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Reply is sent with CommandReply to answer a request. The reply message has the request's id.
+type Reply struct {
+	Command Command   `json:"cmd"`
+	Status  string    `json:"status"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// NewReply returns the reply to a request for cmd, an error reply if err is set.
+func NewReply(cmd Command, err error) Reply {
+	if err == nil {
+		return Reply{Command: cmd, Status: StatusOK}
+	}
+	return Reply{Command: cmd, Status: StatusError, Code: CodeOf(err), Message: MessageOf(err)}
+}
+
+// Err returns the reply's error, nil if the request succeeded.
+func (r Reply) Err() error {
+	if r.Status == StatusOK {
+		return nil
+	}
+	return NewError(r.Code, r.Message)
+}
+
+// LegacyString returns the reply as `<cmd>;<status>;<code>;<message>`.
+func (r Reply) LegacyString() string {
+	return fmt.Sprintf("%d;%s;%s;%s", r.Command, r.Status, r.Code, r.Message)
+}
+
+func (r *Reply) ParseLegacy(str string) error {
+	parts := strings.SplitN(str, ";", 4)
+	if len(parts) != 4 {
+		return errors.New("invalid reply")
+	}
+	cmd, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid reply command '%s'", parts[0])
+	}
+	*r = Reply{Command: Command(cmd), Status: parts[1], Code: ErrorCode(parts[2]), Message: parts[3]}
+	return nil
+}
+
+// PlayerState is a player in the lobby.
+type PlayerState struct {
+	ClientId string `json:"clientId"`
+	Ready    bool   `json:"ready"`
+	Bot      bool   `json:"bot,omitempty"`
+	Host     bool   `json:"host,omitempty"` // only in the JSON format, legacy clients get CommandLobbyHost
+}
+
+// LobbyState is sent with CommandLobbyState whenever a player joins, leaves or changes their ready flag.
+type LobbyState struct {
+	Lobby   string        `json:"lobby"`
+	Players []PlayerState `json:"players"`
+}
+
+// LegacyString returns the state as `lobby#player_1;player_0`, 1 if the player is ready.
+func (s LobbyState) LegacyString() string {
+	str := s.Lobby + "#"
+	for i, p := range s.Players {
+		if i > 0 {
+			str += ";"
+		}
+		ready := "0"
+		if p.Ready {
+			ready = "1"
+		}
+		str += p.ClientId + "_" + ready
+	}
+	return str
+}
+
+func (s *LobbyState) ParseLegacy(str string) error {
+	lobby, players, ok := strings.Cut(str, "#")
+	if !ok {
+		return errors.New("invalid lobby state")
+	}
+	s.Lobby = lobby
+	s.Players = []PlayerState{}
+	if players == "" {
+		return nil
+	}
+	for _, p := range strings.Split(players, ";") {
+		i := strings.LastIndex(p, "_")
+		if i < 0 {
+			return fmt.Errorf("invalid player state '%s'", p)
+		}
+		s.Players = append(s.Players, PlayerState{ClientId: p[:i], Ready: p[i+1:] == "1"})
+	}
+	return nil
+}
+
+// CoinFlip is a tie between two players that was broken by flipping a coin.
+type CoinFlip struct {
+	Round   int `json:"round"`
+	PlayerA int `json:"playerA"`
+	PlayerB int `json:"playerB"`
+	Winner  int `json:"winner"`
+}
+
+// RoundResult is sent with CommandRoundResult after every round.
+type RoundResult struct {
+	Round     int        `json:"round"`
+	Choices   []int      `json:"choices"`
+	Deltas    []int      `json:"deltas"`
+	Winners   []int      `json:"winners"`
+	CoinFlips []CoinFlip `json:"coinFlips,omitempty"`
+}
+
+// LegacyString returns the result as `round=1;choices=0,2;deltas=1,0;winners=0`.
+func (r RoundResult) LegacyString() string {
+	return fmt.Sprintf("round=%d;choices=%s;deltas=%s;winners=%s", r.Round, joinInts(r.Choices), joinInts(r.Deltas), joinInts(r.Winners))
+}
+
+func (r *RoundResult) ParseLegacy(str string) error {
+	*r = RoundResult{}
+	for _, field := range strings.Split(str, ";") {
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "round":
+			r.Round, err = strconv.Atoi(value)
+		case "choices":
+			r.Choices, err = splitInts(value)
+		case "deltas":
+			r.Deltas, err = splitInts(value)
+		case "winners":
+			r.Winners, err = splitInts(value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid round result field '%s': %v", field, err)
+		}
+	}
+	return nil
+}
+
+// PlayerScore is a player's score and choices in the game.
+type PlayerScore struct {
+	ClientId string `json:"clientId"`
+	Score    int    `json:"score"`
+	Choices  []int  `json:"choices"`
+}
+
+// GameDetails is sent with CommandGameState.
+type GameDetails struct {
+	Players   []PlayerScore `json:"players"`
+	Format    string        `json:"format"`
+	Seed      int64         `json:"seed"`
+	CoinFlips []CoinFlip    `json:"coinFlips"`
+	Rounds    []RoundResult `json:"rounds"`
+}
+
+// LegacyString returns the details as `id=[score,c1,c2];...;format=<match>;seed=<n>;coinflips=[r-a-b-w,...]`.
+// Rounds are only part of the JSON format.
+func (d GameDetails) LegacyString() string {
+	str := ""
+	for _, p := range d.Players {
+		str += p.ClientId + "=[" + strconv.Itoa(p.Score)
+		for _, c := range p.Choices {
+			str += "," + strconv.Itoa(c)
+		}
+		str += "];"
+	}
+	str += "format=" + d.Format
+	str += ";seed=" + strconv.FormatInt(d.Seed, 10)
+	str += ";coinflips=["
+	for i, f := range d.CoinFlips {
+		if i > 0 {
+			str += ","
+		}
+		str += fmt.Sprintf("%d-%d-%d-%d", f.Round, f.PlayerA, f.PlayerB, f.Winner)
+	}
+	str += "]"
+	return str
+}
+
+func (d *GameDetails) ParseLegacy(str string) error {
+	*d = GameDetails{Players: []PlayerScore{}, CoinFlips: []CoinFlip{}}
+	for _, field := range strings.Split(str, ";") {
+		i := strings.LastIndex(field, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid game details field '%s'", field)
+		}
+		key, value := field[:i], field[i+1:]
+
+		switch key {
+		case "format":
+			d.Format = value
+		case "seed":
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid seed '%s'", value)
+			}
+			d.Seed = seed
+		case "coinflips":
+			list := strings.Trim(value, "[]")
+			if list == "" {
+				continue
+			}
+			for _, f := range strings.Split(list, ",") {
+				var flip CoinFlip
+				if _, err := fmt.Sscanf(f, "%d-%d-%d-%d", &flip.Round, &flip.PlayerA, &flip.PlayerB, &flip.Winner); err != nil {
+					return fmt.Errorf("invalid coin flip '%s'", f)
+				}
+				d.CoinFlips = append(d.CoinFlips, flip)
+			}
+		default:
+			values, err := splitInts(strings.Trim(value, "[]"))
+			if err != nil || len(values) == 0 {
+				return fmt.Errorf("invalid player '%s'", field)
+			}
+			d.Players = append(d.Players, PlayerScore{ClientId: key, Score: values[0], Choices: values[1:]})
+		}
+	}
+	return nil
+}
+
+// ChatMessage is sent with CommandChat to everyone in the lobby.
+type ChatMessage struct {
+	From string `json:"from"`
+	Text string `json:"text"`
+	Time int64  `json:"time"` // unix time in milliseconds
+}
+
+// LegacyString returns the message as `<time>;<from>;<text>`.
+func (m ChatMessage) LegacyString() string {
+	return strconv.FormatInt(m.Time, 10) + ";" + m.From + ";" + m.Text
+}
+
+func (m *ChatMessage) ParseLegacy(str string) error {
+	parts := strings.SplitN(str, ";", 3)
+	if len(parts) != 3 {
+		return errors.New("invalid chat message")
+	}
+	t, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat message time '%s'", parts[0])
+	}
+	*m = ChatMessage{Time: t, From: parts[1], Text: parts[2]}
+	return nil
+}
+
+// Session is sent with CommandSession after joining a lobby. A client whose connection dropped
+// can take its seat back with the token until the grace period runs out, see Resync.
+type Session struct {
+	Lobby    string `json:"lobby"`
+	ClientId string `json:"clientId"`
+	Token    string `json:"token"`
+	Grace    int64  `json:"grace"` // grace period in milliseconds
+}
+
+// LegacyString returns the session as `<token>;<grace>`, the client knows its lobby and id.
+func (s Session) LegacyString() string {
+	return s.Token + ";" + strconv.FormatInt(s.Grace, 10)
+}
+
+func (s *Session) ParseLegacy(str string) error {
+	token, grace, ok := strings.Cut(str, ";")
+	if !ok {
+		return errors.New("invalid session")
+	}
+	g, err := strconv.ParseInt(grace, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid grace period '%s'", grace)
+	}
+	*s = Session{Token: token, Grace: g}
+	return nil
+}
+
+// Resync is sent with CommandResync after rejoining a lobby, it holds everything a client needs to continue.
+type Resync struct {
+	State    string       `json:"state"` // lobby state, e.g. IN_GAME
+	Lobby    LobbyState   `json:"lobby"`
+	Game     *GameDetails `json:"game,omitempty"`     // nil before the game started
+	Round    int          `json:"round"`              // current round, counted from 0 like RoundResult.Round
+	Input    bool         `json:"input"`              // the player still has to make a choice (or commit) this round
+	Reveal   bool         `json:"reveal"`             // the player still has to reveal their choice this round
+	TimeLeft int64        `json:"timeLeft,omitempty"` // remaining time of the round in milliseconds, 0 if there is no timer
+}
+
+// LegacyString returns the lines `<lobby state>`, `state=<state>;round=<n>;input=<0/1>;reveal=<0/1>;timeleft=<ms>`
+// and the game details if a game is running.
+func (r Resync) LegacyString() string {
+	str := r.Lobby.LegacyString() + "\n"
+	str += fmt.Sprintf("state=%s;round=%d;input=%s;reveal=%s;timeleft=%d", r.State, r.Round, legacyBool(r.Input), legacyBool(r.Reveal), r.TimeLeft)
+	if r.Game != nil {
+		str += "\n" + r.Game.LegacyString()
+	}
+	return str
+}
+
+func (r *Resync) ParseLegacy(str string) error {
+	*r = Resync{}
+	lines := strings.SplitN(str, "\n", 3)
+	if len(lines) < 2 {
+		return errors.New("invalid resync")
+	}
+	if err := r.Lobby.ParseLegacy(lines[0]); err != nil {
+		return err
+	}
+	for _, field := range strings.Split(lines[1], ";") {
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "state":
+			r.State = value
+		case "round":
+			r.Round, err = strconv.Atoi(value)
+		case "input":
+			r.Input = value == "1"
+		case "reveal":
+			r.Reveal = value == "1"
+		case "timeleft":
+			r.TimeLeft, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid resync field '%s': %v", field, err)
+		}
+	}
+	if len(lines) == 3 {
+		r.Game = &GameDetails{}
+		return r.Game.ParseLegacy(lines[2])
+	}
+	return nil
+}
+
+// Series is sent with CommandSeries after every game, it counts the games the same players played in the lobby.
+type Series struct {
+	Games   int           `json:"games"`
+	Draws   int           `json:"draws"`
+	Players []SeriesScore `json:"players"`
+}
+
+type SeriesScore struct {
+	ClientId string `json:"clientId"`
+	Wins     int    `json:"wins"`
+}
+
+// LegacyString returns the series as `games=<n>;draws=<n>;<clientId>=<wins>;...`.
+func (s Series) LegacyString() string {
+	str := fmt.Sprintf("games=%d;draws=%d", s.Games, s.Draws)
+	for _, p := range s.Players {
+		str += ";" + p.ClientId + "=" + strconv.Itoa(p.Wins)
+	}
+	return str
+}
+
+func (s *Series) ParseLegacy(str string) error {
+	*s = Series{Players: []SeriesScore{}}
+	for i, field := range strings.Split(str, ";") {
+		idx := strings.LastIndex(field, "=")
+		if idx < 0 {
+			return fmt.Errorf("invalid series field '%s'", field)
+		}
+		key, value := field[:idx], field[idx+1:]
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid series field '%s'", field)
+		}
+		switch {
+		case i == 0 && key == "games":
+			s.Games = n
+		case i == 1 && key == "draws":
+			s.Draws = n
+		case i > 1:
+			s.Players = append(s.Players, SeriesScore{ClientId: key, Wins: n})
+		default:
+			return fmt.Errorf("invalid series field '%s'", field)
+		}
+	}
+	return nil
+}
+
+// Tournament is sent with CommandTournament on the tournament feed whenever the bracket changes.
+type Tournament struct {
+	Name      string               `json:"name"`
+	Format    string               `json:"format"`           // single-elimination, double-elimination, swiss or round-robin
+	State     string               `json:"state"`            // REGISTERING, RUNNING or FINISHED
+	Round     int                  `json:"round"`            // current round counted from 1, 0 during registration
+	Rounds    int                  `json:"rounds,omitempty"` // planned rounds of swiss and round-robin tournaments
+	Winner    string               `json:"winner,omitempty"`
+	Standings []TournamentStanding `json:"standings"` // ranked, in seed order during registration
+	Matches   []TournamentMatch    `json:"matches"`
+}
+
+// TournamentStanding is a player's record in the tournament.
+type TournamentStanding struct {
+	ClientId   string  `json:"clientId"`
+	Points     float64 `json:"points"` // 1 per win or bye, 0.5 per draw
+	Wins       int     `json:"wins"`
+	Losses     int     `json:"losses"`
+	Draws      int     `json:"draws"`
+	Eliminated bool    `json:"eliminated,omitempty"`
+}
+
+// TournamentMatch is a match of the tournament, a bye has a single player. It is sent with CommandTournamentMatch
+// once the match's lobby is open, the players join it like any other lobby.
+type TournamentMatch struct {
+	Id      int      `json:"id"`
+	Round   int      `json:"round"`
+	Bracket string   `json:"bracket,omitempty"` // winners, losers or final in double elimination tournaments
+	Players []string `json:"players"`
+	Lobby   string   `json:"lobby,omitempty"`
+	Winner  string   `json:"winner,omitempty"` // empty for a draw and while the match runs
+	Draws   int      `json:"draws,omitempty"`  // drawn games of an elimination match, it is played until someone wins
+	Done    bool     `json:"done"`
+}
+
+// LegacyString returns the lines `name=<name>;format=<format>;state=<state>;round=<n>;rounds=<n>;winner=<clientId>`,
+// `<clientId>=<points>,<wins>,<losses>,<draws>,<eliminated 0/1>;...` and one line per match.
+func (t Tournament) LegacyString() string {
+	str := fmt.Sprintf("name=%s;format=%s;state=%s;round=%d;rounds=%d;winner=%s\n", t.Name, t.Format, t.State, t.Round, t.Rounds, t.Winner)
+	for i, s := range t.Standings {
+		if i > 0 {
+			str += ";"
+		}
+		str += fmt.Sprintf("%s=%s,%d,%d,%d,%s", s.ClientId, strconv.FormatFloat(s.Points, 'f', -1, 64), s.Wins, s.Losses, s.Draws, legacyBool(s.Eliminated))
+	}
+	for _, m := range t.Matches {
+		str += "\n" + m.LegacyString()
+	}
+	return str
+}
+
+func (t *Tournament) ParseLegacy(str string) error {
+	*t = Tournament{Standings: []TournamentStanding{}, Matches: []TournamentMatch{}}
+	lines := strings.Split(str, "\n")
+	if len(lines) < 2 {
+		return errors.New("invalid tournament")
+	}
+	for _, field := range strings.Split(lines[0], ";") {
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "name":
+			t.Name = value
+		case "format":
+			t.Format = value
+		case "state":
+			t.State = value
+		case "round":
+			t.Round, err = strconv.Atoi(value)
+		case "rounds":
+			t.Rounds, err = strconv.Atoi(value)
+		case "winner":
+			t.Winner = value
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tournament field '%s': %v", field, err)
+		}
+	}
+
+	if lines[1] != "" {
+		for _, field := range strings.Split(lines[1], ";") {
+			i := strings.LastIndex(field, "=")
+			if i < 0 {
+				return fmt.Errorf("invalid standing '%s'", field)
+			}
+			values := strings.Split(field[i+1:], ",")
+			if len(values) != 5 {
+				return fmt.Errorf("invalid standing '%s'", field)
+			}
+			points, err := strconv.ParseFloat(values[0], 64)
+			if err != nil {
+				return fmt.Errorf("invalid standing '%s'", field)
+			}
+			record, err := splitInts(strings.Join(values[1:4], ","))
+			if err != nil {
+				return fmt.Errorf("invalid standing '%s'", field)
+			}
+			t.Standings = append(t.Standings, TournamentStanding{
+				ClientId:   field[:i],
+				Points:     points,
+				Wins:       record[0],
+				Losses:     record[1],
+				Draws:      record[2],
+				Eliminated: values[4] == "1",
+			})
+		}
+	}
+
+	for _, line := range lines[2:] {
+		var m TournamentMatch
+		if err := m.ParseLegacy(line); err != nil {
+			return err
+		}
+		t.Matches = append(t.Matches, m)
+	}
+	return nil
+}
+
+// LegacyString returns the match as `<id>;<round>;<bracket>;<player>,<player>;<lobby>;<winner>;<draws>;<done 0/1>`.
+func (m TournamentMatch) LegacyString() string {
+	return fmt.Sprintf("%d;%d;%s;%s;%s;%s;%d;%s", m.Id, m.Round, m.Bracket, strings.Join(m.Players, ","), m.Lobby, m.Winner, m.Draws, legacyBool(m.Done))
+}
+
+func (m *TournamentMatch) ParseLegacy(str string) error {
+	parts := strings.Split(str, ";")
+	if len(parts) != 8 {
+		return fmt.Errorf("invalid tournament match '%s'", str)
+	}
+	values, err := splitInts(parts[0] + "," + parts[1] + "," + parts[6])
+	if err != nil {
+		return fmt.Errorf("invalid tournament match '%s'", str)
+	}
+	*m = TournamentMatch{
+		Id:      values[0],
+		Round:   values[1],
+		Bracket: parts[2],
+		Players: strings.Split(parts[3], ","),
+		Lobby:   parts[4],
+		Winner:  parts[5],
+		Draws:   values[2],
+		Done:    parts[7] == "1",
+	}
+	return nil
+}
+
+func legacyBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitInts(s string) ([]int, error) {
+	values := []int{}
+	if s == "" {
+		return values, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
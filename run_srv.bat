@@ -1,2 +1,2 @@
 cd server
-go run main.go .\server.go .\lobby.go localhost:8080
\ No newline at end of file
+go run . localhost:8080
\ No newline at end of file
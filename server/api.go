@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+)
+
+// The REST API answers with JSON only, including errors. The older endpoints stay for the Unity client.
+const apiPrefix = "/api/v1"
+
+// Lobbies per page of GET /api/v1/lobbies
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// apiNotFound answers requests to unknown API endpoints.
+func (cs *gameServer) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, messaging.Errorf(messaging.ErrNotFound, "no endpoint %s %s", r.Method, r.URL.Path))
+}
+
+// methodNotAllowed answers with the methods the endpoint supports.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, messaging.Errorf(messaging.ErrMethodNotAllowed, "use %s", strings.Join(allowed, " or ")))
+}
+
+// apiLobbies serves /api/v1/lobbies: GET lists the lobbies, POST creates one.
+func (cs *gameServer) apiLobbies(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cs.apiListLobbies(w, r)
+	case http.MethodPost:
+		cs.apiCreateLobby(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// apiLobby serves /api/v1/lobbies/{name}: GET returns the lobby, DELETE closes it.
+func (cs *gameServer) apiLobby(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cs.apiGetLobby(w, r)
+	case http.MethodDelete:
+		cs.apiDeleteLobby(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// apiListLobbies returns a page of the listed lobbies, private ones are left out.
+// Filters: ?state=CREATED&rules=classic, pages: ?offset=0&limit=20
+func (cs *gameServer) apiListLobbies(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	state := q.Get("state")
+	switch state {
+	case "", LobbyCreated, LobbyStarting, LobbyInGame, LobbyFinished:
+	default:
+		writeError(w, messaging.Errorf(messaging.ErrBadRequest, "unknown lobby state '%s'", state))
+		return
+	}
+	rules := q.Get("rules")
+	if _, ok := game.GetRuleSet(rules); rules != "" && !ok {
+		writeError(w, messaging.Errorf(messaging.ErrBadRequest, "unknown rule set '%s'", rules))
+		return
+	}
+	offset, err := queryInt(q, "offset", 0, 0, -1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	limit, err := queryInt(q, "limit", defaultPageLimit, 1, maxPageLimit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	lobbies := []messaging.LobbyInfo{}
+	for _, l := range cs.lobbies.List() {
+		info, ok := l.info()
+		if !ok || info.Private || (state != "" && info.State != state) || (rules != "" && info.Rules != rules) {
+			continue
+		}
+		lobbies = append(lobbies, info)
+	}
+
+	page := messaging.LobbyList{Lobbies: []messaging.LobbyInfo{}, Total: len(lobbies), Offset: offset, Limit: limit}
+	if offset < len(lobbies) {
+		page.Lobbies = lobbies[offset:min(offset+limit, len(lobbies))]
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// queryInt reads an integer query value between min and max (no upper bound if max < 0), def if it is missing.
+func queryInt(q url.Values, key string, def int, min int, max int) (int, error) {
+	s := q.Get(key)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || (max >= 0 && n > max) {
+		return 0, messaging.Errorf(messaging.ErrBadRequest, "invalid %s '%s'", key, s)
+	}
+	return n, nil
+}
+
+func (cs *gameServer) apiGetLobby(w http.ResponseWriter, r *http.Request) {
+	lobby := cs.lobbies.Get(r.PathValue("name"))
+	if lobby == nil {
+		writeError(w, ErrLobbyNotFound)
+		return
+	}
+	info, ok := lobby.info()
+	if !ok {
+		writeError(w, ErrLobbyNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// apiCreateLobby creates a lobby from a CreateLobby body, its creator is the host. Players join it with
+// /joinLobby/{name}/{clientId}. The response holds the lobby's invite code and the host's session token.
+func (cs *gameServer) apiCreateLobby(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8192))
+	dec.DisallowUnknownFields()
+	var req messaging.CreateLobby
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, messaging.Errorf(messaging.ErrBadRequest, "invalid lobby: %v", err))
+		return
+	}
+	if req.Name == "" || strings.Contains(req.Name, "/") {
+		writeError(w, messaging.Errorf(messaging.ErrBadRequest, "invalid lobby name '%s'", req.Name))
+		return
+	}
+	if req.ClientId == "" || strings.Contains(req.ClientId, "/") {
+		writeError(w, messaging.Errorf(messaging.ErrBadRequest, "invalid clientId '%s'", req.ClientId))
+		return
+	}
+
+	settings, err := parseLobbySettings(createLobbyQuery(req))
+	if err != nil {
+		log.Printf("Lobby creation failed - %v", err)
+		writeError(w, messaging.NewError(messaging.ErrInvalidSettings, err.Error()))
+		return
+	}
+	lobby, token, err := cs.createHostedLobby(req.Name, settings, req.ClientId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, ok := lobby.info()
+	if !ok {
+		writeError(w, ErrLobbyClosed)
+		return
+	}
+	info.Invite = lobby.invite
+	info.Token = token
+	w.Header().Set("Location", apiPrefix+"/lobbies/"+url.PathEscape(req.Name))
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// createLobbyQuery converts the body to the query string lobbies are created with, so both are validated the same way.
+func createLobbyQuery(req messaging.CreateLobby) url.Values {
+	q := url.Values{}
+	set := func(key string, value string, ok bool) {
+		if ok {
+			q.Set(key, value)
+		}
+	}
+	set("rules", req.Rules, req.Rules != "")
+	set("players", strconv.Itoa(req.Players), req.Players != 0)
+	set("scoring", req.Scoring, req.Scoring != "")
+	set("format", req.Format, req.Format != "")
+	set("target", strconv.Itoa(req.Target), req.Target != 0)
+	set("maxRounds", strconv.Itoa(req.MaxRounds), req.MaxRounds != 0)
+	set("suddenDeath", "true", req.SuddenDeath)
+	set("timeout", strconv.Itoa(req.Timeout), req.Timeout != 0)
+	set("onTimeout", req.OnTimeout, req.OnTimeout != "")
+	set("commitReveal", "true", req.CommitReveal)
+	set("private", "true", req.Private)
+	set("password", req.Password, req.Password != "")
+	return q
+}
+
+// apiDeleteLobby closes the lobby: DELETE /api/v1/lobbies/{name} with the host's session token in an
+// Authorization: Bearer {token} header.
+func (cs *gameServer) apiDeleteLobby(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, messaging.NewError(messaging.ErrUnauthorized, "missing session token"))
+		return
+	}
+	lobby := cs.lobbies.Get(r.PathValue("name"))
+	if lobby == nil {
+		writeError(w, ErrLobbyNotFound)
+		return
+	}
+	if err := lobby.closeLobby(token); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// info returns the lobby for the REST API, false if the lobby is closed.
+func (l *Lobby) info() (messaging.LobbyInfo, bool) {
+	var info messaging.LobbyInfo
+	ok := l.do(func() {
+		info = messaging.LobbyInfo{
+			Name:       l.id,
+			State:      l.state,
+			Players:    l.lobbyState().Players,
+			MaxPlayers: l.maxPlayers,
+			Spectators: len(l.spectators),
+			Rules:      l.rules.Name,
+			Format:     l.match.String(),
+			Private:    l.private,
+			Locked:     l.password != "",
+			Host:       l.host,
+		}
+		if l.tournament != nil {
+			info.Tournament = l.tournament.t.name
+		}
+	})
+	return info, ok
+}
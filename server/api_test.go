@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// apiRequest sends the request to the server and decodes the JSON answer into v, if v isn't nil.
+func apiRequest(t *testing.T, cs *gameServer, method string, target string, body string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cs.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: invalid JSON: %v", method, target, err)
+		}
+	}
+	return rec
+}
+
+func wantError(t *testing.T, cs *gameServer, method string, target string, body string, status int, code messaging.ErrorCode) *httptest.ResponseRecorder {
+	t.Helper()
+	var e messaging.Error
+	rec := apiRequest(t, cs, method, target, body, &e)
+	if rec.Code != status || e.Code != code {
+		t.Errorf("%s %s = %d %s, want %d %s", method, target, rec.Code, e.Code, status, code)
+	}
+	return rec
+}
+
+func TestAPICreateLobby(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+
+	var info messaging.LobbyInfo
+	rec := apiRequest(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"a","clientId":"x","rules":"classic","format":"first-to","target":2}`, &info)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST = %d, want 201", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/v1/lobbies/a" {
+		t.Errorf("Location %q, want /api/v1/lobbies/a", loc)
+	}
+	if info.Name != "a" || info.State != LobbyCreated || info.Host != "x" || info.Invite == "" || info.Token == "" {
+		t.Errorf("created %+v, want lobby a hosted by x with an invite code and a token", info)
+	}
+
+	wantError(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"a","clientId":"x"}`, http.StatusConflict, messaging.ErrLobbyExists)
+	wantError(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"b","clientId":"x","colour":"red"}`, http.StatusBadRequest, messaging.ErrBadRequest)
+	wantError(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"b","clientId":"x","players":1}`, http.StatusBadRequest, messaging.ErrInvalidSettings)
+	wantError(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"b","clientId":"x","scoring":"minority"}`, http.StatusBadRequest, messaging.ErrInvalidSettings)
+	wantError(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"b"}`, http.StatusBadRequest, messaging.ErrBadRequest)
+	wantError(t, cs, http.MethodPost, "/api/v1/lobbies", `{"clientId":"x"}`, http.StatusBadRequest, messaging.ErrBadRequest)
+
+	var got messaging.LobbyInfo
+	if rec := apiRequest(t, cs, http.MethodGet, "/api/v1/lobbies/a", "", &got); rec.Code != http.StatusOK || got.Name != "a" || got.Invite != "" || got.Token != "" {
+		t.Errorf("GET = %d %+v, want lobby a without the invite code and token", rec.Code, got)
+	}
+}
+
+func TestAPICreatorJoins(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	var info messaging.LobbyInfo
+	apiRequest(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"a","clientId":"x","players":2}`, &info)
+	lobby := cs.lobbies.Get("a")
+
+	// The last seat is kept for the host
+	if err := lobby.addPlayer(Player{clientId: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lobby.addPlayer(Player{clientId: "z"}); err != ErrLobbyFull {
+		t.Errorf("joining the host's seat: %v, want ErrLobbyFull", err)
+	}
+	if err := lobby.addPlayer(Player{clientId: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var token string
+	lobby.do(func() { token = lobby.findPlayer("x").token })
+	if token != info.Token {
+		t.Errorf("host joined with token %s, want %s from the lobby creation", token, info.Token)
+	}
+}
+
+func TestAPICreatorDoesNotJoin(t *testing.T) {
+	cs := newGameServer(serverOptions{idleTimeout: 20 * time.Millisecond})
+	apiRequest(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"empty","clientId":"x"}`, nil)
+	apiRequest(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"joined","clientId":"x"}`, nil)
+	if err := cs.lobbies.Get("joined").addPlayer(Player{clientId: "y"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cs.lobbies.Get("empty") != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cs.lobbies.Get("empty") != nil {
+		t.Fatal("lobby nobody joined wasn't closed")
+	}
+	var info messaging.LobbyInfo
+	if apiRequest(t, cs, http.MethodGet, "/api/v1/lobbies/joined", "", &info); info.Host != "y" {
+		t.Errorf("host %s, want y after the creator didn't join", info.Host)
+	}
+}
+
+func TestAPIListLobbies(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	for _, body := range []string{`{"name":"a","clientId":"x"}`, `{"name":"b","clientId":"x","rules":"classic"}`, `{"name":"c","clientId":"x"}`, `{"name":"d","clientId":"x","private":true}`} {
+		if rec := apiRequest(t, cs, http.MethodPost, "/api/v1/lobbies", body, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s = %d", body, rec.Code)
+		}
+	}
+
+	var list messaging.LobbyList
+	apiRequest(t, cs, http.MethodGet, "/api/v1/lobbies?rules=joker&limit=1&offset=1", "", &list)
+	if list.Total != 2 || len(list.Lobbies) != 1 || list.Limit != 1 || list.Offset != 1 {
+		t.Errorf("page %+v, want the second of 2 joker lobbies", list)
+	}
+
+	apiRequest(t, cs, http.MethodGet, "/api/v1/lobbies?state=IN_GAME", "", &list)
+	if list.Total != 0 || list.Lobbies == nil {
+		t.Errorf("page %+v, want an empty list", list)
+	}
+
+	wantError(t, cs, http.MethodGet, "/api/v1/lobbies?state=OPEN", "", http.StatusBadRequest, messaging.ErrBadRequest)
+	wantError(t, cs, http.MethodGet, "/api/v1/lobbies?limit=1000", "", http.StatusBadRequest, messaging.ErrBadRequest)
+}
+
+// deleteLobby closes the lobby with the token in the Authorization header, if there is one.
+func deleteLobby(t *testing.T, cs *gameServer, name string, token string) (*httptest.ResponseRecorder, messaging.ErrorCode) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/lobbies/"+name, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	cs.ServeHTTP(rec, req)
+	var e messaging.Error
+	json.NewDecoder(rec.Body).Decode(&e)
+	return rec, e.Code
+}
+
+func TestAPIDeleteLobby(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	var info messaging.LobbyInfo
+	apiRequest(t, cs, http.MethodPost, "/api/v1/lobbies", `{"name":"a","clientId":"host"}`, &info)
+	lobby := cs.lobbies.Get("a")
+	if err := lobby.addPlayer(Player{clientId: "guest"}); err != nil {
+		t.Fatal(err)
+	}
+	var guest string
+	lobby.do(func() { guest = lobby.findPlayer("guest").token })
+
+	rec, code := deleteLobby(t, cs, "a", "")
+	if rec.Code != http.StatusUnauthorized || code != messaging.ErrUnauthorized || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("DELETE without a token = %d %s %v, want 401 UNAUTHORIZED asking for a bearer token", rec.Code, code, rec.Header())
+	}
+	for name, token := range map[string]string{"wrong": "wrong", "guest's": guest} {
+		if rec, code := deleteLobby(t, cs, "a", token); rec.Code != http.StatusForbidden || code != messaging.ErrInvalidSession {
+			t.Errorf("DELETE with the %s token = %d %s, want 403 INVALID_SESSION", name, rec.Code, code)
+		}
+	}
+	if rec, _ := deleteLobby(t, cs, "a", info.Token); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE with the host's token = %d, want 204", rec.Code)
+	}
+	wantError(t, cs, http.MethodGet, "/api/v1/lobbies/a", "", http.StatusNotFound, messaging.ErrLobbyNotFound)
+	if rec, code := deleteLobby(t, cs, "a", info.Token); rec.Code != http.StatusNotFound || code != messaging.ErrLobbyNotFound {
+		t.Errorf("DELETE of a closed lobby = %d %s, want 404 LOBBY_NOT_FOUND", rec.Code, code)
+	}
+}
+
+func TestAPIDeleteLobbyWithoutHost(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	l, err := cs.createLobby("a", defaultLobbySettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.addPlayer(Player{clientId: "bot", bot: true}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, code := deleteLobby(t, cs, "a", "token"); rec.Code != http.StatusForbidden || code != messaging.ErrNotHost {
+		t.Errorf("DELETE without a host = %d %s, want 403 NOT_HOST", rec.Code, code)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+
+	rec := wantError(t, cs, http.MethodPut, "/api/v1/lobbies", "", http.StatusMethodNotAllowed, messaging.ErrMethodNotAllowed)
+	if allow := rec.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Allow %q, want GET, POST", allow)
+	}
+	wantError(t, cs, http.MethodGet, "/api/v1/nothing", "", http.StatusNotFound, messaging.ErrNotFound)
+}
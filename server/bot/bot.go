@@ -0,0 +1,186 @@
+// Package bot implements strategies for server-side bot players.
+package bot
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/venom1270/RPS/server/game"
+)
+
+// Strategy picks the next choice of a bot playing as player in game g.
+type Strategy interface {
+	Name() string
+	Choose(g *game.Game, player int) game.PlayerChoice
+}
+
+var strategies = map[string]func(rng *rand.Rand) Strategy{
+	"random":    func(rng *rand.Rand) Strategy { return &randomStrategy{rng: rng} },
+	"frequency": func(rng *rand.Rand) Strategy { return &frequencyStrategy{rng: rng} },
+	"markov":    func(rng *rand.Rand) Strategy { return &markovStrategy{rng: rng} },
+	"nojoker":   func(rng *rand.Rand) Strategy { return &noJokerStrategy{rng: rng} },
+}
+
+// DefaultStrategy is used when no strategy name is given.
+const DefaultStrategy = "random"
+
+// New creates a strategy by name.
+func New(name string) (Strategy, bool) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+	create, ok := strategies[name]
+	if !ok {
+		return nil, false
+	}
+	return create(rand.New(rand.NewSource(time.Now().UnixNano()))), true
+}
+
+// Names returns the names of all strategies, sorted.
+func Names() []string {
+	names := []string{}
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// randomStrategy picks uniformly from all choices.
+type randomStrategy struct {
+	rng *rand.Rand
+}
+
+func (s *randomStrategy) Name() string {
+	return "random"
+}
+
+func (s *randomStrategy) Choose(g *game.Game, player int) game.PlayerChoice {
+	return randomChoice(s.rng, g.Rules().Choices)
+}
+
+// noJokerStrategy picks uniformly from all choices except the Joker.
+type noJokerStrategy struct {
+	rng *rand.Rand
+}
+
+func (s *noJokerStrategy) Name() string {
+	return "nojoker"
+}
+
+func (s *noJokerStrategy) Choose(g *game.Game, player int) game.PlayerChoice {
+	choices := []game.PlayerChoice{}
+	for _, c := range g.Rules().Choices {
+		if c != game.JOKER {
+			choices = append(choices, c)
+		}
+	}
+	return randomChoice(s.rng, choices)
+}
+
+// frequencyStrategy counters the choice the opponents made most often.
+type frequencyStrategy struct {
+	rng *rand.Rand
+}
+
+func (s *frequencyStrategy) Name() string {
+	return "frequency"
+}
+
+func (s *frequencyStrategy) Choose(g *game.Game, player int) game.PlayerChoice {
+	counts := map[game.PlayerChoice]int{}
+	for _, opponent := range opponents(g, player) {
+		for _, c := range g.History(opponent) {
+			if c != game.NONE {
+				counts[c]++
+			}
+		}
+	}
+
+	predicted, ok := mostFrequent(counts)
+	if !ok {
+		return randomChoice(s.rng, g.Rules().Choices)
+	}
+	return counter(s.rng, g.Rules(), predicted)
+}
+
+// markovStrategy predicts the opponents' next choice from what they usually play after their last choice.
+type markovStrategy struct {
+	rng *rand.Rand
+}
+
+func (s *markovStrategy) Name() string {
+	return "markov"
+}
+
+func (s *markovStrategy) Choose(g *game.Game, player int) game.PlayerChoice {
+	counts := map[game.PlayerChoice]int{}
+	for _, opponent := range opponents(g, player) {
+		history := g.History(opponent)
+		if len(history) < 2 {
+			continue
+		}
+		last := history[len(history)-1]
+		// Count transitions from the last choice
+		for i := 0; i < len(history)-1; i++ {
+			if history[i] == last && history[i+1] != game.NONE {
+				counts[history[i+1]]++
+			}
+		}
+	}
+
+	predicted, ok := mostFrequent(counts)
+	if !ok {
+		return randomChoice(s.rng, g.Rules().Choices)
+	}
+	return counter(s.rng, g.Rules(), predicted)
+}
+
+func opponents(g *game.Game, player int) []int {
+	o := []int{}
+	for i := 0; i < g.NumPlayers(); i++ {
+		if i != player {
+			o = append(o, i)
+		}
+	}
+	return o
+}
+
+func randomChoice(rng *rand.Rand, choices []game.PlayerChoice) game.PlayerChoice {
+	return choices[rng.Intn(len(choices))]
+}
+
+func mostFrequent(counts map[game.PlayerChoice]int) (game.PlayerChoice, bool) {
+	best, bestCount := game.NONE, 0
+	for c, n := range counts {
+		// Ties are broken by the lower choice so the result doesn't depend on map order
+		if n > bestCount || (n == bestCount && c < best) {
+			best, bestCount = c, n
+		}
+	}
+	return best, bestCount > 0
+}
+
+// counter returns a choice that beats the predicted one, preferring choices without a losing penalty.
+func counter(rng *rand.Rand, rules *game.RuleSet, predicted game.PlayerChoice) game.PlayerChoice {
+	safe, risky := []game.PlayerChoice{}, []game.PlayerChoice{}
+	for _, c := range rules.Choices {
+		if rules.Resolve(c, predicted, func() bool { return true }) != 0 {
+			continue
+		}
+		if rules.LoseDelta[c] < 0 {
+			risky = append(risky, c)
+		} else {
+			safe = append(safe, c)
+		}
+	}
+
+	if len(safe) > 0 {
+		return randomChoice(rng, safe)
+	}
+	if len(risky) > 0 {
+		return randomChoice(rng, risky)
+	}
+	return randomChoice(rng, rules.Choices)
+}
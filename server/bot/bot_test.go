@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/venom1270/RPS/server/game"
+)
+
+// playedGame returns a classic game in which player 1 made the given choices, player 0 always played ROCK.
+func playedGame(t *testing.T, rules *game.RuleSet, choices ...game.PlayerChoice) *game.Game {
+	t.Helper()
+	g := game.NewGame(game.Config{Rules: rules, Match: game.MatchConfig{Format: game.FIRST_TO, Target: 100}, Seed: 1})
+	for _, c := range choices {
+		g.MakeChoice(0, game.ROCK)
+		if ok, _ := g.MakeChoice(1, c); !ok {
+			t.Fatalf("choice %d rejected", c)
+		}
+		g.CompleteRound()
+	}
+	return g
+}
+
+func TestStrategiesCounterPrediction(t *testing.T) {
+	tests := []struct {
+		strategy string
+		history  []game.PlayerChoice
+		want     game.PlayerChoice
+	}{
+		{"frequency", []game.PlayerChoice{game.ROCK, game.SCISSORS, game.ROCK}, game.PAPER},
+		{"frequency", []game.PlayerChoice{game.SCISSORS, game.PAPER, game.SCISSORS}, game.ROCK},
+		// ROCK and PAPER are tied, the lower choice is predicted
+		{"frequency", []game.PlayerChoice{game.PAPER, game.ROCK}, game.PAPER},
+		// PAPER always followed ROCK
+		{"markov", []game.PlayerChoice{game.ROCK, game.PAPER, game.ROCK, game.PAPER, game.ROCK}, game.SCISSORS},
+		{"markov", []game.PlayerChoice{game.PAPER, game.ROCK, game.ROCK, game.PAPER}, game.PAPER},
+	}
+	for _, tt := range tests {
+		g := playedGame(t, game.ClassicRules, tt.history...)
+		for seed := int64(1); seed <= 20; seed++ {
+			s := strategies[tt.strategy](rand.New(rand.NewSource(seed)))
+			if got := s.Choose(g, 0); got != tt.want {
+				t.Errorf("%s after %v (seed %d) = %d, want %d", tt.strategy, tt.history, seed, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestStrategiesWithoutHistory(t *testing.T) {
+	g := playedGame(t, game.JokerRules)
+	for _, name := range Names() {
+		s, _ := New(name)
+		if c := s.Choose(g, 0); !game.JokerRules.IsValidChoice(c) {
+			t.Errorf("%s chose %d without a history", name, c)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, rules := range []*game.RuleSet{game.ClassicRules, game.JokerRules} {
+		for _, predicted := range rules.Choices {
+			for i := 0; i < 20; i++ {
+				c := counter(rng, rules, predicted)
+				if rules.Resolve(c, predicted, func() bool { return true }) != 0 {
+					t.Errorf("%s: %d doesn't beat %d", rules.Name, c, predicted)
+				}
+				if predicted != game.JOKER && c == game.JOKER {
+					t.Errorf("%s: the Joker was picked against %d although a choice without a penalty wins", rules.Name, predicted)
+				}
+			}
+		}
+	}
+}
+
+func TestNoJoker(t *testing.T) {
+	s := strategies["nojoker"](rand.New(rand.NewSource(1)))
+	g := playedGame(t, game.JokerRules)
+	seen := map[game.PlayerChoice]bool{}
+	for i := 0; i < 1000; i++ {
+		seen[s.Choose(g, 0)] = true
+	}
+	if seen[game.JOKER] {
+		t.Error("nojoker picked the Joker")
+	}
+	if len(seen) != len(game.JokerRules.Choices)-1 {
+		t.Errorf("nojoker picked %v, want all other choices", seen)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if s, ok := New(""); !ok || s.Name() != DefaultStrategy {
+		t.Errorf("New(\"\") = %v, want the %s strategy", s, DefaultStrategy)
+	}
+	for _, name := range Names() {
+		if s, ok := New(name); !ok || s.Name() != name {
+			t.Errorf("New(%s) = %v", name, s)
+		}
+	}
+	if _, ok := New("cheater"); ok {
+		t.Error("unknown strategy created")
+	}
+}
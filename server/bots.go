@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/bot"
+	"github.com/venom1270/RPS/server/game"
+)
+
+// addBot fills an empty seat in the lobby with a bot player using the given strategy.
+// The bot is a subscriber without a websocket connection - it reads the lobby's broadcasts
+// and sends its choices as events, like a client's connection would.
+func (l *Lobby) addBot(strategyName string) error {
+	reply := make(chan error, 1)
+	if !l.send(addBotEvent{strategy: strategyName, reply: reply}) {
+		return ErrLobbyClosed
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-l.done:
+		return ErrLobbyClosed
+	}
+}
+
+func (l *Lobby) handleAddBot(strategyName string) error {
+	strategy, ok := bot.New(strategyName)
+	if !ok {
+		return messaging.Errorf(messaging.ErrUnknownBot, "unknown bot strategy '%s'", strategyName)
+	}
+
+	if !l.betweenGames() {
+		return messaging.NewError(messaging.ErrGameStarted, "game already started")
+	}
+
+	player := Player{
+		clientId: fmt.Sprintf("BOT_%s_%d", strategy.Name(), l.subscriberIdCount),
+		ready:    true,
+		bot:      true,
+	}
+	if err := l.handleJoin(player); err != nil {
+		return err
+	}
+
+	s := &subscriber{
+		player:    &player,
+		msgs:      make(chan messaging.Message, l.subscriberMessageBuffer),
+		readCmdCh: make(chan messaging.Message, l.subscriberMessageBuffer),
+		readErrCh: make(chan error, l.subscriberMessageBuffer),
+		closeSlow: func() {},
+		bot:       strategy,
+		botDone:   make(chan struct{}),
+	}
+
+	log.Printf("Bot %s joined lobby %s", player.clientId, l.id)
+
+	go l.runBot(s)
+	l.handleSubscribe(s)
+
+	return nil
+}
+
+// runBot reacts to the lobby's messages like a client would, until the bot is removed from the lobby.
+func (l *Lobby) runBot(s *subscriber) {
+	defer l.send(unsubscribeEvent{s: s})
+
+	// Choice and nonce of the current round's commitment
+	var choice game.PlayerChoice
+	var nonce string
+
+	for {
+		var msg messaging.Message
+		select {
+		case msg = <-s.msgs:
+		case <-s.botDone:
+			log.Printf("Bot %s left lobby %s", s.player.clientId, l.id)
+			return
+		}
+
+		switch {
+		case msg.Type == messaging.MessageText && msg.Content == "0":
+			// Input signal, the strategy reads the game on the lobby's event loop
+			playing, commitReveal := false, false
+			l.do(func() {
+				player := l.seat(s)
+				if player < 0 || l.game == nil {
+					return
+				}
+				choice = s.bot.Choose(l.game, player)
+				playing = true
+				commitReveal = l.commitReveal
+			})
+			if !playing {
+				continue
+			}
+
+			if commitReveal {
+				nonce = strconv.FormatInt(rand.Int63(), 36)
+				l.send(choiceEvent{s: s, msg: *messaging.CreateCommandMessage(messaging.CommandCommit, game.CommitHash(choice, nonce))})
+			} else {
+				l.send(choiceEvent{s: s, msg: *messaging.CreateTextMessage(strconv.Itoa(int(choice)))})
+			}
+		case msg.Type == messaging.MessageCommand && msg.Cmd == messaging.CommandReveal:
+			l.send(choiceEvent{s: s, msg: *messaging.CreateCommandMessage(messaging.CommandReveal, fmt.Sprintf("%d %s", choice, nonce))})
+		}
+	}
+}
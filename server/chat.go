@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/chat"
+)
+
+// Chat limits
+const (
+	maxChatLength  = 200 // characters
+	chatScrollback = 20  // messages sent to players who join later
+	chatBurst      = 5
+	chatInterval   = 2 * time.Second // after a burst, one message per interval
+)
+
+func newChatLimiter() *chat.RateLimiter {
+	return chat.NewRateLimiter(chatBurst, chatInterval)
+}
+
+// handleChat sends a player's chat message to everyone in the lobby, if it passes the limits and the server's filter.
+func (l *Lobby) handleChat(s *subscriber, msg messaging.Message) {
+	fail := func(code messaging.ErrorCode, text string) {
+		l.sendTo(s, s.reply(msg, messaging.NewError(code, text), nil))
+	}
+
+	text := strings.TrimSpace(msg.Content)
+	if text == "" {
+		fail(messaging.ErrBadRequest, "Empty message")
+		return
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		fail(messaging.ErrMessageTooLong, fmt.Sprintf("Messages can have at most %d characters", maxChatLength))
+		return
+	}
+	if !l.chatLimiter.Allow(s.player.clientId, time.Now()) {
+		fail(messaging.ErrRateLimited, "Too many messages, slow down")
+		return
+	}
+	if f := l.server.chatFilter; f != nil {
+		var ok bool
+		if text, ok = f.Filter(text); !ok {
+			fail(messaging.ErrMessageRejected, "Message not allowed")
+			return
+		}
+	}
+
+	chatMsg := messaging.ChatMessage{From: s.player.clientId, Text: text, Time: time.Now().UnixMilli()}
+	l.chatLog = append(l.chatLog, chatMsg)
+	if len(l.chatLog) > chatScrollback {
+		l.chatLog = l.chatLog[len(l.chatLog)-chatScrollback:]
+	}
+
+	l.sendTo(s, s.reply(msg, nil, nil))
+	l.publish(messaging.CreatePayloadMessage(messaging.CommandChat, chatMsg))
+}
+
+// sendChatLog sends the latest chat messages to a player or spectator that just joined.
+func (l *Lobby) sendChatLog(s *subscriber) {
+	for _, m := range l.chatLog {
+		l.sendTo(s, messaging.CreatePayloadMessage(messaging.CommandChat, m))
+	}
+}
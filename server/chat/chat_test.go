@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name   string
+		sender string
+		at     time.Duration // since start
+		want   bool
+	}{
+		{"burst 1", "a", 0, true},
+		{"burst 2", "a", 0, true},
+		{"burst 3", "a", 0, true},
+		{"after the burst", "a", 0, false},
+		{"other sender", "b", 0, true},
+		{"before the interval", "a", 500 * time.Millisecond, false},
+		{"after the interval", "a", time.Second, true},
+		{"once per interval", "a", time.Second, false},
+		{"refilled", "a", 10 * time.Second, true},
+		{"refilled up to the burst", "a", 10 * time.Second, true},
+		{"refilled burst used", "a", 10 * time.Second, true},
+		{"after the refilled burst", "a", 10 * time.Second, false},
+	}
+
+	r := NewRateLimiter(3, time.Second)
+	for _, tt := range tests {
+		if got := r.Allow(tt.sender, start.Add(tt.at)); got != tt.want {
+			t.Errorf("%s: Allow = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWordList(t *testing.T) {
+	words := NewWordList([]string{"darn", "Heck"})
+	rejecting := NewWordList([]string{"darn"})
+	rejecting.Reject = true
+
+	tests := []struct {
+		filter Filter
+		text   string
+		want   string
+		ok     bool
+	}{
+		{words, "good game", "good game", true},
+		{words, "darn it", "**** it", true},
+		{words, "DARN, heck!", "****, ****!", true},
+		{words, "darned darn", "darned ****", true}, // whole words only
+		{words, "čšž darn", "čšž ****", true},
+		{words, "", "", true},
+		{rejecting, "darn it", "", false},
+		{rejecting, "heck", "heck", true},
+		{Filters{words, rejecting}, "darn heck", "**** ****", true}, // masked before the second filter sees it
+		{Filters{rejecting, words}, "darn heck", "", false},
+		{Filters{}, "darn", "darn", true},
+	}
+	for _, tt := range tests {
+		got, ok := tt.filter.Filter(tt.text)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Filter(%q) = %q, %t, want %q, %t", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# swear words\ndarn\n\n  heck  \n#skipped\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := LoadWordList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Len() != 2 {
+		t.Errorf("loaded %d words, want 2", w.Len())
+	}
+	if got, _ := w.Filter("heck skipped swear"); got != "**** skipped swear" {
+		t.Errorf("Filter = %q, want only the listed words masked", got)
+	}
+
+	if _, err := LoadWordList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+}
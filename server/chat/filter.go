@@ -0,0 +1,103 @@
+// Package chat holds the moderation hooks of the lobby chat: message filters and rate limiting.
+package chat
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Filter checks a chat message before it is sent to the lobby. It returns the text to send,
+// which may be changed (e.g. masked words), or false to reject the message.
+type Filter interface {
+	Filter(text string) (string, bool)
+}
+
+// Filters runs several filters in order, a message is rejected if any of them rejects it.
+type Filters []Filter
+
+func (fs Filters) Filter(text string) (string, bool) {
+	for _, f := range fs {
+		var ok bool
+		text, ok = f.Filter(text)
+		if !ok {
+			return "", false
+		}
+	}
+	return text, true
+}
+
+// WordList masks listed words with asterisks, or rejects messages containing them if Reject is set.
+// Words are matched as a whole and ignoring case.
+type WordList struct {
+	words  map[string]struct{}
+	Reject bool
+}
+
+func NewWordList(words []string) *WordList {
+	w := &WordList{words: map[string]struct{}{}}
+	for _, word := range words {
+		w.words[strings.ToLower(word)] = struct{}{}
+	}
+	return w
+}
+
+// LoadWordList reads a word list file with one word per line. Empty lines and lines starting with # are skipped.
+func LoadWordList(path string) (*WordList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewWordList(words), nil
+}
+
+func (w *WordList) Len() int {
+	return len(w.words)
+}
+
+func (w *WordList) Filter(text string) (string, bool) {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+		word := string(runes[i:j])
+		if _, listed := w.words[strings.ToLower(word)]; listed {
+			if w.Reject {
+				return "", false
+			}
+			b.WriteString(strings.Repeat("*", j-i))
+		} else {
+			b.WriteString(word)
+		}
+		i = j
+	}
+	return b.String(), true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,41 @@
+package chat
+
+import "time"
+
+// RateLimiter limits how many messages each sender can send: a burst of messages, then one per interval.
+// It is not safe for concurrent use, every lobby's event loop has its own.
+type RateLimiter struct {
+	burst    int
+	interval time.Duration
+	senders  map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(burst int, interval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		burst:    burst,
+		interval: interval,
+		senders:  map[string]*bucket{},
+	}
+}
+
+// Allow reports whether the sender may send a message now and counts it if so.
+func (r *RateLimiter) Allow(sender string, now time.Time) bool {
+	b, ok := r.senders[sender]
+	if !ok {
+		b = &bucket{tokens: float64(r.burst), last: now}
+		r.senders[sender] = b
+	}
+
+	b.tokens = min(float64(r.burst), b.tokens+float64(now.Sub(b.last))/float64(r.interval))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/chat"
+)
+
+// chatReplies sends chat messages from s and returns the replies to them.
+func chatReplies(l *Lobby, s *subscriber, texts ...string) []messaging.Reply {
+	replies := []messaging.Reply{}
+	for _, text := range texts {
+		l.send(chatEvent{s: s, msg: *messaging.CreateCommandMessage(messaging.CommandChat, text)})
+	}
+	l.do(func() {
+		for len(s.msgs) > 0 {
+			if msg := <-s.msgs; msg.Cmd == messaging.CommandReply {
+				replies = append(replies, msg.Payload.(messaging.Reply))
+			}
+		}
+	})
+	return replies
+}
+
+func TestChat(t *testing.T) {
+	tests := []struct {
+		text string
+		want messaging.ErrorCode // empty if the message is sent
+	}{
+		{"gg", ""},
+		{"  ", messaging.ErrBadRequest},
+		{strings.Repeat("a", maxChatLength), ""},
+		{strings.Repeat("a", maxChatLength+1), messaging.ErrMessageTooLong},
+		{strings.Repeat("č", maxChatLength), ""}, // characters, not bytes
+		{"  " + strings.Repeat("a", maxChatLength) + "  ", ""},
+		{"darn", messaging.ErrMessageRejected},
+	}
+
+	l, subs := startTestGame(t)
+	words := chat.NewWordList([]string{"darn"})
+	words.Reject = true
+	l.server.chatFilter = words
+	subs[0].codec = messaging.JSONCodec
+	for _, tt := range tests {
+		// Not rate limited by the earlier messages
+		l.do(func() { l.chatLimiter = newChatLimiter() })
+
+		replies := chatReplies(l, subs[0], tt.text)
+		if len(replies) != 1 {
+			t.Fatalf("%d replies to a chat message, want 1", len(replies))
+		}
+		if got := replies[0].Code; got != tt.want {
+			t.Errorf("chat message of %d characters: %q, want %q", len([]rune(tt.text)), got, tt.want)
+		}
+	}
+}
+
+func TestChatRateLimit(t *testing.T) {
+	l, subs := startTestGame(t)
+	for _, s := range subs {
+		s.codec = messaging.JSONCodec
+	}
+
+	texts := make([]string, chatBurst+1)
+	for i := range texts {
+		texts[i] = "spam"
+	}
+	replies := chatReplies(l, subs[0], texts...)
+	for i, r := range replies {
+		want := messaging.ErrorCode("")
+		if i == chatBurst {
+			want = messaging.ErrRateLimited
+		}
+		if got := r.Code; got != want {
+			t.Errorf("chat message %d: %q, want %q", i+1, got, want)
+		}
+	}
+
+	// Every player has their own limit
+	if r := chatReplies(l, subs[1], "hi"); r[0].Err() != nil {
+		t.Errorf("other player's message: %v", r[0].Err())
+	}
+}
+
+func TestChatScrollback(t *testing.T) {
+	l, subs := startTestGame(t)
+	const sent = chatScrollback + 5
+	for i := 0; i < sent; i++ {
+		// Spread over both players so the rate limit doesn't kick in
+		l.do(func() { l.chatLimiter = newChatLimiter() })
+		chatReplies(l, subs[i%2], strings.Repeat("x", i+1))
+	}
+
+	late := &subscriber{player: &Player{clientId: "c"}, msgs: make(chan messaging.Message, 256), closeSlow: func() {}}
+	l.send(subscribeEvent{s: late})
+	var log []messaging.ChatMessage
+	l.do(func() {
+		for len(late.msgs) > 0 {
+			if msg := <-late.msgs; msg.Cmd == messaging.CommandChat {
+				log = append(log, msg.Payload.(messaging.ChatMessage))
+			}
+		}
+	})
+
+	if len(log) != chatScrollback {
+		t.Fatalf("late joiner got %d chat messages, want %d", len(log), chatScrollback)
+	}
+	for i, m := range log {
+		// The latest messages, oldest first
+		if want := sent - chatScrollback + i + 1; len(m.Text) != want {
+			t.Errorf("scrollback message %d has %d characters, want %d", i, len(m.Text), want)
+		}
+	}
+}
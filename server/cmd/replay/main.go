@@ -0,0 +1,62 @@
+// Command replay re-runs replay files through game.Game and checks that the outcome is identical.
+//
+// Usage: go run ./cmd/replay <replay.json>...
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/venom1270/RPS/server/game"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	err := run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return errors.New("please provide one or more replay files")
+	}
+
+	failed := 0
+	for _, path := range os.Args[1:] {
+		if err := playFile(path); err != nil {
+			fmt.Printf("%s: FAIL - %v\n", path, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replays failed", failed, len(os.Args)-1)
+	}
+	return nil
+}
+
+func playFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := game.ReadReplay(f)
+	if err != nil {
+		return err
+	}
+
+	g, err := game.PlayReplay(r)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: OK - %d events, rounds: %d, scores: %v, winner: %d\n", path, len(r.Events), g.CurrentRound()+1, g.GetScores(), g.GetWinner())
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+	"github.com/venom1270/RPS/server/tournament"
+)
+
+// gameErrors maps errors of the game package to the error codes sent to clients.
+var gameErrors = map[error]messaging.ErrorCode{
+	game.ErrNotCommitReveal:  messaging.ErrBadRequest,
+	game.ErrAlreadyCommitted: messaging.ErrAlreadyCommitted,
+	game.ErrNotCommitted:     messaging.ErrCommitRequired,
+	game.ErrCommitsPending:   messaging.ErrCommitsPending,
+	game.ErrHashMismatch:     messaging.ErrHashMismatch,
+	game.ErrChoiceRejected:   messaging.ErrRejected,
+}
+
+// gameError gives an error of the game package its error code.
+func gameError(err error) error {
+	for target, code := range gameErrors {
+		if errors.Is(err, target) {
+			return messaging.NewError(code, err.Error())
+		}
+	}
+	return err
+}
+
+// tournamentErrors maps errors of the tournament package to the error codes sent to clients.
+var tournamentErrors = map[error]messaging.ErrorCode{
+	tournament.ErrStarted:           messaging.ErrTournamentStarted,
+	tournament.ErrAlreadyRegistered: messaging.ErrAlreadyRegistered,
+	tournament.ErrTooFewPlayers:     messaging.ErrTooFewPlayers,
+	tournament.ErrNotStarted:        messaging.ErrBadRequest,
+	tournament.ErrUnknownMatch:      messaging.ErrBadRequest,
+	tournament.ErrMatchDone:         messaging.ErrBadRequest,
+	tournament.ErrNotInMatch:        messaging.ErrNotInMatch,
+}
+
+// tournamentError gives an error of the tournament package its error code.
+func tournamentError(err error) error {
+	for target, code := range tournamentErrors {
+		if errors.Is(err, target) {
+			return messaging.NewError(code, err.Error())
+		}
+	}
+	return err
+}
+
+// httpStatus is the HTTP status code returned for requests failing with the error code.
+func httpStatus(code messaging.ErrorCode) int {
+	switch code {
+	case messaging.ErrNotFound, messaging.ErrLobbyNotFound, messaging.ErrPlayerNotFound, messaging.ErrTournamentNotFound:
+		return http.StatusNotFound
+	case messaging.ErrLobbyExists, messaging.ErrLobbyFull, messaging.ErrAlreadyInLobby, messaging.ErrGameStarted,
+		messaging.ErrTournamentExists, messaging.ErrTournamentStarted, messaging.ErrTournamentFull,
+		messaging.ErrAlreadyRegistered, messaging.ErrTooFewPlayers:
+		return http.StatusConflict
+	case messaging.ErrInvalidSession, messaging.ErrWrongPassword, messaging.ErrNotHost, messaging.ErrNotOrganizer, messaging.ErrNotInMatch:
+		return http.StatusForbidden
+	case messaging.ErrUnauthorized:
+		return http.StatusUnauthorized
+	case messaging.ErrLobbyClosed:
+		return http.StatusGone
+	case messaging.ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case messaging.ErrInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// writeError responds with the error's code and message as JSON, e.g. {"code":"LOBBY_FULL","message":"lobby is full"}
+func writeError(w http.ResponseWriter, err error) {
+	code := messaging.CodeOf(err)
+	writeJSON(w, httpStatus(code), messaging.Error{Code: code, Message: messaging.MessageOf(err)})
+}
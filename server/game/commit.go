@@ -0,0 +1,126 @@
+package game
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+var (
+	ErrNotCommitReveal  = errors.New("game is not in commit-reveal mode")
+	ErrAlreadyCommitted = errors.New("player already committed this round")
+	ErrNotCommitted     = errors.New("player did not commit this round")
+	ErrCommitsPending   = errors.New("not all players committed yet")
+	ErrHashMismatch     = errors.New("revealed choice does not match the commitment")
+	ErrChoiceRejected   = errors.New("game could not accept choice")
+)
+
+// Commitment is the hash of a choice and a nonce, sent by a player before revealing the choice.
+// All commitments are kept so the results can be audited after the game.
+type Commitment struct {
+	Round  int
+	Player int
+	Hash   string
+
+	// Filled in when the player reveals the choice
+	Revealed bool
+	Choice   PlayerChoice
+	Nonce    string
+}
+
+// CommitHash returns the commitment hash of a choice, see messaging.CommitHash.
+func CommitHash(choice PlayerChoice, nonce string) string {
+	return messaging.CommitHash(int(choice), nonce)
+}
+
+// Verify checks that the revealed choice matches the committed hash.
+func (c Commitment) Verify() bool {
+	return c.Revealed && CommitHash(c.Choice, c.Nonce) == c.Hash
+}
+
+func (g *Game) IsCommitReveal() bool {
+	return g.commitReveal
+}
+
+// Commit records the player's commitment for the current round.
+func (g *Game) Commit(player int, hash string) error {
+	if !g.commitReveal {
+		return ErrNotCommitReveal
+	}
+	if player < 0 || player >= len(g.players) || g.state == GAME_FINISHED {
+		return ErrChoiceRejected
+	}
+	if g.commitment(player) != nil || len(g.players[player]) > g.currentRound {
+		return ErrAlreadyCommitted
+	}
+
+	g.commitments = append(g.commitments, Commitment{
+		Round:  g.currentRound,
+		Player: player,
+		Hash:   strings.ToLower(hash),
+	})
+	g.emit(Event{Type: EventChoiceCommitted, Player: player, Hash: strings.ToLower(hash)})
+	return nil
+}
+
+// AllCommitted reports whether every player committed (or forfeited) in the current round.
+func (g *Game) AllCommitted() bool {
+	for i := range g.players {
+		if g.commitment(i) == nil && len(g.players[i]) <= g.currentRound {
+			return false
+		}
+	}
+	return true
+}
+
+// Reveal verifies the choice against the player's commitment and records it.
+// Choices are only accepted once all players committed.
+func (g *Game) Reveal(player int, choice PlayerChoice, nonce string) error {
+	if !g.commitReveal {
+		return ErrNotCommitReveal
+	}
+	c := g.commitment(player)
+	if c == nil {
+		return ErrNotCommitted
+	}
+	if !g.AllCommitted() {
+		return ErrCommitsPending
+	}
+	if CommitHash(choice, nonce) != c.Hash {
+		return ErrHashMismatch
+	}
+
+	if !g.rules.IsValidChoice(choice) {
+		return ErrChoiceRejected
+	}
+	ok, _ := g.record(player, choice)
+	if !ok {
+		return ErrChoiceRejected
+	}
+	g.emit(Event{Type: EventChoiceRevealed, Player: player, Choice: &choice, Nonce: nonce})
+
+	c.Revealed = true
+	c.Choice = choice
+	c.Nonce = nonce
+	return nil
+}
+
+// Commitments returns all commitments made during the game.
+func (g *Game) Commitments() []Commitment {
+	return g.commitments
+}
+
+// commitment returns the player's commitment for the current round.
+func (g *Game) commitment(player int) *Commitment {
+	for i := len(g.commitments) - 1; i >= 0; i-- {
+		c := &g.commitments[i]
+		if c.Round < g.currentRound {
+			break
+		}
+		if c.Round == g.currentRound && c.Player == player {
+			return c
+		}
+	}
+	return nil
+}
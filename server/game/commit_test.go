@@ -0,0 +1,85 @@
+package game
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCommitReveal(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, CommitReveal: true, Seed: 1})
+
+	if err := g.Reveal(0, ROCK, "n0"); !errors.Is(err, ErrNotCommitted) {
+		t.Errorf("reveal without commit: %v, want ErrNotCommitted", err)
+	}
+	if err := g.Commit(0, strings.ToUpper(CommitHash(ROCK, "n0"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Commit(0, CommitHash(PAPER, "n0")); !errors.Is(err, ErrAlreadyCommitted) {
+		t.Errorf("second commit: %v, want ErrAlreadyCommitted", err)
+	}
+	if err := g.Reveal(0, ROCK, "n0"); !errors.Is(err, ErrCommitsPending) {
+		t.Errorf("reveal before everyone committed: %v, want ErrCommitsPending", err)
+	}
+	if err := g.Commit(1, CommitHash(SCISSORS, "n1")); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches := []struct {
+		choice PlayerChoice
+		nonce  string
+	}{
+		{PAPER, "n1"},     // other choice
+		{SCISSORS, "n0"},  // other nonce
+		{SCISSORS, "n1 "}, // nonce with a trailing space
+	}
+	for _, m := range mismatches {
+		if err := g.Reveal(1, m.choice, m.nonce); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("reveal %d %q: %v, want ErrHashMismatch", m.choice, m.nonce, err)
+		}
+	}
+	if g.IsRoundFinished() {
+		t.Fatal("mismatched reveal was recorded")
+	}
+
+	if err := g.Reveal(0, ROCK, "n0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Reveal(1, SCISSORS, "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if r := g.CompleteRound(); r.Winner() != 0 {
+		t.Errorf("winner %d, want 0", r.Winner())
+	}
+	for _, c := range g.Commitments() {
+		if !c.Verify() {
+			t.Errorf("commitment %+v doesn't verify", c)
+		}
+	}
+}
+
+func TestCommitRejectsInvalidChoice(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, CommitReveal: true, Seed: 1})
+	g.Commit(0, CommitHash(JOKER, "n0"))
+	g.Commit(1, CommitHash(ROCK, "n1"))
+	if err := g.Reveal(0, JOKER, "n0"); !errors.Is(err, ErrChoiceRejected) {
+		t.Errorf("reveal of a choice the rules don't have: %v, want ErrChoiceRejected", err)
+	}
+}
+
+func TestMakeChoiceInCommitReveal(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, CommitReveal: true, Seed: 1})
+	if ok, _ := g.MakeChoice(0, ROCK); ok {
+		t.Error("plaintext choice accepted without a commitment")
+	}
+	if len(g.Events()) != 1 {
+		t.Errorf("rejected choice was logged: %v", g.Events())
+	}
+}
+
+func TestCommitOutsideCommitReveal(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, Seed: 1})
+	if err := g.Commit(0, CommitHash(ROCK, "n0")); !errors.Is(err, ErrNotCommitReveal) {
+		t.Errorf("commit: %v, want ErrNotCommitReveal", err)
+	}
+}
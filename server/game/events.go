@@ -0,0 +1,78 @@
+package game
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	EventGameStarted     EventType = "game_started"
+	EventChoiceCommitted EventType = "choice_committed" // commit-reveal: hash of the choice received
+	EventChoiceRevealed  EventType = "choice_revealed"  // commit-reveal: choice and nonce verified and locked
+	EventChoiceLocked    EventType = "choice_locked"    // choice locked, NONE if the player forfeited the round
+	EventPenalty         EventType = "penalty"          // player lost with a penalised choice, e.g. the Joker
+	EventCoinFlip        EventType = "coin_flip"
+	EventRoundResolved   EventType = "round_resolved"
+	EventMatchForfeited  EventType = "match_forfeited"
+	EventGameFinished    EventType = "game_finished"
+)
+
+// Event is a single entry of the game's append-only event log.
+// Fields that don't apply to the event type are left empty, Player is -1 if the event isn't about a single player.
+type Event struct {
+	Seq    int       `json:"seq"`
+	Time   time.Time `json:"time"`
+	Type   EventType `json:"type"`
+	Round  int       `json:"round"`
+	Player int       `json:"player"`
+
+	Choice *PlayerChoice `json:"choice,omitempty"`
+	Random bool          `json:"random,omitempty"` // choice was picked randomly by the server
+	Hash   string        `json:"hash,omitempty"`
+	Nonce  string        `json:"nonce,omitempty"`
+
+	// Coin flip opponent
+	Opponent *int `json:"opponent,omitempty"`
+	// Winner of a coin flip or the game (-1 on draw)
+	Winner *int `json:"winner,omitempty"`
+	// Penalty points
+	Points int `json:"points,omitempty"`
+
+	Choices []PlayerChoice `json:"choices,omitempty"`
+	Winners []int          `json:"winners,omitempty"`
+	Deltas  []int          `json:"deltas,omitempty"`
+	Scores  []int          `json:"scores,omitempty"`
+
+	Config *ReplayConfig `json:"config,omitempty"`
+}
+
+// emit appends the event to the log.
+func (g *Game) emit(e Event) {
+	e.Seq = len(g.events)
+	e.Time = time.Now()
+	e.Round = g.currentRound
+	g.events = append(g.events, e)
+}
+
+func (g *Game) emitChoice(t EventType, player int, choice PlayerChoice) {
+	g.emit(Event{Type: t, Player: player, Choice: &choice})
+}
+
+// Events returns a copy of the game's event log.
+func (g *Game) Events() []Event {
+	events := make([]Event, len(g.events))
+	copy(events, g.events)
+	return events
+}
+
+// intPtr returns a pointer to i, for event fields where 0 is a valid value.
+func intPtr(i int) *int {
+	return &i
+}
+
+func copyInts(s []int) []int {
+	c := make([]int, len(s))
+	copy(c, s)
+	return c
+}
@@ -3,7 +3,7 @@ package game
 import (
 	"log"
 	"math/rand"
-	"strconv"
+	"time"
 )
 
 type PlayerChoice int
@@ -13,6 +13,10 @@ const (
 	PAPER                        // 1
 	SCISSORS                     // 2
 	JOKER                        // 3
+	LIZARD                       // 4
+	SPOCK                        // 5
+
+	NONE PlayerChoice = -1 // no choice was made in time, loses against any choice
 )
 
 type GameState int
@@ -23,32 +27,197 @@ const (
 	GAME_FINISHED
 )
 
+type ScoringMode int
+
+const (
+	PAIRWISE ScoringMode = iota // every pair of players plays a matchup, a point per defeated opponent
+	MINORITY                    // players with the least picked choice win the round
+)
+
+const (
+	MinPlayers = 2
+	MaxPlayers = 8
+
+	// MinMinorityPlayers is the least players for MINORITY scoring, two players never have a single minority
+	MinMinorityPlayers = 3
+)
+
+// Config holds the settings a game is created with.
+// Zero values are replaced with defaults.
+type Config struct {
+	Rules   *RuleSet
+	Players int
+	Scoring ScoringMode
+	Match   MatchConfig
+
+	// CommitReveal makes players commit to a hash of their choice before revealing it.
+	CommitReveal bool
+
+	// Seed of the random source used for coin flips, 0 picks a random seed.
+	Seed int64
+}
+
 type Game struct {
 	players      []([]PlayerChoice)
 	scores       []int
-	toWin        int
+	match        MatchConfig
+	suddenDeath  bool
+	draw         bool
+	forfeited    []bool
+	commitReveal bool
+	commitments  []Commitment
 	numChoices   int
 	currentRound int
+	resolved     int // rounds completed so far, the last one stays current when the game ends
 	state        GameState
+	rules        *RuleSet
+	scoring      ScoringMode
+	seed         int64
+	rng          *rand.Rand
+	coinFlips    []CoinFlip
+	events       []Event
+}
+
+// CoinFlip records a tie that was broken by flipping a coin.
+type CoinFlip struct {
+	Round   int
+	PlayerA int
+	PlayerB int
+	Winner  int
+}
+
+// RoundResult describes the outcome of a completed round.
+type RoundResult struct {
+	Round   int
+	Choices []PlayerChoice
+	// Deltas holds the score change of each player in this round.
+	Deltas []int
+	// Winners holds the players that won the round, empty on stalemate.
+	Winners []int
+	// CoinFlips holds the ties in this round that were decided by a coin flip.
+	CoinFlips []CoinFlip
+}
+
+// Winner returns the winner of the round or -1 if there was a stalemate or more than one winner.
+func (r *RoundResult) Winner() int {
+	if len(r.Winners) != 1 {
+		return -1
+	}
+	return r.Winners[0]
 }
 
-func NewGame() *Game {
-	return &Game{
-		toWin:        3,
+// NewGame creates a new game for the given config.
+// If no rules are set, the default rule set is used, and the game defaults to two players.
+// Games with less than MinMinorityPlayers players use PAIRWISE scoring.
+func NewGame(cfg Config) *Game {
+	if cfg.Rules == nil {
+		cfg.Rules = DefaultRuleSet()
+	}
+	if cfg.Players < MinPlayers {
+		cfg.Players = MinPlayers
+	}
+	if cfg.Players > MaxPlayers {
+		cfg.Players = MaxPlayers
+	}
+	if cfg.Scoring == MINORITY && cfg.Players < MinMinorityPlayers {
+		cfg.Scoring = PAIRWISE
+	}
+	if cfg.Match.Validate() != nil {
+		cfg.Match = DefaultMatchConfig()
+	}
+
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+
+	players := make([][]PlayerChoice, cfg.Players)
+	for i := range players {
+		players[i] = []PlayerChoice{}
+	}
+
+	g := &Game{
+		rules:        cfg.Rules,
+		scoring:      cfg.Scoring,
+		match:        cfg.Match,
+		commitReveal: cfg.CommitReveal,
+		commitments:  []Commitment{},
+		seed:         cfg.Seed,
+		rng:          rand.New(rand.NewSource(cfg.Seed)),
+		coinFlips:    []CoinFlip{},
 		state:        WAITING,
-		scores:       []int{0, 0},
+		scores:       make([]int, cfg.Players),
+		forfeited:    make([]bool, cfg.Players),
 		currentRound: 0,
 		numChoices:   0,
-		players:      [][]PlayerChoice{[]PlayerChoice{}, []PlayerChoice{}},
+		events:       []Event{},
+		players:      players,
 	}
+
+	rc := g.replayConfig()
+	g.emit(Event{Type: EventGameStarted, Player: -1, Config: &rc})
+
+	return g
 }
 
 func (g *Game) MakeChoice(player int, choice PlayerChoice) (bool, bool) {
-	if player < 0 || player > 1 {
+	if g.commitReveal {
+		// Choices have to be committed and revealed, see Reveal
+		return false, false
+	}
+	if !g.rules.IsValidChoice(choice) {
+		// Choice not allowed by the rule set
+		return false, false
+	}
+
+	ok, roundFinished := g.record(player, choice)
+	if ok {
+		g.emitChoice(EventChoiceLocked, player, choice)
+	}
+	return ok, roundFinished
+}
+
+// MakeRandomChoice makes a random valid choice for the player using the game's random source.
+func (g *Game) MakeRandomChoice(player int) (PlayerChoice, bool) {
+	choice := g.rules.Choices[g.rng.Intn(len(g.rules.Choices))]
+	ok, _ := g.record(player, choice)
+	if ok {
+		g.emit(Event{Type: EventChoiceLocked, Player: player, Choice: &choice, Random: true})
+	}
+	return choice, ok
+}
+
+// Forfeit records that the player made no choice this round. The player loses against every other choice.
+func (g *Game) Forfeit(player int) (bool, bool) {
+	ok, roundFinished := g.record(player, NONE)
+	if ok {
+		g.emitChoice(EventChoiceLocked, player, NONE)
+	}
+	return ok, roundFinished
+}
+
+// ForfeitMatch ends the game, the player can't win it anymore.
+func (g *Game) ForfeitMatch(player int) bool {
+	if player < 0 || player >= len(g.players) || g.state == GAME_FINISHED {
+		return false
+	}
+
+	g.forfeited[player] = true
+	g.state = GAME_FINISHED
+	g.emit(Event{Type: EventMatchForfeited, Player: player})
+	g.emitGameFinished()
+	return true
+}
+
+func (g *Game) record(player int, choice PlayerChoice) (bool, bool) {
+	if player < 0 || player >= len(g.players) {
 		// Invalid player
 		return false, false
 	}
 
+	if g.state == GAME_FINISHED {
+		return false, false
+	}
+
 	if len(g.players[player]) > g.currentRound {
 		// Already played this round
 		return false, false
@@ -69,81 +238,170 @@ func (g *Game) MakeChoice(player int, choice PlayerChoice) (bool, bool) {
 	return true, roundFinished
 }
 
-func (g *Game) CompleteRound() int {
-	p1 := g.players[0][g.currentRound]
-	p2 := g.players[1][g.currentRound]
+// CompleteRound resolves the current round once all players made their choice
+// and starts a new one, unless the game is finished.
+func (g *Game) CompleteRound() *RoundResult {
+	result := &RoundResult{
+		Round:   g.currentRound,
+		Choices: make([]PlayerChoice, len(g.players)),
+		Deltas:  make([]int, len(g.players)),
+		Winners: []int{},
+	}
+	flipsBefore := len(g.coinFlips)
+	for i := range g.players {
+		result.Choices[i] = g.players[i][g.currentRound]
+	}
+
+	var changes []int
+	switch g.scoring {
+	case MINORITY:
+		changes, result.Winners = g.resolveMinority(result.Choices)
+	default:
+		changes, result.Winners = g.resolvePairwise(result.Choices)
+	}
+
+	result.CoinFlips = g.coinFlips[flipsBefore:]
+	g.resolved++
 
-	if p1 == p2 && p1 != JOKER {
-		// Stalemate
-		g.currentRound++
-		return -1
+	for i, change := range changes {
+		before := g.scores[i]
+		g.addScore(i, change)
+		result.Deltas[i] = g.scores[i] - before
 	}
 
-	winner := -1
+	g.emit(Event{
+		Type:    EventRoundResolved,
+		Player:  -1,
+		Choices: result.Choices,
+		Winners: result.Winners,
+		Deltas:  result.Deltas,
+		Scores:  copyInts(g.scores),
+	})
 
-	switch p1 {
-	case ROCK:
-		if p2 == SCISSORS {
-			winner = 0
-		} else if p2 == PAPER {
-			winner = 1
-		} else if p2 == 0 {
-			winner = 1
-		}
-	case PAPER:
-		if p2 == SCISSORS {
-			winner = 1
-		} else if p2 == ROCK {
-			winner = 0
-		} else if p2 == JOKER {
-			winner = 1
+	if g.checkFinished() {
+		g.state = GAME_FINISHED
+		g.emitGameFinished()
+		return result
+	}
+
+	g.currentRound++
+	g.state = WAITING
+	g.numChoices = 0
+	return result
+}
+
+// resolvePairwise plays every pair of players against each other.
+// Returns score changes and the players who won the most matchups.
+func (g *Game) resolvePairwise(choices []PlayerChoice) ([]int, []int) {
+	changes := make([]int, len(choices))
+	wins := make([]int, len(choices))
+
+	for a := 0; a < len(choices); a++ {
+		for b := a + 1; b < len(choices); b++ {
+			r := g.resolve(a, b, choices[a], choices[b])
+			if r == -1 {
+				// Stalemate
+				continue
+			}
+
+			winner, loser := a, b
+			if r == 1 {
+				winner, loser = b, a
+			}
+			wins[winner]++
+			changes[winner] += g.rules.WinDelta
+			if delta, ok := g.rules.LoseDelta[choices[loser]]; ok {
+				log.Printf("Player %d gets %d point(s) for losing with choice %d!", loser, delta, choices[loser])
+				changes[loser] += delta
+				g.emit(Event{Type: EventPenalty, Player: loser, Choice: &choices[loser], Points: delta})
+			}
 		}
-	case SCISSORS:
-		if p2 == ROCK {
-			winner = 1
-		} else if p2 == PAPER {
-			winner = 0
-		} else if p2 == JOKER {
-			winner = 0
-			log.Print("Player 1 loses 1 point for losing with the JOKER!")
-			g.addScore(1, -1)
+	}
+
+	best := 0
+	for _, w := range wins {
+		if w > best {
+			best = w
 		}
-	case JOKER:
-		if p2 == ROCK {
-			winner = 0
-		} else if p2 == PAPER {
-			winner = 0
-		} else if p2 == SCISSORS {
-			winner = 1
-			log.Print("Player 0 loses 1 point for losing with the JOKER!")
-			g.addScore(0, -1)
-		} else {
-			// 50-50 chance for each to win
-			randomFloat := rand.Float64()
-			if randomFloat >= 0.5 {
-				winner = 1
-				log.Print("BOTH PLAYERS USED JOKER! Player 0 loses 1 point for losing with the JOKER!")
-				g.addScore(0, -1)
-			} else {
-				winner = 0
-				log.Print("BOTH PLAYERS USED JOKER! Player 1 loses 1 point for losing with the JOKER!")
-				g.addScore(1, -1)
+	}
+	winners := []int{}
+	if best > 0 {
+		for i, w := range wins {
+			if w == best {
+				winners = append(winners, i)
 			}
 		}
 	}
 
-	if winner != -1 {
-		g.scores[winner]++
-		if g.scores[winner] >= g.toWin {
-			g.state = GAME_FINISHED
-			return winner
+	return changes, winners
+}
+
+// resolveMinority awards a point to every player that picked the least picked choice.
+// If more choices are tied for the least picked, or everyone picked the same, the round is a stalemate.
+func (g *Game) resolveMinority(choices []PlayerChoice) ([]int, []int) {
+	changes := make([]int, len(choices))
+	winners := []int{}
+
+	counts := map[PlayerChoice]int{}
+	for _, c := range choices {
+		if c != NONE {
+			counts[c]++
+		}
+	}
+	if len(counts) < 2 {
+		return changes, winners
+	}
+
+	minChoice, minCount, tied := PlayerChoice(-1), len(choices)+1, false
+	for c, n := range counts {
+		if n < minCount {
+			minChoice, minCount, tied = c, n, false
+		} else if n == minCount {
+			tied = true
 		}
 	}
+	if tied {
+		return changes, winners
+	}
 
-	g.currentRound++
-	g.state = WAITING
-	g.numChoices = 0
-	return winner
+	for i, c := range choices {
+		if c == minChoice {
+			changes[i] += g.rules.WinDelta
+			winners = append(winners, i)
+		}
+	}
+
+	return changes, winners
+}
+
+// resolve plays player a's choice against player b's choice, taking missing choices into account.
+// Returns 0 if a wins, 1 if b wins and -1 on stalemate.
+func (g *Game) resolve(a, b int, choiceA, choiceB PlayerChoice) int {
+	switch {
+	case choiceA == NONE && choiceB == NONE:
+		return -1
+	case choiceA == NONE:
+		return 1
+	case choiceB == NONE:
+		return 0
+	}
+
+	return g.rules.Resolve(choiceA, choiceB, func() bool {
+		// 50-50 chance for each to win
+		bWins := g.rng.Float64() >= 0.5
+		flip := CoinFlip{Round: g.currentRound, PlayerA: a, PlayerB: b, Winner: a}
+		if bWins {
+			flip.Winner = b
+		}
+		log.Printf("Coin flip between players %d and %d: player %d wins", a, b, flip.Winner)
+		g.coinFlips = append(g.coinFlips, flip)
+		g.emit(Event{Type: EventCoinFlip, Player: a, Opponent: intPtr(b), Winner: intPtr(flip.Winner)})
+		return bWins
+	})
+}
+
+func (g *Game) emitGameFinished() {
+	g.emit(Event{Type: EventGameFinished, Player: -1, Winner: intPtr(g.GetWinner()), Scores: copyInts(g.scores)})
 }
 
 func (g *Game) IsFinished() bool {
@@ -154,10 +412,17 @@ func (g *Game) IsRoundFinished() bool {
 	return g.state == ROUND_FINISHED
 }
 
+// GetWinner returns the player with the highest score, or -1 if the game ended in a draw.
 func (g *Game) GetWinner() int {
-	maxI := 0
-	maxScore := 0
+	if g.draw {
+		return -1
+	}
+	maxI := -1
+	maxScore := -1
 	for i, s := range g.scores {
+		if g.forfeited[i] {
+			continue
+		}
 		if s > maxScore {
 			maxI = i
 			maxScore = s
@@ -166,6 +431,54 @@ func (g *Game) GetWinner() int {
 	return maxI
 }
 
+func (g *Game) Seed() int64 {
+	return g.seed
+}
+
+func (g *Game) CoinFlips() []CoinFlip {
+	return g.coinFlips
+}
+
+// IsForfeited reports whether the player forfeited the game, see ForfeitMatch.
+func (g *Game) IsForfeited(player int) bool {
+	return player >= 0 && player < len(g.forfeited) && g.forfeited[player]
+}
+
+func (g *Game) IsDraw() bool {
+	return g.draw
+}
+
+func (g *Game) IsSuddenDeath() bool {
+	return g.suddenDeath
+}
+
+func (g *Game) Match() MatchConfig {
+	return g.match
+}
+
+func (g *Game) CurrentRound() int {
+	return g.currentRound
+}
+
+// History returns a copy of the player's choices in the completed rounds.
+// A choice made in the current round stays hidden until the round is completed.
+func (g *Game) History(player int) []PlayerChoice {
+	if player < 0 || player >= len(g.players) {
+		return []PlayerChoice{}
+	}
+	h := make([]PlayerChoice, min(len(g.players[player]), g.resolved))
+	copy(h, g.players[player])
+	return h
+}
+
+func (g *Game) NumPlayers() int {
+	return len(g.players)
+}
+
+func (g *Game) Rules() *RuleSet {
+	return g.rules
+}
+
 func (g *Game) GetScores() []int {
 	return g.scores
 }
@@ -178,21 +491,3 @@ func (g *Game) addScore(player, points int) {
 		}
 	}
 }
-
-func (g *Game) GetGameDetails(clientIds []string) string {
-	str := ""
-	for i, v := range g.players {
-		str += clientIds[i] + "=[" + strconv.Itoa(g.scores[i]) + ","
-		for _, vv := range v {
-			str += strconv.Itoa(int(vv)) + ","
-		}
-		if str[len(str)-1] == ',' {
-			str = str[:len(str)-1]
-		}
-		str += "];"
-	}
-	if len(str) > 0 {
-		str = str[:len(str)-1]
-	}
-	return str
-}
@@ -0,0 +1,175 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+// playRound makes the choices for all players and completes the round.
+func playRound(t *testing.T, g *Game, choices ...PlayerChoice) *RoundResult {
+	t.Helper()
+	for i, c := range choices {
+		ok, finished := g.MakeChoice(i, c)
+		if !ok {
+			t.Fatalf("round %d: choice %d of player %d rejected", g.CurrentRound(), c, i)
+		}
+		if finished != (i == len(choices)-1) {
+			t.Fatalf("round %d: round finished after player %d: %t", g.CurrentRound(), i, finished)
+		}
+	}
+	return g.CompleteRound()
+}
+
+func TestScoring(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   *RuleSet
+		scoring ScoringMode
+		choices []PlayerChoice
+		deltas  []int
+		winners []int
+	}{
+		{"pairwise", ClassicRules, PAIRWISE, []PlayerChoice{ROCK, SCISSORS}, []int{1, 0}, []int{0}},
+		{"pairwise stalemate", ClassicRules, PAIRWISE, []PlayerChoice{PAPER, PAPER}, []int{0, 0}, []int{}},
+		{"pairwise point per opponent", ClassicRules, PAIRWISE, []PlayerChoice{ROCK, SCISSORS, SCISSORS}, []int{2, 0, 0}, []int{0}},
+		{"pairwise cycle", ClassicRules, PAIRWISE, []PlayerChoice{ROCK, PAPER, SCISSORS}, []int{1, 1, 1}, []int{0, 1, 2}},
+		{"pairwise most matchups", ClassicRules, PAIRWISE, []PlayerChoice{ROCK, ROCK, SCISSORS, PAPER}, []int{1, 1, 1, 2}, []int{3}},
+		{"pairwise rpsls", LizardSpockRules, PAIRWISE, []PlayerChoice{SPOCK, SCISSORS, LIZARD}, []int{1, 1, 1}, []int{0, 1, 2}},
+		{"minority", ClassicRules, MINORITY, []PlayerChoice{ROCK, ROCK, PAPER}, []int{0, 0, 1}, []int{2}},
+		{"minority of two", ClassicRules, MINORITY, []PlayerChoice{ROCK, ROCK, ROCK, PAPER, PAPER}, []int{0, 0, 0, 1, 1}, []int{3, 4}},
+		{"minority tied", ClassicRules, MINORITY, []PlayerChoice{ROCK, ROCK, PAPER, PAPER}, []int{0, 0, 0, 0}, []int{}},
+		{"minority all same", ClassicRules, MINORITY, []PlayerChoice{ROCK, ROCK, ROCK}, []int{0, 0, 0}, []int{}},
+		{"minority ignores winning choices", ClassicRules, MINORITY, []PlayerChoice{SCISSORS, SCISSORS, ROCK}, []int{0, 0, 1}, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(Config{Rules: tt.rules, Players: len(tt.choices), Scoring: tt.scoring, Seed: 1})
+			r := playRound(t, g, tt.choices...)
+			if !reflect.DeepEqual(r.Deltas, tt.deltas) || !reflect.DeepEqual(r.Winners, tt.winners) {
+				t.Errorf("deltas %v winners %v, want %v and %v", r.Deltas, r.Winners, tt.deltas, tt.winners)
+			}
+		})
+	}
+}
+
+func TestJokerPenalty(t *testing.T) {
+	g := NewGame(Config{Rules: JokerRules, Match: MatchConfig{Format: FIRST_TO, Target: 5}, Seed: 1})
+	playRound(t, g, JOKER, ROCK)
+	playRound(t, g, JOKER, ROCK)
+
+	// Losing with the Joker costs a point
+	r := playRound(t, g, JOKER, SCISSORS)
+	if !reflect.DeepEqual(r.Deltas, []int{-1, 1}) {
+		t.Errorf("deltas %v, want [-1 1]", r.Deltas)
+	}
+	// Scores don't drop below zero
+	playRound(t, g, JOKER, SCISSORS)
+	r = playRound(t, g, JOKER, SCISSORS)
+	if r.Deltas[0] != 0 || g.GetScores()[0] != 0 {
+		t.Errorf("delta %d score %d, want 0 and 0", r.Deltas[0], g.GetScores()[0])
+	}
+}
+
+func TestMinorityNeedsThreePlayers(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, Players: 2, Scoring: MINORITY, Seed: 1})
+	if r := playRound(t, g, ROCK, SCISSORS); r.Winner() != 0 {
+		t.Errorf("winner %d, want 0 - two players score pairwise", r.Winner())
+	}
+}
+
+func TestForfeitRound(t *testing.T) {
+	tests := []struct {
+		choices []PlayerChoice
+		deltas  []int
+	}{
+		{[]PlayerChoice{NONE, ROCK}, []int{0, 1}},
+		{[]PlayerChoice{NONE, NONE}, []int{0, 0}},
+		{[]PlayerChoice{NONE, ROCK, SCISSORS}, []int{0, 2, 1}},
+	}
+	for _, tt := range tests {
+		g := NewGame(Config{Rules: ClassicRules, Players: len(tt.choices), Seed: 1})
+		for i, c := range tt.choices {
+			if c == NONE {
+				g.Forfeit(i)
+			} else {
+				g.MakeChoice(i, c)
+			}
+		}
+		if !g.IsRoundFinished() {
+			t.Fatalf("%v: round not finished", tt.choices)
+		}
+		if r := g.CompleteRound(); !reflect.DeepEqual(r.Deltas, tt.deltas) {
+			t.Errorf("%v: deltas %v, want %v", tt.choices, r.Deltas, tt.deltas)
+		}
+	}
+}
+
+func TestRandomChoice(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, Seed: 1})
+	choice, ok := g.MakeRandomChoice(0)
+	if !ok || !ClassicRules.IsValidChoice(choice) {
+		t.Fatalf("random choice %d ok %t, want a valid choice", choice, ok)
+	}
+	if _, ok := g.MakeRandomChoice(0); ok {
+		t.Error("second choice in the same round accepted")
+	}
+}
+
+func TestForfeitMatch(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, Seed: 1})
+	playRound(t, g, ROCK, SCISSORS)
+	g.MakeChoice(1, ROCK)
+	if !g.ForfeitMatch(0) || !g.IsFinished() {
+		t.Fatal("forfeit didn't end the game")
+	}
+	if g.GetWinner() != 1 {
+		t.Errorf("winner %d, want 1 - the leader forfeited", g.GetWinner())
+	}
+	if g.ForfeitMatch(1) {
+		t.Error("forfeit accepted after the game ended")
+	}
+}
+
+func TestSeedMakesCoinFlipsRepeatable(t *testing.T) {
+	play := func(seed int64) ([]CoinFlip, []PlayerChoice) {
+		g := NewGame(Config{Rules: JokerRules, Match: MatchConfig{Format: BEST_OF, Target: 9}, Seed: seed})
+		random := []PlayerChoice{}
+		for i := 0; i < 5 && !g.IsFinished(); i++ {
+			playRound(t, g, JOKER, JOKER)
+		}
+		for !g.IsFinished() {
+			c, _ := g.MakeRandomChoice(0)
+			g.MakeRandomChoice(1)
+			random = append(random, c)
+			g.CompleteRound()
+		}
+		return g.CoinFlips(), random
+	}
+
+	flips, random := play(42)
+	if len(flips) == 0 {
+		t.Fatal("no coin flips between two Jokers")
+	}
+	again, randomAgain := play(42)
+	if !reflect.DeepEqual(flips, again) || !reflect.DeepEqual(random, randomAgain) {
+		t.Errorf("same seed, different games:\n%v %v\n%v %v", flips, random, again, randomAgain)
+	}
+
+	if g := NewGame(Config{}); g.Seed() == 0 {
+		t.Error("no seed picked for a game without one")
+	}
+}
+
+func TestHistoryHidesCurrentRound(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, Match: MatchConfig{Format: BEST_OF, Target: 2}, Seed: 1})
+	playRound(t, g, ROCK, SCISSORS)
+	g.MakeChoice(1, PAPER)
+	if h := g.History(1); !reflect.DeepEqual(h, []PlayerChoice{SCISSORS}) {
+		t.Errorf("history %v during the round, want [SCISSORS]", h)
+	}
+	g.MakeChoice(0, ROCK)
+	g.CompleteRound()
+	if h := g.History(1); !g.IsFinished() || !reflect.DeepEqual(h, []PlayerChoice{SCISSORS, PAPER}) {
+		t.Errorf("history %v after the last round, want [SCISSORS PAPER]", h)
+	}
+}
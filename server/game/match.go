@@ -0,0 +1,167 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+)
+
+type MatchFormat int
+
+const (
+	FIRST_TO MatchFormat = iota // first player to reach Target points wins
+	BEST_OF                     // Target rounds are played, ends early when the winner is decided
+)
+
+// MatchConfig defines how long a game lasts and how it is decided.
+type MatchConfig struct {
+	Format MatchFormat `json:"format"`
+	// Target is the points needed to win (FIRST_TO) or the number of rounds (BEST_OF).
+	Target int `json:"target"`
+	// MaxRounds caps the number of rounds of a FIRST_TO game, 0 means no limit.
+	MaxRounds int `json:"maxRounds"`
+	// SuddenDeath plays extra rounds until there is a single leader when the game ends in a tie.
+	// Without it, such a game ends in a draw.
+	SuddenDeath bool `json:"suddenDeath"`
+}
+
+func DefaultMatchConfig() MatchConfig {
+	return MatchConfig{
+		Format: FIRST_TO,
+		Target: 3,
+	}
+}
+
+func (f MatchFormat) String() string {
+	switch f {
+	case BEST_OF:
+		return "best-of"
+	default:
+		return "first-to"
+	}
+}
+
+func ParseMatchFormat(s string) (MatchFormat, error) {
+	switch s {
+	case "first-to":
+		return FIRST_TO, nil
+	case "best-of":
+		return BEST_OF, nil
+	}
+	return FIRST_TO, fmt.Errorf("unknown match format '%s'", s)
+}
+
+// String formats the config as e.g. "first-to-3", "best-of-5" or "first-to-3/max-10/sd".
+func (m MatchConfig) String() string {
+	s := fmt.Sprintf("%s-%d", m.Format, m.Target)
+	if m.MaxRounds > 0 {
+		s += fmt.Sprintf("/max-%d", m.MaxRounds)
+	}
+	if m.SuddenDeath {
+		s += "/sd"
+	}
+	return s
+}
+
+func (m MatchConfig) Validate() error {
+	if m.Target < 1 {
+		return errors.New("match target must be at least 1")
+	}
+	if m.MaxRounds < 0 {
+		return errors.New("max rounds can't be negative")
+	}
+	if m.Format == BEST_OF && m.MaxRounds > 0 {
+		return errors.New("max rounds can only be set for first-to matches")
+	}
+	return nil
+}
+
+// leaders returns the players with the highest score.
+func (g *Game) leaders() []int {
+	best := -1
+	leaders := []int{}
+	for i, s := range g.scores {
+		if s > best {
+			best = s
+			leaders = []int{i}
+		} else if s == best {
+			leaders = append(leaders, i)
+		}
+	}
+	return leaders
+}
+
+// lead returns the difference between the best and the second best score.
+func (g *Game) lead() int {
+	first, second := -1, -1
+	for _, s := range g.scores {
+		if s > first {
+			first, second = s, first
+		} else if s > second {
+			second = s
+		}
+	}
+	return first - second
+}
+
+// maxSwing returns the most the score difference of two players can change in a single round.
+func (g *Game) maxSwing() int {
+	if g.scoring == MINORITY {
+		return g.rules.WinDelta
+	}
+
+	penalty := 0
+	for _, d := range g.rules.LoseDelta {
+		if -d > penalty {
+			penalty = -d
+		}
+	}
+	return (len(g.players) - 1) * (g.rules.WinDelta + penalty)
+}
+
+// checkFinished decides whether the game is over after the round that was just completed.
+func (g *Game) checkFinished() bool {
+	single := len(g.leaders()) == 1
+
+	if g.suddenDeath {
+		return single
+	}
+
+	played := g.currentRound + 1
+
+	// endOfMatch is used when the match ran out of rounds
+	endOfMatch := func() bool {
+		if single {
+			return true
+		}
+		if g.match.SuddenDeath {
+			g.suddenDeath = true
+			return false
+		}
+		g.draw = true
+		return true
+	}
+
+	switch g.match.Format {
+	case BEST_OF:
+		remaining := g.match.Target - played
+		if remaining <= 0 {
+			return endOfMatch()
+		}
+		// Decided early if nobody can catch up anymore
+		return g.lead() > remaining*g.maxSwing()
+	default:
+		if g.scores[g.leaders()[0]] >= g.match.Target {
+			if single {
+				return true
+			}
+			// More players reached the target at once, play until one of them leads
+			g.suddenDeath = true
+			return false
+		}
+		if g.match.MaxRounds > 0 && played >= g.match.MaxRounds {
+			return endOfMatch()
+		}
+	}
+
+	return false
+}
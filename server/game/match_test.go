@@ -0,0 +1,66 @@
+package game
+
+import "testing"
+
+func TestMatchFormats(t *testing.T) {
+	// Shorthands for the rounds: a - player 0 wins, b - player 1 wins, s - stalemate
+	a := []PlayerChoice{ROCK, SCISSORS}
+	b := []PlayerChoice{SCISSORS, ROCK}
+	s := []PlayerChoice{PAPER, PAPER}
+
+	tests := []struct {
+		name   string
+		match  MatchConfig
+		rounds [][]PlayerChoice
+		winner int // -1 for a draw
+	}{
+		{"first-to", MatchConfig{Format: FIRST_TO, Target: 3}, [][]PlayerChoice{a, b, s, a, b, a}, 0},
+		{"first-to max rounds", MatchConfig{Format: FIRST_TO, Target: 3, MaxRounds: 3}, [][]PlayerChoice{b, s, s}, 1},
+		{"first-to max rounds draw", MatchConfig{Format: FIRST_TO, Target: 3, MaxRounds: 2}, [][]PlayerChoice{a, b}, -1},
+		{"first-to max rounds sudden death", MatchConfig{Format: FIRST_TO, Target: 3, MaxRounds: 2, SuddenDeath: true}, [][]PlayerChoice{a, b, s, b}, 1},
+		{"best-of decided early", MatchConfig{Format: BEST_OF, Target: 5}, [][]PlayerChoice{a, a, a}, 0},
+		{"best-of catch up", MatchConfig{Format: BEST_OF, Target: 5}, [][]PlayerChoice{a, a, b, b, b}, 1},
+		{"best-of all rounds", MatchConfig{Format: BEST_OF, Target: 3}, [][]PlayerChoice{a, s, s}, 0},
+		{"best-of draw", MatchConfig{Format: BEST_OF, Target: 3}, [][]PlayerChoice{a, b, s}, -1},
+		{"best-of sudden death", MatchConfig{Format: BEST_OF, Target: 3, SuddenDeath: true}, [][]PlayerChoice{s, s, s, s, a}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(Config{Rules: ClassicRules, Match: tt.match, Seed: 1})
+			for i, round := range tt.rounds {
+				if g.IsFinished() {
+					t.Fatalf("finished after %d rounds, want %d", i, len(tt.rounds))
+				}
+				playRound(t, g, round...)
+			}
+			if !g.IsFinished() {
+				t.Fatalf("not finished after %d rounds", len(tt.rounds))
+			}
+			if g.GetWinner() != tt.winner || g.IsDraw() != (tt.winner < 0) {
+				t.Errorf("winner %d draw %t, want %d", g.GetWinner(), g.IsDraw(), tt.winner)
+			}
+		})
+	}
+}
+
+func TestBestOfCountsJokerPenalty(t *testing.T) {
+	// A round can swing the score by 2 with the Joker, so a 2 point lead isn't safe with one round left
+	g := NewGame(Config{Rules: JokerRules, Match: MatchConfig{Format: BEST_OF, Target: 3}, Seed: 1})
+	playRound(t, g, ROCK, SCISSORS)
+	playRound(t, g, ROCK, SCISSORS)
+	if g.IsFinished() {
+		t.Fatal("finished with a round left that can still tie the game")
+	}
+}
+
+func TestFirstToSharedTargetPlaysSuddenDeath(t *testing.T) {
+	g := NewGame(Config{Rules: ClassicRules, Players: 3, Match: MatchConfig{Format: FIRST_TO, Target: 1}, Seed: 1})
+	playRound(t, g, ROCK, PAPER, SCISSORS)
+	if g.IsFinished() || !g.IsSuddenDeath() {
+		t.Fatalf("finished %t sudden death %t, want sudden death after a shared lead", g.IsFinished(), g.IsSuddenDeath())
+	}
+	playRound(t, g, PAPER, ROCK, ROCK)
+	if !g.IsFinished() || g.GetWinner() != 0 {
+		t.Errorf("finished %t winner %d, want player 0", g.IsFinished(), g.GetWinner())
+	}
+}
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReplayVersion is the version of the replay file format.
+const ReplayVersion = 1
+
+// ReplayConfig is the serialisable form of Config.
+type ReplayConfig struct {
+	Rules        string      `json:"rules"`
+	Players      int         `json:"players"`
+	Scoring      ScoringMode `json:"scoring"`
+	Match        MatchConfig `json:"match"`
+	CommitReveal bool        `json:"commitReveal"`
+	Seed         int64       `json:"seed"`
+}
+
+// Replay is a recorded game: the config it was created with and its full event log.
+type Replay struct {
+	Version int          `json:"version"`
+	Config  ReplayConfig `json:"config"`
+	Events  []Event      `json:"events"`
+}
+
+func (g *Game) replayConfig() ReplayConfig {
+	return ReplayConfig{
+		Rules:        g.rules.Name,
+		Players:      len(g.players),
+		Scoring:      g.scoring,
+		Match:        g.match,
+		CommitReveal: g.commitReveal,
+		Seed:         g.seed,
+	}
+}
+
+// NewReplay records the game's events.
+func NewReplay(g *Game) *Replay {
+	return &Replay{
+		Version: ReplayVersion,
+		Config:  g.replayConfig(),
+		Events:  g.Events(),
+	}
+}
+
+func (r *Replay) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func ReadReplay(rd io.Reader) (*Replay, error) {
+	r := &Replay{}
+	if err := json.NewDecoder(rd).Decode(r); err != nil {
+		return nil, err
+	}
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("unsupported replay version %d (expected %d)", r.Version, ReplayVersion)
+	}
+	return r, nil
+}
+
+// PlayReplay re-runs the players' inputs from the replay through a new game
+// and checks that the game produces exactly the same events.
+func PlayReplay(r *Replay) (*Game, error) {
+	rules, ok := GetRuleSet(r.Config.Rules)
+	if !ok {
+		return nil, fmt.Errorf("unknown rule set '%s'", r.Config.Rules)
+	}
+	if r.Config.Seed == 0 {
+		return nil, errors.New("replay has no seed")
+	}
+
+	g := NewGame(Config{
+		Rules:        rules,
+		Players:      r.Config.Players,
+		Scoring:      r.Config.Scoring,
+		Match:        r.Config.Match,
+		CommitReveal: r.Config.CommitReveal,
+		Seed:         r.Config.Seed,
+	})
+
+	for i, e := range r.Events {
+		if (e.Type == EventChoiceRevealed || e.Type == EventChoiceLocked) && e.Choice == nil {
+			return g, fmt.Errorf("event %d: missing choice", i)
+		}
+
+		var err error
+		switch e.Type {
+		case EventChoiceCommitted:
+			err = g.Commit(e.Player, e.Hash)
+		case EventChoiceRevealed:
+			err = g.Reveal(e.Player, *e.Choice, e.Nonce)
+		case EventChoiceLocked:
+			var ok bool
+			switch {
+			case *e.Choice == NONE:
+				ok, _ = g.Forfeit(e.Player)
+			case e.Random:
+				_, ok = g.MakeRandomChoice(e.Player)
+			default:
+				ok, _ = g.MakeChoice(e.Player, *e.Choice)
+			}
+			if !ok {
+				err = ErrChoiceRejected
+			}
+		case EventRoundResolved:
+			if !g.IsRoundFinished() {
+				err = errors.New("round resolved before all choices were made")
+				break
+			}
+			g.CompleteRound()
+		case EventMatchForfeited:
+			if !g.ForfeitMatch(e.Player) {
+				err = errors.New("match forfeit rejected")
+			}
+		}
+		if err != nil {
+			return g, fmt.Errorf("event %d (%s): %w", e.Seq, e.Type, err)
+		}
+	}
+
+	return g, compareEvents(r.Events, g.events)
+}
+
+// compareEvents checks that two event logs are identical, ignoring timestamps.
+func compareEvents(expected, actual []Event) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("replay produced %d events, expected %d", len(actual), len(expected))
+	}
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		e.Time, a.Time = time.Time{}, time.Time{}
+		// Compared in serialised form, so empty and nil slices are equal
+		eb, _ := json.Marshal(e)
+		ab, _ := json.Marshal(a)
+		if string(eb) != string(ab) {
+			return fmt.Errorf("event %d differs: expected %s, got %s", i, eb, ab)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordGames plays a few games that cover all event types.
+func recordGames(t *testing.T) map[string]*Game {
+	t.Helper()
+	games := map[string]*Game{}
+
+	// Jokers flip coins and get penalised, player 0 wins
+	g := NewGame(Config{Rules: JokerRules, Match: MatchConfig{Format: FIRST_TO, Target: 5}, Seed: 7})
+	playRound(t, g, JOKER, JOKER)
+	playRound(t, g, SCISSORS, JOKER)
+	g.Forfeit(1)
+	g.MakeRandomChoice(0)
+	g.CompleteRound()
+	for !g.IsFinished() {
+		playRound(t, g, ROCK, SCISSORS)
+	}
+	games["joker"] = g
+
+	// Commit-reveal, 3 players, minority scoring
+	g = NewGame(Config{Rules: ClassicRules, Players: 3, Scoring: MINORITY, CommitReveal: true, Match: MatchConfig{Format: BEST_OF, Target: 1}, Seed: 7})
+	for i, c := range []PlayerChoice{ROCK, ROCK, PAPER} {
+		g.Commit(i, CommitHash(c, "nonce"))
+	}
+	for i, c := range []PlayerChoice{ROCK, ROCK, PAPER} {
+		if err := g.Reveal(i, c, "nonce"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.CompleteRound()
+	games["commit-reveal"] = g
+
+	// Forfeited in the middle of a round
+	g = NewGame(Config{Rules: ClassicRules, Seed: 7})
+	playRound(t, g, ROCK, SCISSORS)
+	g.MakeChoice(1, PAPER)
+	g.ForfeitMatch(0)
+	games["forfeit"] = g
+
+	return games
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	for name, g := range recordGames(t) {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewReplay(g).Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			r, err := ReadReplay(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayed, err := PlayReplay(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replayed.GetWinner() != g.GetWinner() || !reflect.DeepEqual(replayed.GetScores(), g.GetScores()) {
+				t.Errorf("replay won by %d with %v, game by %d with %v", replayed.GetWinner(), replayed.GetScores(), g.GetWinner(), g.GetScores())
+			}
+		})
+	}
+}
+
+func TestReplayWritesPlayerZero(t *testing.T) {
+	g := recordGames(t)["joker"]
+	if g.GetWinner() != 0 {
+		t.Fatalf("winner %d, want 0", g.GetWinner())
+	}
+	for _, e := range NewReplay(g).Events {
+		b, _ := json.Marshal(e)
+		switch e.Type {
+		case EventGameFinished:
+			if !strings.Contains(string(b), `"winner":0`) {
+				t.Errorf("game finished without the winner: %s", b)
+			}
+		case EventCoinFlip:
+			if !strings.Contains(string(b), `"winner":`) || !strings.Contains(string(b), `"opponent":1`) {
+				t.Errorf("coin flip without winner or opponent: %s", b)
+			}
+		}
+	}
+}
+
+func TestReplayDetectsChanges(t *testing.T) {
+	r := NewReplay(recordGames(t)["joker"])
+	for i, e := range r.Events {
+		if e.Type == EventGameFinished {
+			other := 1
+			r.Events[i].Winner = &other
+		}
+	}
+	if _, err := PlayReplay(r); err == nil {
+		t.Error("replay with a different winner passed")
+	}
+}
+
+func TestSampleReplay(t *testing.T) {
+	f, err := os.Open("testdata/joker.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ReadReplay(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := PlayReplay(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GetWinner() != 0 {
+		t.Errorf("winner %d, want 0", g.GetWinner())
+	}
+}
+
+func TestReplayMissingChoice(t *testing.T) {
+	for _, event := range []string{`{"type":"choice_locked","player":0}`, `{"type":"choice_revealed","player":0,"nonce":"n0"}`} {
+		r, err := ReadReplay(strings.NewReader(`{"version":1,"config":{"rules":"classic","players":2,"match":{},"commitReveal":true,"seed":1},"events":[` + event + `]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := PlayReplay(r); err == nil || !strings.Contains(err.Error(), "missing choice") {
+			t.Errorf("%s: %v, want a missing choice error", event, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package game
+
+import (
+	"sort"
+	"sync"
+)
+
+// RuleSet describes a variant of the game: which choices are available,
+// which choice beats which, how scores change and how ties are broken.
+type RuleSet struct {
+	Name    string
+	Choices []PlayerChoice
+
+	// Beats maps a choice to all the choices it defeats.
+	Beats map[PlayerChoice][]PlayerChoice
+
+	// WinDelta is added to the winner's score for every won matchup.
+	WinDelta int
+	// LoseDelta is added to the loser's score when losing with the given choice (e.g. -1 for the Joker).
+	LoseDelta map[PlayerChoice]int
+
+	// CoinFlipTies lists choices where a tie is not a stalemate - a coin is flipped instead.
+	CoinFlipTies []PlayerChoice
+}
+
+var (
+	// JokerRules is the default rule set - RPS with the JOKER card.
+	JokerRules = &RuleSet{
+		Name:    "joker",
+		Choices: []PlayerChoice{ROCK, PAPER, SCISSORS, JOKER},
+		Beats: map[PlayerChoice][]PlayerChoice{
+			ROCK:     {SCISSORS},
+			PAPER:    {ROCK},
+			SCISSORS: {PAPER, JOKER},
+			JOKER:    {ROCK, PAPER},
+		},
+		WinDelta:     1,
+		LoseDelta:    map[PlayerChoice]int{JOKER: -1},
+		CoinFlipTies: []PlayerChoice{JOKER},
+	}
+
+	// ClassicRules is plain Rock-Paper-Scissors.
+	ClassicRules = &RuleSet{
+		Name:    "classic",
+		Choices: []PlayerChoice{ROCK, PAPER, SCISSORS},
+		Beats: map[PlayerChoice][]PlayerChoice{
+			ROCK:     {SCISSORS},
+			PAPER:    {ROCK},
+			SCISSORS: {PAPER},
+		},
+		WinDelta: 1,
+	}
+
+	// LizardSpockRules is Rock-Paper-Scissors-Lizard-Spock.
+	LizardSpockRules = &RuleSet{
+		Name:    "rpsls",
+		Choices: []PlayerChoice{ROCK, PAPER, SCISSORS, LIZARD, SPOCK},
+		Beats: map[PlayerChoice][]PlayerChoice{
+			ROCK:     {SCISSORS, LIZARD},
+			PAPER:    {ROCK, SPOCK},
+			SCISSORS: {PAPER, LIZARD},
+			LIZARD:   {PAPER, SPOCK},
+			SPOCK:    {ROCK, SCISSORS},
+		},
+		WinDelta: 1,
+	}
+)
+
+// Lobbies look up rule sets concurrently, RegisterRuleSet may be called at any time
+var (
+	ruleSetsMu sync.RWMutex
+	ruleSets   = map[string]*RuleSet{
+		JokerRules.Name:       JokerRules,
+		ClassicRules.Name:     ClassicRules,
+		LizardSpockRules.Name: LizardSpockRules,
+	}
+)
+
+// DefaultRuleSet returns the rule set used when none is specified.
+func DefaultRuleSet() *RuleSet {
+	return JokerRules
+}
+
+// GetRuleSet returns the registered rule set with the given name.
+func GetRuleSet(name string) (*RuleSet, bool) {
+	ruleSetsMu.RLock()
+	defer ruleSetsMu.RUnlock()
+	rs, ok := ruleSets[name]
+	return rs, ok
+}
+
+// RegisterRuleSet makes a custom rule set available by name.
+func RegisterRuleSet(rs *RuleSet) {
+	ruleSetsMu.Lock()
+	defer ruleSetsMu.Unlock()
+	ruleSets[rs.Name] = rs
+}
+
+// RuleSetNames returns the names of all registered rule sets, sorted.
+func RuleSetNames() []string {
+	ruleSetsMu.RLock()
+	defer ruleSetsMu.RUnlock()
+	names := []string{}
+	for name := range ruleSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (rs *RuleSet) IsValidChoice(choice PlayerChoice) bool {
+	for _, c := range rs.Choices {
+		if c == choice {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *RuleSet) beats(a, b PlayerChoice) bool {
+	for _, c := range rs.Beats[a] {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *RuleSet) isCoinFlipTie(choice PlayerChoice) bool {
+	for _, c := range rs.CoinFlipTies {
+		if c == choice {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve plays choice a against choice b.
+// Returns 0 if a wins, 1 if b wins and -1 on stalemate.
+// flip is called to break coin flip ties and should return true if b wins.
+func (rs *RuleSet) Resolve(a, b PlayerChoice, flip func() bool) int {
+	if a == b {
+		if !rs.isCoinFlipTie(a) {
+			return -1
+		}
+		if flip() {
+			return 1
+		}
+		return 0
+	}
+
+	if rs.beats(a, b) {
+		return 0
+	}
+	if rs.beats(b, a) {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,83 @@
+package game
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestJokerRules(t *testing.T) {
+	tests := []struct {
+		a, b PlayerChoice
+		want int
+	}{
+		{ROCK, SCISSORS, 0},
+		{ROCK, PAPER, 1},
+		{ROCK, JOKER, 1},
+		{PAPER, ROCK, 0},
+		{PAPER, SCISSORS, 1},
+		{PAPER, JOKER, 1},
+		{SCISSORS, PAPER, 0},
+		{SCISSORS, JOKER, 0},
+		{SCISSORS, ROCK, 1},
+		{JOKER, ROCK, 0},
+		{JOKER, PAPER, 0},
+		{JOKER, SCISSORS, 1},
+		{ROCK, ROCK, -1},
+		{PAPER, PAPER, -1},
+		{SCISSORS, SCISSORS, -1},
+	}
+	noFlip := func() bool {
+		t.Fatal("coin flipped for a decided matchup")
+		return false
+	}
+	for _, tt := range tests {
+		if got := JokerRules.Resolve(tt.a, tt.b, noFlip); got != tt.want {
+			t.Errorf("Resolve(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	// Two Jokers flip a coin
+	for _, bWins := range []bool{false, true} {
+		want := 0
+		if bWins {
+			want = 1
+		}
+		if got := JokerRules.Resolve(JOKER, JOKER, func() bool { return bWins }); got != want {
+			t.Errorf("Resolve(JOKER, JOKER) with flip %t = %d, want %d", bWins, got, want)
+		}
+	}
+}
+
+func TestRuleSetsAreSymmetric(t *testing.T) {
+	for _, name := range RuleSetNames() {
+		rs, _ := GetRuleSet(name)
+		for _, a := range rs.Choices {
+			for _, b := range rs.Choices {
+				if a != b && rs.beats(a, b) && rs.beats(b, a) {
+					t.Errorf("%s: %d and %d beat each other", name, a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestRegisterRuleSetConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterRuleSet(&RuleSet{Name: fmt.Sprintf("test-%d", i), Choices: ClassicRules.Choices, Beats: ClassicRules.Beats})
+		}()
+		go func() {
+			defer wg.Done()
+			GetRuleSet("classic")
+			RuleSetNames()
+		}()
+	}
+	wg.Wait()
+	if _, ok := GetRuleSet("test-9"); !ok {
+		t.Error("registered rule set not found")
+	}
+}
@@ -1,5 +1,10 @@
-module github.com/venom1270/RPS
+module github.com/venom1270/RPS/server
 
 go 1.22.2
 
-require github.com/coder/websocket v1.8.12 // indirect
+require (
+	github.com/coder/websocket v1.8.12
+	github.com/venom1270/RPS/protocol v0.0.0
+)
+
+replace github.com/venom1270/RPS/protocol => ../protocol
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+)
+
+var ErrNotHost = messaging.NewError(messaging.ErrNotHost, "only the host can do that")
+
+// Query returns the settings in the query string format lobbies are created with, without the password.
+func (s LobbySettings) Query() url.Values {
+	scoring := "pairwise"
+	if s.Scoring == game.MINORITY {
+		scoring = "minority"
+	}
+
+	q := url.Values{}
+	q.Set("rules", s.Rules.Name)
+	q.Set("players", strconv.Itoa(s.MaxPlayers))
+	q.Set("scoring", scoring)
+	q.Set("format", s.Match.Format.String())
+	q.Set("target", strconv.Itoa(s.Match.Target))
+	q.Set("maxRounds", strconv.Itoa(s.Match.MaxRounds))
+	q.Set("suddenDeath", strconv.FormatBool(s.Match.SuddenDeath))
+	q.Set("timeout", strconv.Itoa(int(s.RoundTimeout/time.Second)))
+	q.Set("onTimeout", s.TimeoutPolicy.String())
+	q.Set("commitReveal", strconv.FormatBool(s.CommitReveal))
+	q.Set("private", strconv.FormatBool(s.Private))
+	return q
+}
+
+// kick removes a player from the lobby while no game is running, only the host can do that.
+func (l *Lobby) kick(host string, clientId string) error {
+	reply := make(chan error, 1)
+	return l.call(kickEvent{host: host, clientId: clientId, reply: reply}, reply)
+}
+
+// transferHost makes another player the host, only the host can do that.
+func (l *Lobby) transferHost(host string, clientId string) error {
+	reply := make(chan error, 1)
+	return l.call(transferHostEvent{host: host, clientId: clientId, reply: reply}, reply)
+}
+
+// changeSettings changes the settings given in the query string while no game is running, only the host can do that.
+func (l *Lobby) changeSettings(host string, query string) error {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return messaging.Errorf(messaging.ErrInvalidSettings, "invalid settings '%s'", query)
+	}
+	reply := make(chan error, 1)
+	return l.call(settingsEvent{host: host, query: q, reply: reply}, reply)
+}
+
+// closeLobby disbands the lobby while no game is running, only the host can do that with their session token.
+func (l *Lobby) closeLobby(token string) error {
+	reply := make(chan error, 1)
+	return l.call(closeEvent{token: token, reply: reply}, reply)
+}
+
+// call sends the event to the event loop and waits for its reply.
+func (l *Lobby) call(ev lobbyEvent, reply chan error) error {
+	if !l.send(ev) {
+		return ErrLobbyClosed
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-l.done:
+		return ErrLobbyClosed
+	}
+}
+
+// checkHost returns ErrNotHost if the player isn't the lobby's host.
+func (l *Lobby) checkHost(clientId string) error {
+	if clientId != l.host {
+		return ErrNotHost
+	}
+	return nil
+}
+
+// checkHostToken returns an error if the token isn't the host's session token.
+// Requests that don't come over the lobby's connections prove they are from the host this way.
+func (l *Lobby) checkHostToken(token string) error {
+	if l.host == "" {
+		return messaging.NewError(messaging.ErrNotHost, "the lobby has no host")
+	}
+	want := l.hostToken
+	if p := l.findPlayer(l.host); p != nil {
+		want = p.token
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(want), []byte(token)) != 1 {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+// createHostedLobby creates a lobby whose host is the player creating it, before they joined. It returns the
+// session token the host gets once they join. Without joining within the idle timeout the host gives up the role.
+func (cs *gameServer) createHostedLobby(name string, settings LobbySettings, host string) (*Lobby, string, error) {
+	token := newSessionToken()
+	lobby, err := cs.lobbies.CreateIfAbsent(name, func() *Lobby {
+		l := newLobby(name, settings, cs)
+		l.host = host
+		l.hostToken = token
+		return l
+	})
+	if err != nil {
+		log.Printf("Lobby creation failed - '%s': %v", name, err)
+		return nil, "", err
+	}
+
+	if idle := cs.opts.idleTimeout; idle > 0 {
+		time.AfterFunc(idle, func() {
+			lobby.send(hostAbsentEvent{})
+		})
+	}
+	return lobby, token, nil
+}
+
+// handleHostAbsent passes the host role on if the player that created the lobby still didn't join,
+// a lobby nobody joined is closed.
+func (l *Lobby) handleHostAbsent() {
+	if l.hostToken == "" {
+		return
+	}
+	log.Printf("Host %s didn't join lobby %s", l.host, l.id)
+	l.hostToken = ""
+	l.migrateHost()
+}
+
+func (l *Lobby) handleKick(host string, clientId string) error {
+	if err := l.checkHost(host); err != nil {
+		return err
+	}
+	if !l.betweenGames() {
+		return messaging.NewError(messaging.ErrGameStarted, "game already started")
+	}
+	if clientId == host {
+		return messaging.NewError(messaging.ErrBadRequest, "the host can't kick themselves, exit the lobby instead")
+	}
+	if l.findPlayer(clientId) == nil {
+		return messaging.Errorf(messaging.ErrPlayerNotFound, "player %s is not in the lobby", clientId)
+	}
+
+	log.Printf("Host %s kicked player %s from lobby %s", host, clientId, l.id)
+	if s := l.subscriberOf(clientId); s != nil {
+		l.handleUnsubscribe(s)
+		go s.close(websocket.StatusPolicyViolation, "Kicked by the host")
+	}
+	l.handleLeave(clientId)
+	l.publish(messaging.CreateTextMessage("KICKED " + clientId))
+	l.sendLobbyState()
+	return nil
+}
+
+func (l *Lobby) handleTransferHost(host string, clientId string) error {
+	if err := l.checkHost(host); err != nil {
+		return err
+	}
+	p := l.findPlayer(clientId)
+	if p == nil {
+		return messaging.Errorf(messaging.ErrPlayerNotFound, "player %s is not in the lobby", clientId)
+	}
+	if p.bot {
+		return messaging.NewError(messaging.ErrBadRequest, "bots can't be the host")
+	}
+
+	l.setHost(clientId)
+	return nil
+}
+
+func (l *Lobby) handleSettings(host string, q url.Values) error {
+	if err := l.checkHost(host); err != nil {
+		return err
+	}
+	if !l.betweenGames() {
+		return messaging.NewError(messaging.ErrGameStarted, "game already started")
+	}
+
+	settings, err := applyLobbySettings(l.settings(), q)
+	if err != nil {
+		return messaging.NewError(messaging.ErrInvalidSettings, err.Error())
+	}
+	if settings.MaxPlayers < len(l.players) {
+		return messaging.Errorf(messaging.ErrInvalidSettings, "%d players are already in the lobby", len(l.players))
+	}
+
+	l.maxPlayers = settings.MaxPlayers
+	l.rules = settings.Rules
+	l.scoring = settings.Scoring
+	l.match = settings.Match
+	l.roundTimeout = settings.RoundTimeout
+	l.timeoutPolicy = settings.TimeoutPolicy
+	l.commitReveal = settings.CommitReveal
+	l.private = settings.Private
+	l.password = settings.Password
+
+	// Players agreed to the old settings
+	for i := range l.players {
+		if !l.players[i].bot {
+			l.players[i].ready = false
+		}
+	}
+
+	log.Printf("Host %s changed the settings of lobby %s: %s", host, l.id, settings.Query().Encode())
+	l.publish(messaging.CreateCommandMessage(messaging.CommandLobbySettings, settings.Query().Encode()))
+	l.sendLobbyState()
+	return nil
+}
+
+func (l *Lobby) handleClose(token string) error {
+	if l.tournament != nil {
+		return messaging.NewError(messaging.ErrNotHost, "lobbies of tournament matches can't be closed")
+	}
+	if err := l.checkHostToken(token); err != nil {
+		return err
+	}
+	if !l.betweenGames() {
+		return messaging.NewError(messaging.ErrGameStarted, "game already started")
+	}
+
+	log.Printf("Host %s closed lobby %s", l.host, l.id)
+	l.publish(messaging.CreateTextMessage("The host closed the lobby"))
+	l.handleDisband()
+	return nil
+}
+
+// settings returns the lobby's current settings. Called from the lobby's event loop.
+func (l *Lobby) settings() LobbySettings {
+	return LobbySettings{
+		Rules:         l.rules,
+		MaxPlayers:    l.maxPlayers,
+		Scoring:       l.scoring,
+		Match:         l.match,
+		RoundTimeout:  l.roundTimeout,
+		TimeoutPolicy: l.timeoutPolicy,
+		CommitReveal:  l.commitReveal,
+		Private:       l.private,
+		Password:      l.password,
+	}
+}
+
+// setHost makes the player the host and tells everyone.
+func (l *Lobby) setHost(clientId string) {
+	l.host = clientId
+	log.Printf("Player %s is the host of lobby %s", clientId, l.id)
+	l.publish(messaging.CreateCommandMessage(messaging.CommandLobbyHost, clientId))
+	l.sendLobbyState()
+}
+
+// migrateHost passes the host role to the player that joined first after the host left.
+// A lobby without human players is disbanded.
+func (l *Lobby) migrateHost() {
+	for _, p := range l.players {
+		if !p.bot {
+			l.setHost(p.clientId)
+			return
+		}
+	}
+
+	l.host = ""
+	if !l.stopped {
+		log.Printf("No players left to host lobby %s", l.id)
+		l.handleDisband()
+	}
+}
+
+// sendLobbyInfo tells a player or spectator that just joined who the host is, the lobby's settings and the series so far.
+func (l *Lobby) sendLobbyInfo(s *subscriber) {
+	if s.bot != nil {
+		return
+	}
+	l.sendTo(s, messaging.CreateCommandMessage(messaging.CommandLobbyHost, l.host))
+	l.sendTo(s, messaging.CreateCommandMessage(messaging.CommandLobbySettings, l.settings().Query().Encode()))
+	if l.series.Games > 0 {
+		l.sendTo(s, messaging.CreatePayloadMessage(messaging.CommandSeries, l.series))
+	}
+}
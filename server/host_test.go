@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// joinedLobby returns a lobby for 4 players the given players joined, the first one is the host.
+func joinedLobby(t *testing.T, clientIds ...string) *Lobby {
+	t.Helper()
+	cs := newGameServer(serverOptions{})
+	settings := defaultLobbySettings()
+	settings.MaxPlayers = 4
+	l, err := cs.createLobby("game", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.do(func() {
+		for _, id := range clientIds {
+			if err := l.handleJoin(Player{clientId: id}); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	return l
+}
+
+func TestHostLeaves(t *testing.T) {
+	l := joinedLobby(t, "a", "b", "c")
+	if err := l.addBot("random"); err != nil {
+		t.Fatal(err)
+	}
+
+	host := func() string {
+		var host string
+		l.do(func() { host = l.host })
+		return host
+	}
+	if got := host(); got != "a" {
+		t.Fatalf("host %q, want the player that joined first", got)
+	}
+
+	// The player that joined first after the host takes over
+	l.exitLobby("a")
+	if got := host(); got != "b" {
+		t.Errorf("host %q after the host left, want b", got)
+	}
+	l.exitLobby("c")
+	if got := host(); got != "b" {
+		t.Errorf("host %q after another player left, want b", got)
+	}
+
+	// Only the bot is left
+	l.exitLobby("b")
+	<-l.done
+	if l.server.lobbies.Get("game") != nil {
+		t.Error("lobby with only bots left wasn't disbanded")
+	}
+}
+
+func TestOnlyHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func(l *Lobby, clientId string) error
+	}{
+		{"kick", func(l *Lobby, clientId string) error { return l.kick(clientId, "c") }},
+		{"transfer host", func(l *Lobby, clientId string) error { return l.transferHost(clientId, "c") }},
+		{"settings", func(l *Lobby, clientId string) error { return l.changeSettings(clientId, "players=3") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := joinedLobby(t, "a", "b", "c")
+			if err := tt.request(l, "b"); messaging.CodeOf(err) != messaging.ErrNotHost {
+				t.Errorf("request of a player: %v, want %s", err, messaging.ErrNotHost)
+			}
+			if err := tt.request(l, "a"); err != nil {
+				t.Errorf("request of the host: %v", err)
+			}
+		})
+	}
+}
+
+func TestSettingsDuringGame(t *testing.T) {
+	l, _ := startTestGame(t)
+	var host, rules string
+	l.do(func() { host, rules = l.host, l.rules.Name })
+
+	if err := l.changeSettings(host, "rules=rpsls"); messaging.CodeOf(err) != messaging.ErrGameStarted {
+		t.Errorf("changing the settings during a game: %v, want %s", err, messaging.ErrGameStarted)
+	}
+	l.do(func() {
+		if l.rules.Name != rules {
+			t.Errorf("rules %s, want the settings unchanged", l.rules.Name)
+		}
+	})
+}
+
+func TestSettingsResetReady(t *testing.T) {
+	l := joinedLobby(t, "a", "b", "c")
+	if err := l.addBot("random"); err != nil {
+		t.Fatal(err)
+	}
+	l.ready("a")
+	l.ready("b")
+
+	if err := l.changeSettings("a", "rules=rpsls"); err != nil {
+		t.Fatal(err)
+	}
+	l.do(func() {
+		if l.rules.Name != "rpsls" {
+			t.Errorf("rules %s, want rpsls", l.rules.Name)
+		}
+		for _, p := range l.players {
+			if p.ready != p.bot {
+				t.Errorf("player %s ready = %t after the settings changed, only bots should stay ready", p.clientId, p.ready)
+			}
+		}
+	})
+}
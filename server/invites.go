@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+var (
+	ErrPasswordRequired = messaging.NewError(messaging.ErrWrongPassword, "lobby requires a password")
+	ErrWrongPassword    = messaging.NewError(messaging.ErrWrongPassword, "wrong password")
+)
+
+// Invite codes are short enough to type, without characters that are easy to mix up (0/O, 1/I)
+const (
+	inviteAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	inviteLength   = 6
+)
+
+// newInviteCode returns a random invite code, the registry makes sure it is unique.
+func newInviteCode() string {
+	b := make([]byte, inviteLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = inviteAlphabet[int(b[i])%len(inviteAlphabet)]
+	}
+	return string(b)
+}
+
+// inviteHandler returns the name of the lobby an invite code belongs to: /invite/{code}
+// The client joins with /joinLobby/{lobby}/{clientId}?invite={code}, which also works for lobbies with a password.
+func (cs *gameServer) inviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use GET"))
+		return
+	}
+
+	code := strings.TrimPrefix(r.URL.Path, "/invite/")
+	if code == "" || strings.Contains(code, "/") {
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, "expected /invite/{code}"))
+		return
+	}
+
+	lobby := cs.lobbies.ByInvite(code)
+	if lobby == nil {
+		writeError(w, messaging.NewError(messaging.ErrLobbyNotFound, "unknown invite code"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Lobby string `json:"lobby"`
+	}{lobby.id})
+}
+
+// lobbyAccess checks the password or invite code given in the request's query, ?password=... or ?invite=...
+func lobbyAccess(r *http.Request, lobby *Lobby) error {
+	q := r.URL.Query()
+	err := lobby.checkAccess(q.Get("password"), q.Get("invite"))
+	if err != nil {
+		log.Printf("Access to lobby %s denied: %v", lobby.id, err)
+	}
+	return err
+}
+
+// checkAccess returns an error if neither the password nor the invite code let a player into the lobby.
+func (l *Lobby) checkAccess(password string, invite string) error {
+	err := error(ErrLobbyClosed)
+	l.do(func() {
+		err = l.accessError(password, invite)
+	})
+	return err
+}
+
+func (l *Lobby) accessError(password string, invite string) error {
+	if l.password == "" || (invite != "" && sameSecret(strings.ToUpper(invite), l.invite)) {
+		return nil
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if !sameSecret(password, l.password) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// sameSecret compares in constant time, hashing first so the time doesn't depend on the length either.
+func sameSecret(a string, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// listed reports whether the lobby shows up in the lobby list, private and disbanded lobbies don't.
+func (l *Lobby) listed() bool {
+	listed := false
+	l.do(func() {
+		listed = !l.private
+	})
+	return listed
+}
+
+// sendInvite tells a player the lobby's invite code to pass on to friends.
+func (l *Lobby) sendInvite(s *subscriber) {
+	if s.bot != nil {
+		return
+	}
+	l.sendTo(s, messaging.CreateCommandMessage(messaging.CommandInvite, l.invite))
+}
@@ -4,20 +4,27 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/coder/websocket"
-	"github.com/venom1270/RPS/game"
-	"github.com/venom1270/RPS/messaging"
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/bot"
+	"github.com/venom1270/RPS/server/chat"
+	"github.com/venom1270/RPS/server/game"
 )
 
 type Player struct {
 	clientId string
 	ready    bool
+	bot      bool
+	// token lets the player take their seat back after the connection dropped, see handleRejoin
+	token string
 }
 
 // subscriber represents a subscriber.
@@ -26,14 +33,137 @@ type Player struct {
 type subscriber struct {
 	id     int
 	player *Player
-	msgs   chan []byte
+	msgs   chan messaging.Message
+	codec  messaging.Codec // wire format negotiated with the client
 
-	readCmdCh chan int
-	readMsgCh chan []byte
+	readCmdCh chan messaging.Message
 	readErrCh chan error
 
 	closeSlow func()
 	c         *websocket.Conn
+
+	// Bot players have no websocket connection, a strategy makes their choices instead
+	bot      bot.Strategy
+	botDone  chan struct{}
+	botClose sync.Once
+
+	// The connection dropped and the seat is kept for a rejoin, only used by the event loop
+	disconnected bool
+}
+
+// write sends msg to the subscriber directly, bots get it through the msgs channel. A nil msg is skipped.
+func (s *subscriber) write(ctx context.Context, msg *messaging.Message) error {
+	if msg == nil {
+		return nil
+	}
+	if s.c == nil {
+		select {
+		case s.msgs <- *msg:
+		default:
+		}
+		return nil
+	}
+	return s.c.Write(ctx, websocket.MessageText, s.codec.Encode(*msg))
+}
+
+// reply answers a client's request. JSON clients get a CommandReply with the request's id, status and error code.
+// Legacy clients get the legacy message, or the error as text if legacy is nil - nil if there is nothing to send.
+func (s *subscriber) reply(req messaging.Message, err error, legacy *messaging.Message) *messaging.Message {
+	if s.codec != messaging.JSONCodec {
+		if legacy == nil && err != nil {
+			return messaging.CreateTextMessage(messaging.MessageOf(err))
+		}
+		return legacy
+	}
+
+	cmd := req.Cmd
+	if req.Type == messaging.MessageText {
+		// Plain text input is a choice
+		cmd = messaging.CommandChoice
+	}
+	msg := messaging.CreatePayloadMessage(messaging.CommandReply, messaging.NewReply(cmd, err))
+	msg.Id = req.Id
+	return msg
+}
+
+// notInLobby returns the error for requests of players that aren't in the lobby, nil if ok.
+func notInLobby(ok bool) error {
+	if ok {
+		return nil
+	}
+	return messaging.NewError(messaging.ErrNotInLobby, "Player is not in the lobby")
+}
+
+// close closes the subscriber's websocket connection or stops the bot.
+func (s *subscriber) close(code websocket.StatusCode, reason string) {
+	if s.c == nil {
+		if s.botDone != nil {
+			s.botClose.Do(func() { close(s.botDone) })
+		}
+		return
+	}
+	s.c.Close(code, reason)
+}
+
+// LobbySettings holds the options a lobby is created with.
+type LobbySettings struct {
+	Rules      *game.RuleSet
+	MaxPlayers int
+	Scoring    game.ScoringMode
+	Match      game.MatchConfig
+
+	// RoundTimeout is the time players have to make a choice, 0 means no limit.
+	RoundTimeout  time.Duration
+	TimeoutPolicy TimeoutPolicy
+
+	// CommitReveal makes players send a hash of their choice first and reveal it after all players committed.
+	CommitReveal bool
+
+	// Private lobbies are not listed, players join them by name or invite code.
+	Private bool
+	// Password is needed to join or spectate the lobby unless the player has the invite code, empty for none.
+	Password string
+}
+
+// TimeoutPolicy decides what happens when a player doesn't make a choice in time.
+type TimeoutPolicy int
+
+const (
+	TimeoutForfeitRound TimeoutPolicy = iota // the player loses the round
+	TimeoutRandomChoice                      // a random choice is made for the player
+	TimeoutForfeitMatch                      // the player loses the whole game
+)
+
+func ParseTimeoutPolicy(s string) (TimeoutPolicy, error) {
+	switch s {
+	case "forfeit-round":
+		return TimeoutForfeitRound, nil
+	case "random":
+		return TimeoutRandomChoice, nil
+	case "forfeit-match":
+		return TimeoutForfeitMatch, nil
+	}
+	return TimeoutForfeitRound, fmt.Errorf("unknown timeout policy '%s'", s)
+}
+
+func (p TimeoutPolicy) String() string {
+	switch p {
+	case TimeoutRandomChoice:
+		return "random"
+	case TimeoutForfeitMatch:
+		return "forfeit-match"
+	default:
+		return "forfeit-round"
+	}
+}
+
+func defaultLobbySettings() LobbySettings {
+	return LobbySettings{
+		Rules:      game.DefaultRuleSet(),
+		MaxPlayers: 2,
+		Scoring:    game.PAIRWISE,
+		Match:      game.DefaultMatchConfig(),
+	}
 }
 
 type Lobby struct {
@@ -41,102 +171,377 @@ type Lobby struct {
 	players    []Player
 	maxPlayers int
 	state      string
+	rules      *game.RuleSet
+	scoring    game.ScoringMode
+	match      game.MatchConfig
 	game       *game.Game
-	server     *gameServer
+
+	roundTimeout  time.Duration
+	timeoutPolicy TimeoutPolicy
+	commitReveal  bool
+	server        *gameServer
+
+	private  bool
+	password string
+	invite   string // set by the registry, see ByInvite
+	host     string // client id of the player that can kick players and change settings, see host.go
+	// hostToken is the session token of a host that created the lobby over the REST API and didn't join yet
+	hostToken string
+
+	// Results of the games the same players played in the lobby, see rematch.go
+	series    messaging.Series
+	swapSeats bool // a player voted to change seats in the rematch
+
+	tournament *tournamentMatch // nil unless the lobby was opened for a tournament match, see tournaments.go
 
 	// Websocket stuff
 	subscriberMessageBuffer int
 	subscriberIdCount       int
 	logf                    func(f string, v ...interface{})
-	subscribersMu           sync.Mutex
 	subscribers             []*subscriber
+	spectators              []*subscriber // read-only connections, they get everything that is published
+
+	chatLimiter *chat.RateLimiter
+	chatLog     []messaging.ChatMessage // latest messages for players who join later
+
+	// Event loop - players, subscribers and the game are only touched by the loop's goroutine
+	events  chan lobbyEvent
+	done    chan struct{}
+	stopped bool
+
+	// Current round
+	round         int
+	seats         []*subscriber // subscriber of each player in the game
+	inputDone     []bool
+	committed     []bool
+	revealOpen    bool
+	roundDone     chan struct{}
+	roundDeadline time.Time // zero if the round has no timer
+}
+
+// Lobby states
+const (
+	LobbyCreated  = "CREATED"
+	LobbyStarting = "STARTING"
+	LobbyInGame   = "IN_GAME"
+	LobbyFinished = "FINISHED"
+)
+
+// How long players are told the game is starting before the first round
+const gameStartDelay = 5 * time.Second
+
+func newLobby(id string, settings LobbySettings, server *gameServer) *Lobby {
+	l := &Lobby{
+		id:         id,
+		maxPlayers: settings.MaxPlayers,
+		state:      LobbyCreated,
+		rules:      settings.Rules,
+		scoring:    settings.Scoring,
+		match:      settings.Match,
+
+		roundTimeout:  settings.RoundTimeout,
+		timeoutPolicy: settings.TimeoutPolicy,
+		commitReveal:  settings.CommitReveal,
+
+		private:  settings.Private,
+		password: settings.Password,
+
+		subscriberMessageBuffer: 16,
+		subscriberIdCount:       0,
+		logf:                    log.Printf,
+		subscribers:             []*subscriber{},
+		server:                  server,
 
-	// Async game mutex
-	inputMutex sync.Mutex
+		chatLimiter: newChatLimiter(),
+
+		events: make(chan lobbyEvent, 64),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
 }
 
-func (l Lobby) String() string {
-	return fmt.Sprintf("%s,%d,%d,%s", l.id, len(l.players), l.maxPlayers, l.state)
+func (l *Lobby) String() string {
+	var str string
+	if !l.do(func() {
+		locked := 0
+		if l.password != "" {
+			locked = 1
+		}
+		str = fmt.Sprintf("%s,%d,%d,%s,%s,%d,%d", l.id, len(l.players), l.maxPlayers, l.state, l.match, len(l.spectators), locked)
+	}) {
+		return fmt.Sprintf("%s,0,%d,%s,%s,0,0", l.id, l.maxPlayers, LobbyFinished, l.match)
+	}
+	return str
 }
 
-func (l *Lobby) exitLobby(clientId string) bool {
+// addPlayer adds the player if there is space left.
+func (l *Lobby) addPlayer(player Player) error {
+	reply := make(chan error, 1)
+	if !l.send(joinEvent{player: player, reply: reply}) {
+		return ErrLobbyClosed
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-l.done:
+		return ErrLobbyClosed
+	}
+}
 
+// getPlayers returns a snapshot of the lobby's players.
+func (l *Lobby) getPlayers() []Player {
+	var players []Player
+	l.do(func() {
+		players = make([]Player, len(l.players))
+		copy(players, l.players)
+	})
+	return players
+}
+
+func (l *Lobby) getLobbyState() string {
+	state := LobbyFinished
+	l.do(func() {
+		state = l.state
+	})
+	return state
+}
+
+func (l *Lobby) exitLobby(clientId string) bool {
 	log.Println("Exit lobby accepted:", clientId)
 
-	ok := false
+	reply := make(chan bool, 1)
+	if !l.send(leaveEvent{clientId: clientId, reply: reply}) {
+		return false
+	}
+	select {
+	case ok := <-reply:
+		return ok
+	case <-l.done:
+		return false
+	}
+}
+
+// humanPlayers returns the number of players that aren't bots.
+func (l *Lobby) humanPlayers() int {
+	n := 0
+	l.do(func() {
+		n = l.countHumans()
+	})
+	return n
+}
+
+func (l *Lobby) countHumans() int {
+	n := 0
+	for _, p := range l.players {
+		if !p.bot {
+			n++
+		}
+	}
+	return n
+}
+
+// setReady sets the player's ready flag, returns false if the player isn't in the lobby.
+func (l *Lobby) setReady(clientId string, ready bool) bool {
+	reply := make(chan bool, 1)
+	if !l.send(readyEvent{clientId: clientId, ready: ready, reply: reply}) {
+		return false
+	}
+	select {
+	case ok := <-reply:
+		return ok
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *Lobby) ready(clientId string) bool {
+	return l.setReady(clientId, true)
+}
+
+func (l *Lobby) unready(clientId string) bool {
+	return l.setReady(clientId, false)
+}
+
+// handleJoin takes a seat for the player if there is one left.
+func (l *Lobby) handleJoin(player Player) error {
+	for _, p := range l.players {
+		if p.clientId == player.clientId {
+			return ErrAlreadyJoined
+		}
+	}
+	if len(l.players) >= l.maxPlayers {
+		return ErrLobbyFull
+	}
+	if l.reserved(player.clientId) {
+		return ErrSeatReserved
+	}
+	if l.hostToken != "" && player.clientId != l.host && len(l.players)+1 >= l.maxPlayers {
+		// The last seat is kept for the host that created the lobby
+		return ErrLobbyFull
+	}
 
+	if l.hostToken != "" && player.clientId == l.host {
+		player.token = l.hostToken
+		l.hostToken = ""
+	} else if !player.bot {
+		player.token = newSessionToken()
+	}
+	if l.tournament != nil {
+		// The match starts as soon as both players are there
+		player.ready = true
+	}
+	l.players = append(l.players, player)
+	log.Printf("Joining player %s to lobby %s successful! %d/%d", player.clientId, l.id, len(l.players), l.maxPlayers)
+
+	if l.host == "" && !player.bot && l.tournament == nil {
+		// The player creating the lobby joins first, tournament lobbies have no host
+		l.host = player.clientId
+	}
+	return nil
+}
+
+func (l *Lobby) handleLeave(clientId string) bool {
+	// Nobody would make the player's choices anymore
+	l.forfeitSeat(clientId)
+
+	found := false
 	for i, v := range l.players {
 		if v.clientId == clientId {
 			l.players = append(l.players[:i], l.players[i+1:]...)
-			ok = true
+			found = true
 			break
 		}
 	}
-
-	if !ok {
+	if !found {
 		log.Printf("Player %s not found in lobby %s", clientId, l.id)
 	}
+	if found && l.tournament != nil {
+		// Leaving forfeits the tournament match, after the player is gone
+		defer l.forfeitTournament(clientId)
+	}
+	if found && clientId == l.host {
+		defer l.migrateHost()
+	}
+	if found && l.state == LobbyFinished {
+		// The remaining players may all have voted for a rematch
+		defer l.checkRematch()
+	}
 
-	for i, v := range l.subscribers {
+	var s *subscriber
+	for _, v := range l.subscribers {
 		if v.player.clientId == clientId {
-			l.subscribers[i].c.Close(websocket.StatusGoingAway, "Lobby exit on request")
-			// Sometimes a read error gets logged - this is probably because a ead operation is running somewhere
-			log.Printf("Connection with player %s closed!", clientId)
-			l.sendLobbyState()
-			l.publish(messaging.CreateTextMessage("EXIT " + clientId).Parse())
-			return true
+			s = v
+			break
 		}
 	}
+	if s == nil {
+		return false
+	}
 
-	return false
+	// Closing waits for the close handshake, don't block the event loop
+	go s.close(websocket.StatusGoingAway, "Lobby exit on request")
+	// Sometimes a read error gets logged - this is probably because a ead operation is running somewhere
+	log.Printf("Connection with player %s closed!", clientId)
+	l.sendLobbyState()
+	l.publish(messaging.CreateTextMessage("EXIT " + clientId))
+	return true
 }
 
-func (l *Lobby) ready(clientId string) bool {
+func (l *Lobby) handleReady(clientId string, ready bool) bool {
 	for ip, p := range l.players {
 		if p.clientId == clientId {
-			l.players[ip].ready = true
-			log.Println("Ready for clientId", clientId, "success!")
+			l.players[ip].ready = ready
+			log.Printf("Ready=%t for clientId %s success!", ready, clientId)
 			l.sendLobbyState()
-			go l.checkStartGame()
+			if ready && l.state == LobbyFinished {
+				l.checkRematch()
+			} else if ready {
+				l.checkStartGame()
+			}
 			return true
 		}
 	}
 	return false
 }
 
-func (l *Lobby) unready(clientId string) bool {
-	for ip, p := range l.players {
-		if p.clientId == clientId {
-			l.players[ip].ready = false
-			log.Println("Unready for clientId", clientId, "success!")
-			l.sendLobbyState()
-			return true
+func (l *Lobby) handleSubscribe(s *subscriber) {
+	s.id = l.subscriberIdCount
+	l.subscriberIdCount++
+	l.subscribers = append(l.subscribers, s)
+
+	// Send message to everyone that someone joined
+	l.publishExcept(messaging.CreateTextMessage("JOINED "+s.player.clientId), s.player.clientId)
+	l.sendSession(s)
+	l.sendInvite(s)
+	l.sendLobbyInfo(s)
+	l.sendLobbyState()
+	l.sendChatLog(s)
+
+	// Players that join ready (e.g. from matchmaking or bots) may complete the lobby
+	l.checkStartGame()
+}
+
+func (l *Lobby) handleUnsubscribe(s *subscriber) {
+	log.Printf("DELETING SUBSCRIBER :(")
+
+	for i := range l.subscribers {
+		if l.subscribers[i] == s {
+			l.subscribers = append(l.subscribers[:i], l.subscribers[i+1:]...)
+			break
 		}
 	}
-	return false
+}
+
+// handleDisband closes all connections, removes the lobby from the server and stops the event loop.
+func (l *Lobby) handleDisband() {
+	if l.stopped {
+		return
+	}
+	log.Printf("Disconnecting subscribers from lobby %s", l.id)
+	for _, s := range l.subscribers {
+		go s.close(websocket.StatusAbnormalClosure, "TIMOUT")
+	}
+	for _, s := range l.spectators {
+		go s.close(websocket.StatusNormalClosure, "Lobby closed")
+	}
+
+	log.Printf("Removing lobby %s", l.id)
+	l.server.lobbies.Remove(l)
+	l.stopped = true
+
+	log.Printf("Done disbanding lobby %s", l.id)
 }
 
 // Sends lobby state to all connected clients (every change etc...)
 func (l *Lobby) sendLobbyState() {
 	log.Printf("Sending lobby state...")
-	l.publish(messaging.CreateCommandMessage(messaging.CommandLobbyState, l.getState()).Parse())
+	l.publish(messaging.CreatePayloadMessage(messaging.CommandLobbyState, l.lobbyState()))
 }
 
-func (l *Lobby) getState() string {
-	// Format: lobby#playerName[string]_ready[0/1];...
-	stateStr := l.id + "#"
-	for _, p := range l.players {
-		rStr := "0"
-		if p.ready {
-			rStr = "1"
+// gameDetails returns the game state for the GameState command, false if no game was started.
+func (l *Lobby) gameDetails() (messaging.GameDetails, bool) {
+	var details messaging.GameDetails
+	started := false
+	l.do(func() {
+		if l.game == nil {
+			return
 		}
-		stateStr += p.clientId + "_" + rStr + ";"
-	}
-	if stateStr[len(stateStr)-1] == ';' {
-		stateStr = stateStr[0 : len(stateStr)-1]
-	}
+		details = newGameDetails(l.game, l.seatIds())
+		started = true
+	})
+	return details, started
+}
 
-	return stateStr
+// gameStateReply answers a GameState request with the game details, or an error if no game was started.
+func (l *Lobby) gameStateReply(s *subscriber, req messaging.Message) *messaging.Message {
+	gameDetails, ok := l.gameDetails()
+	if !ok {
+		return s.reply(req, messaging.NewError(messaging.ErrGameNotRunning, "No game in progress"), nil)
+	}
+	reply := messaging.CreatePayloadMessage(messaging.CommandGameState, gameDetails)
+	reply.Id = req.Id
+	return reply
 }
 
 // subscribe subscribes the given WebSocket to all broadcast messages.
@@ -148,76 +553,116 @@ func (l *Lobby) getState() string {
 // It uses CloseRead to keep reading from the connection to process control
 // messages and cancel the context if the connection drops.
 func (l *Lobby) subscribe(w http.ResponseWriter, r *http.Request, player *Player) error {
-	var mu sync.Mutex
-	var c *websocket.Conn
-	var closed bool
+	c, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		return err
+	}
+	return l.subscribeConn(c, player, "", nil)
+}
+
+// connMessage is a message read from a websocket connection, or the error that ended reading.
+type connMessage struct {
+	data []byte
+	err  error
+}
+
+// readConn reads the connection's messages until reading fails or done is closed, then closes the channel.
+// A single reader lets the matchmaking queue notice closed connections and hand the connection to a lobby later.
+func readConn(c *websocket.Conn, done <-chan struct{}) <-chan connMessage {
+	reads := make(chan connMessage)
+	go func() {
+		defer close(reads)
+		for {
+			_, m, err := c.Read(context.Background())
+			select {
+			case reads <- connMessage{data: m, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return reads
+}
+
+// subscribeConn subscribes an already accepted WebSocket connection, see subscribe.
+// With a session token the connection takes over the player's existing seat, see handleRejoin.
+// reads are the connection's messages if it is already read, see readConn, nil starts reading it.
+func (l *Lobby) subscribeConn(c *websocket.Conn, player *Player, token string, reads <-chan connMessage) (err error) {
 	s := &subscriber{
-		id:        l.subscriberIdCount,
 		player:    player,
-		msgs:      make(chan []byte, l.subscriberMessageBuffer),
-		readCmdCh: make(chan int, l.subscriberMessageBuffer),
-		readMsgCh: make(chan []byte, l.subscriberMessageBuffer),
+		msgs:      make(chan messaging.Message, l.subscriberMessageBuffer),
+		codec:     messaging.CodecFor(c.Subprotocol()),
+		readCmdCh: make(chan messaging.Message, l.subscriberMessageBuffer),
 		readErrCh: make(chan error, l.subscriberMessageBuffer),
 		closeSlow: func() {
-			mu.Lock()
-			defer mu.Unlock()
-			closed = true
-			if c != nil {
-				c.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
-			}
+			c.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
 		},
+		c: c,
 	}
 
-	l.subscriberIdCount++
-	l.addSubscriber(s)
-	defer l.deleteSubscriber(s)
-
-	c2, err := websocket.Accept(w, r, nil)
-	if err != nil {
-		return err
+	defer c.CloseNow()
+	if reads == nil {
+		done := make(chan struct{})
+		defer close(done)
+		reads = readConn(c, done)
 	}
-	mu.Lock()
-	if closed {
-		mu.Unlock()
-		return net.ErrClosed
+	if token == "" {
+		if !l.send(subscribeEvent{s: s}) {
+			return ErrLobbyClosed
+		}
+	} else if err := l.rejoin(s, token); err != nil {
+		c.Close(websocket.StatusPolicyViolation, messaging.MessageOf(err))
+		return err
 	}
-	c = c2
-	s.c = c
-	mu.Unlock()
-	defer c.CloseNow()
+	defer func() {
+		l.send(disconnectEvent{s: s, dropped: connectionDropped(err)})
+	}()
 
-	c.Write(context.Background(), websocket.MessageText, messaging.CreateTextMessage("Welcome to lobby "+l.id).Parse())
+	s.write(context.Background(), messaging.CreateTextMessage("Welcome to lobby "+l.id))
 
 	//ctx := c.CloseRead(context.Background()) // This closes the connection after one read (???!!!)
 	ctx := context.Background()
 	log.Printf("!! Client connected to lobby %s !!", l.id)
 
-	// Send message to everyone that someone joined
-	l.publishExcept(messaging.CreateTextMessage("JOINED "+player.clientId).Parse(), player.clientId)
-	l.sendLobbyState()
-
 	go func() {
 		for {
-			_, m, err := c.Read(ctx)
+			r, ok := <-reads
+			if !ok {
+				return
+			}
+			m, err := r.data, r.err
 
 			log.Printf("READING: %s %v", m, err != nil)
 
 			if err != nil {
+				// The connection is closed after a failed read
 				s.readErrCh <- err
-				continue // return maybe??
+				return
 			}
 
-			msg := messaging.ToMessage(m)
+			msg := s.codec.Decode(m)
 
 			switch msg.Type {
 			case messaging.MessageCommand:
-				s.readCmdCh <- int(msg.Cmd)
+				if msg.Cmd == messaging.CommandChoice || msg.Cmd == messaging.CommandCommit || msg.Cmd == messaging.CommandReveal {
+					l.send(choiceEvent{s: s, msg: msg})
+					continue
+				}
+				if msg.Cmd == messaging.CommandChat {
+					l.send(chatEvent{s: s, msg: msg})
+					continue
+				}
+				s.readCmdCh <- msg
 				continue
 			case messaging.MessageText:
-				s.readMsgCh <- []byte(msg.Content)
+				l.send(choiceEvent{s: s, msg: msg})
 				continue
 			case messaging.MessageCorrupted:
 				log.Printf("Ignoring received corrupted message from %s: %s", player.clientId, msg.Content)
+				s.write(ctx, s.reply(msg, messaging.NewError(messaging.ErrBadRequest, msg.Content), nil))
 				continue
 			}
 		}
@@ -226,241 +671,464 @@ func (l *Lobby) subscribe(w http.ResponseWriter, r *http.Request, player *Player
 	for {
 		select {
 		case msg := <-s.msgs:
-			err := writeTimeout(ctx, time.Second*5, c, msg)
+			err := writeTimeout(ctx, time.Second*5, c, s.codec.Encode(msg))
 			if err != nil {
 				log.Println("Client disconnected from websocket")
-				l.exitLobby(player.clientId)
 				return err
 			}
 
 		case cmd := <-s.readCmdCh:
-			switch cmd {
+			switch cmd.Cmd {
 			case messaging.CommandGameState:
 				log.Printf("GAME STATE REQUEST")
-				// Get player names
-				var playerIds []string
-				for _, s := range l.players {
-					playerIds = append(playerIds, s.clientId)
-				}
-				gameDetails := l.game.GetGameDetails(playerIds)
-				fmt.Printf("Sending detail string: %s", gameDetails)
-				/*gameStateMsg := messaging.Message{
-					Type:    messaging.MessageCommand,
-					Cmd:     messaging.CommandGameState,
-					Content: gameDetails,
-				}*/
-				c.Write(ctx, websocket.MessageText, messaging.CreateCommandMessage(messaging.CommandGameState, gameDetails).Parse())
+				s.write(ctx, l.gameStateReply(s, cmd))
 			case messaging.CommandLobbyExit:
 				log.Printf("EXIT LOBBY")
-				// Get player names
+				s.write(ctx, s.reply(cmd, nil, nil))
 				l.exitLobby(player.clientId)
 			case messaging.CommandLobbyReady:
 				log.Printf("READY")
 				ok := l.ready(player.clientId)
-				c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage(fmt.Sprintf("%t", ok)).Parse())
-				fmt.Println("VSE OK!")
+				s.write(ctx, s.reply(cmd, notInLobby(ok), messaging.CreateTextMessage(fmt.Sprintf("%t", ok))))
 			case messaging.CommandLobbyUnready:
 				log.Printf("UNREADY")
 				ok := l.unready(player.clientId)
-				c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage(fmt.Sprintf("%t", ok)).Parse())
+				s.write(ctx, s.reply(cmd, notInLobby(ok), messaging.CreateTextMessage(fmt.Sprintf("%t", ok))))
+			case messaging.CommandLobbyAddBot:
+				log.Printf("ADD BOT")
+				err := l.addBot(cmd.Content)
+				result := "OK"
+				if err != nil {
+					result = err.Error()
+				}
+				s.write(ctx, s.reply(cmd, err, messaging.CreateCommandMessage(messaging.CommandLobbyAddBot, result)))
+			case messaging.CommandLobbyKick:
+				log.Printf("KICK")
+				s.write(ctx, s.reply(cmd, l.kick(player.clientId, cmd.Content), nil))
+			case messaging.CommandLobbyHost:
+				log.Printf("TRANSFER HOST")
+				s.write(ctx, s.reply(cmd, l.transferHost(player.clientId, cmd.Content), nil))
+			case messaging.CommandLobbySettings:
+				log.Printf("CHANGE SETTINGS")
+				s.write(ctx, s.reply(cmd, l.changeSettings(player.clientId, cmd.Content), nil))
+			case messaging.CommandRematch:
+				log.Printf("REMATCH")
+				s.write(ctx, s.reply(cmd, l.rematch(player.clientId, cmd.Content == "swap"), nil))
 			case 123:
 				// Ping operation, do nothing for now... maybo do "Pong" in the future
 				log.Printf("Ping received")
-				c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage("Pong").Parse()) // TODO: CMD???
+				s.write(ctx, s.reply(cmd, nil, messaging.CreateTextMessage("Pong"))) // TODO: CMD???
 			default:
-				log.Printf("UNKNOWN CMD: %d", cmd)
+				log.Printf("UNKNOWN CMD: %d", cmd.Cmd)
+				s.write(ctx, s.reply(cmd, messaging.NewError(messaging.ErrUnknownCommand, fmt.Sprintf("unknown command %v", cmd.Cmd)), nil))
 			}
 
 		case err := <-s.readErrCh:
-			log.Printf("Error parsing client message: %v", err)
-			err = writeTimeout(ctx, time.Second*5, c, []byte("IS_ALIVE"))
-			if err != nil {
-				// TODO: THis triggers after game ends and lobby gets disbanded... HOW TO FIX??
-				log.Println("Client disconnected from websocket")
-				l.exitLobby(player.clientId)
-				return err
-			}
+			log.Printf("Client disconnected from websocket: %v", err)
+			return err
 		case <-ctx.Done():
 			log.Println("Client disconnected from websocket")
-			l.exitLobby(player.clientId)
 			return ctx.Err()
 		}
 	}
 }
 
-// publish publishes the msg to all subscribers.
+// publish publishes the msg to all subscribers and spectators.
 // It never blocks and so messages to slow subscribers
 // are dropped.
-func (l *Lobby) publish(msg []byte) {
-	l.subscribersMu.Lock()
-	defer l.subscribersMu.Unlock()
-
-	//cs.publishLimiter.Wait(context.Background())
-
-	for i, _ := range l.subscribers {
-		s := l.subscribers[i]
-		select {
-		case s.msgs <- msg:
-		default:
-			go s.closeSlow()
-		}
+func (l *Lobby) publish(msg *messaging.Message) {
+	for _, s := range l.subscribers {
+		l.sendTo(s, msg)
+	}
+	for _, s := range l.spectators {
+		l.sendTo(s, msg)
 	}
 }
 
-func (l *Lobby) publishExcept(msg []byte, clientId string) {
-	l.subscribersMu.Lock()
-	defer l.subscribersMu.Unlock()
-
-	//cs.publishLimiter.Wait(context.Background())
-
-	for i, _ := range l.subscribers {
-		s := l.subscribers[i]
+func (l *Lobby) publishExcept(msg *messaging.Message, clientId string) {
+	for _, s := range l.subscribers {
 		if s.player.clientId == clientId {
 			continue
 		}
-		select {
-		case s.msgs <- msg:
-		default:
-			go s.closeSlow()
-		}
+		l.sendTo(s, msg)
 	}
-}
-
-func (l *Lobby) publishToClient(msg []byte, clientId int) {
-	l.subscribersMu.Lock()
-	defer l.subscribersMu.Unlock()
-
-	//log.Printf("Trying to send message to client", clientId, "with subscriber cound: ", len(cs.subscribers))
-	//cs.publishLimiter.Wait(context.Background())
-
-	for i, _ := range l.subscribers {
-		s := l.subscribers[i]
-		fmt.Println("sub: ", s)
-		if s.id == clientId {
-			log.Printf("Sending to client...")
-			select {
-			case s.msgs <- msg:
-			default:
-				go s.closeSlow()
-			}
-			break
-		}
+	for _, s := range l.spectators {
+		l.sendTo(s, msg)
 	}
 }
 
-// addSubscriber registers a subscriber.
-func (l *Lobby) addSubscriber(s *subscriber) {
-	l.subscribersMu.Lock()
-	l.subscribers = append(l.subscribers, s)
-	l.subscribersMu.Unlock()
-}
-
-// deleteSubscriber deletes the given subscriber.
-func (l *Lobby) deleteSubscriber(s *subscriber) {
-	l.subscribersMu.Lock()
-
-	log.Printf("DELETING SUBSCRIBER :(")
-
-	for i, _ := range l.subscribers {
-		if l.subscribers[i] == s {
-			l.subscribers = append(l.subscribers[:i], l.subscribers[i+1:]...)
-			break
-		}
+// sendTo queues the msg for one subscriber without blocking. A nil msg is skipped.
+// Disconnected subscribers are skipped, they get a resync when they rejoin.
+func (l *Lobby) sendTo(s *subscriber, msg *messaging.Message) {
+	if msg == nil || s.disconnected {
+		return
+	}
+	select {
+	case s.msgs <- *msg:
+	default:
+		go s.closeSlow()
 	}
-	l.subscribersMu.Unlock()
 }
 
+// checkStartGame starts the countdown once the lobby is full and everyone is connected and ready.
 func (l *Lobby) checkStartGame() {
+	if l.state != LobbyCreated {
+		// Game already starting
+		return
+	}
 
 	if len(l.players) < l.maxPlayers {
 		return
 	}
 
+	if len(l.subscribers) < l.maxPlayers {
+		// Not all players are connected yet
+		return
+	}
+
 	for _, p := range l.players {
 		if !p.ready {
 			return
 		}
 	}
 
+	l.state = LobbyStarting
+
 	log.Printf("Game is starting in lobby: %s", l.id)
 
-	l.publish(messaging.CreateCommandMessage(messaging.CommandLobbyGameStarting, "").Parse())
+	l.publish(messaging.CreateCommandMessage(messaging.CommandLobbyGameStarting, ""))
+
+	time.AfterFunc(gameStartDelay, func() {
+		l.send(startEvent{})
+	})
+}
+
+func (l *Lobby) handleStart() {
+	if l.state != LobbyStarting {
+		return
+	}
+	if len(l.players) < l.maxPlayers || len(l.subscribers) < l.maxPlayers {
+		// Someone left during the countdown
+		log.Printf("Game start in lobby %s cancelled - players left", l.id)
+		l.state = LobbyCreated
+		l.sendLobbyState()
+		return
+	}
 
-	time.Sleep(5 * time.Second)
 	log.Printf("Game starting NOW!")
-	// Start game!!
-	go l.startGame()
+	l.state = LobbyInGame
+	l.game = game.NewGame(game.Config{
+		Rules:   l.rules,
+		Players: l.maxPlayers,
+		Scoring: l.scoring,
+		Match:   l.match,
+
+		CommitReveal: l.commitReveal,
+		Seed:         l.server.opts.seed,
+	})
+	log.Printf("Game in lobby %s uses seed %d", l.id, l.game.Seed())
+
+	l.seats = make([]*subscriber, l.maxPlayers)
+	copy(l.seats, l.subscribers)
+	l.resetSeries()
+
+	l.startRound()
 }
 
-func (l *Lobby) startGame() {
-	l.game = game.NewGame()
+// startRound asks all players for input and starts the round timer.
+func (l *Lobby) startRound() {
+	l.round++
+	l.inputDone = make([]bool, l.maxPlayers)
+	l.committed = make([]bool, l.maxPlayers)
+	l.revealOpen = false
+	l.roundDone = make(chan struct{})
+	l.roundDeadline = time.Time{}
+
+	l.publish(messaging.CreateTextMessage("0"))
+
+	// All players share the same deadline
+	if l.roundTimeout > 0 {
+		l.roundDeadline = time.Now().Add(l.roundTimeout)
+		go l.runRoundTimer(l.round, l.roundDeadline, l.roundDone)
+	}
+}
 
-	var wg sync.WaitGroup
+// seat returns the subscriber's player number in the game.
+func (l *Lobby) seat(s *subscriber) int {
+	for i, v := range l.seats {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
 
-	getPlayerInput := func(player int) {
-		ctx := context.Background()
+// checkRevealPhase tells players to reveal once everyone committed to a choice in the current round.
+func (l *Lobby) checkRevealPhase() {
+	if l.commitReveal && !l.revealOpen && l.game.AllCommitted() {
+		l.revealOpen = true
+		l.publish(messaging.CreateCommandMessage(messaging.CommandReveal, ""))
+	}
+}
 
-		for {
-			msg := <-l.subscribers[player].readMsgCh
+// handleChoice processes a player's choice, commitment or reveal for the current round.
+func (l *Lobby) handleChoice(s *subscriber, msg messaging.Message) {
+	fail := func(code messaging.ErrorCode, text string) {
+		l.sendTo(s, s.reply(msg, messaging.NewError(code, text), nil))
+	}
+	ok := func(legacy *messaging.Message) {
+		l.sendTo(s, s.reply(msg, nil, legacy))
+	}
 
-			choice, err := strconv.Atoi(string(msg))
-			if err != nil {
-				log.Printf("Error converting choice to int... %v", err)
-				l.subscribers[player].c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage("Invalid choice type").Parse())
-				continue
-			}
+	player := l.seat(s)
+	if l.state != LobbyInGame || player < 0 {
+		log.Printf("Ignoring game input from %s - not playing", s.player.clientId)
+		fail(messaging.ErrGameNotRunning, "Not playing")
+		return
+	}
+	if l.inputDone[player] {
+		log.Printf("Ignoring game input from %s - already made a choice this round", s.player.clientId)
+		fail(messaging.ErrAlreadyChose, "Choice already made this round")
+		return
+	}
 
-			if choice < 0 || choice > 3 {
-				log.Printf("Invalid choice: %d", choice)
-				l.subscribers[player].c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage("Invalid choice").Parse())
-				continue
+	if l.commitReveal {
+		if !l.committed[player] {
+			if msg.Type != messaging.MessageCommand || msg.Cmd != messaging.CommandCommit {
+				fail(messaging.ErrCommitRequired, "Commit your choice first")
+				return
 			}
-			ok, _ := l.game.MakeChoice(player, intToPlayerChoice(choice))
-			if !ok {
-				log.Println("Something went wrong, choice not ok!")
-				l.subscribers[player].c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage("Game could not accept choice").Parse())
-				continue
+			if err := l.game.Commit(player, msg.Content); err != nil {
+				log.Printf("Commit not accepted: %v", err)
+				l.sendTo(s, s.reply(msg, gameError(err), nil))
+				return
 			}
-			log.Println("Player made a choice! Sending OK response")
-			l.subscribers[player].c.Write(ctx, websocket.MessageText, messaging.CreateTextMessage("OK").Parse())
-			break
+			l.committed[player] = true
+			ok(messaging.CreateCommandMessage(messaging.CommandCommit, "OK"))
+			l.checkRevealPhase()
+			return
 		}
 
-		wg.Done()
+		if msg.Type != messaging.MessageCommand || msg.Cmd != messaging.CommandReveal {
+			fail(messaging.ErrRevealRequired, "Reveal your choice")
+			return
+		}
+		// The nonce is everything after the first space, it may contain spaces or be empty
+		c, nonce, found := strings.Cut(msg.Content, " ")
+		choice, err := strconv.Atoi(c)
+		if !found || err != nil {
+			fail(messaging.ErrBadRequest, "Invalid reveal")
+			return
+		}
+		if err := l.game.Reveal(player, game.PlayerChoice(choice), nonce); err != nil {
+			log.Printf("Reveal not accepted: %v", err)
+			l.sendTo(s, s.reply(msg, gameError(err), nil))
+			return
+		}
+		log.Println("Player revealed a choice! Sending OK response")
+		ok(messaging.CreateTextMessage("OK"))
+		l.inputDone[player] = true
+		l.checkRoundFinished()
+		return
+	}
+
+	choice, err := strconv.Atoi(msg.Content)
+	if err != nil {
+		log.Printf("Error converting choice to int... %v", err)
+		fail(messaging.ErrInvalidChoice, "Invalid choice type")
+		return
+	}
+
+	if !l.rules.IsValidChoice(game.PlayerChoice(choice)) {
+		log.Printf("Invalid choice: %d", choice)
+		fail(messaging.ErrInvalidChoice, "Invalid choice")
+		return
+	}
+	if accepted, _ := l.game.MakeChoice(player, game.PlayerChoice(choice)); !accepted {
+		log.Println("Something went wrong, choice not ok!")
+		fail(messaging.ErrRejected, "Game could not accept choice")
+		return
+	}
+	log.Println("Player made a choice! Sending OK response")
+	ok(messaging.CreateTextMessage("OK"))
+	l.inputDone[player] = true
+	l.checkRoundFinished()
+}
+
+// checkRoundFinished completes the round once all players made their input and starts the next one.
+func (l *Lobby) checkRoundFinished() {
+	for _, done := range l.inputDone {
+		if !done {
+			return
+		}
+	}
+	close(l.roundDone)
+
+	if l.game.IsFinished() {
+		// A player forfeited the match
+		l.finishGame()
+		return
+	}
+
+	result := l.game.CompleteRound()
+	l.publish(messaging.CreatePayloadMessage(messaging.CommandRoundResult, newRoundResult(result)))
+	for _, f := range result.CoinFlips {
+		l.publish(messaging.CreateTextMessage(fmt.Sprintf("Coin flip between players %d and %d: player %d", f.PlayerA, f.PlayerB, f.Winner)))
+	}
+	if l.game.IsSuddenDeath() && !l.game.IsFinished() {
+		l.publish(messaging.CreateTextMessage("SUDDEN DEATH!"))
+	}
+	if len(result.Winners) > 1 {
+		winners := []string{}
+		for _, w := range result.Winners {
+			winners = append(winners, strconv.Itoa(w))
+		}
+		l.publish(messaging.CreateTextMessage("Winners: " + strings.Join(winners, ",")))
+	} else {
+		l.publish(messaging.CreateTextMessage("Winner: " + strconv.Itoa(result.Winner())))
+	}
+
+	log.Println("ROUND COMPLETED!")
+
+	if l.game.IsFinished() {
+		l.finishGame()
+		return
 	}
+	l.startRound()
+}
 
-	for !l.game.IsFinished() {
-		// Wait for input from both players
-		wg.Add(2)
+func (l *Lobby) finishGame() {
+	log.Println("GAME FINISHED!!!!")
+	l.state = LobbyFinished
+	l.saveReplay()
+	l.recordResults()
+	if l.game.IsDraw() {
+		l.publish(messaging.CreateTextMessage("The game ended in a DRAW!"))
+	} else {
+		winner := l.game.GetWinner()
+		l.publish(messaging.CreateTextMessage("Player " + strconv.Itoa(winner) + " WON THE GAME!"))
+	}
 
-		l.publish(messaging.CreateTextMessage("0").Parse())
+	l.publish(messaging.CreateTextMessage("1"))
+	l.recordSeries()
 
-		go getPlayerInput(0)
-		go getPlayerInput(1)
+	// Players vote for a rematch by getting ready again, bots are always ready
+	for i := range l.players {
+		if !l.players[i].bot {
+			l.players[i].ready = false
+		}
+	}
+	l.sendLobbyState()
+	if l.tournament != nil {
+		l.reportTournament()
+	} else {
+		l.publish(messaging.CreateTextMessage("Send rematch to play again"))
+	}
 
-		wg.Wait()
+	if idle := l.server.opts.idleTimeout; idle > 0 {
+		games := l.series.Games
+		time.AfterFunc(idle, func() {
+			l.send(idleEvent{games: games})
+		})
+	}
+}
 
-		for !l.game.IsRoundFinished() {
-			// Wait until round finished...
+// runRoundTimer sends a tick every second and a timeout at the deadline, until done is closed.
+func (l *Lobby) runRoundTimer(round int, deadline time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
 
+	l.send(tickEvent{round: round, deadline: deadline})
+	for {
+		select {
+		case <-ticker.C:
+			l.send(tickEvent{round: round, deadline: deadline})
+		case <-timeout.C:
+			l.send(timeoutEvent{round: round})
+			return
+		case <-done:
+			return
 		}
+	}
+}
 
-		winner := l.game.CompleteRound()
-		l.publish(messaging.CreateTextMessage("Winner: " + strconv.Itoa(winner)).Parse())
+// handleTick sends the remaining time to make a choice to all players.
+func (l *Lobby) handleTick(round int, deadline time.Time) {
+	if round != l.round || l.state != LobbyInGame {
+		return
+	}
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 0 {
+		remaining = 0
+	}
+	l.publish(messaging.CreateCommandMessage(messaging.CommandRoundTimer, strconv.FormatInt(remaining, 10)))
+}
 
-		log.Println("ROUND COMPLETED!")
+// handleRoundTimeout applies the timeout policy to every player that didn't finish their input in time.
+func (l *Lobby) handleRoundTimeout(round int) {
+	if round != l.round || l.state != LobbyInGame {
+		return
+	}
+	for player, done := range l.inputDone {
+		if done {
+			continue
+		}
+		if !l.game.IsFinished() {
+			l.timeoutPlayer(player)
+		}
+		l.inputDone[player] = true
+	}
+	l.checkRoundFinished()
+}
+
+// timeoutPlayer applies the lobby's timeout policy to a player that didn't make a choice in time.
+func (l *Lobby) timeoutPlayer(player int) {
+	log.Printf("Player %d in lobby %s didn't make a choice in time", player, l.id)
 
-		// TODO: new input signel etc...
+	policy := l.timeoutPolicy
+	if l.commitReveal && policy == TimeoutRandomChoice {
+		// A random choice can't be made for a player who committed
+		policy = TimeoutForfeitRound
 	}
 
-	log.Println("GAME FINISHED!!!!")
-	winner := l.game.GetWinner()
-	l.publish(messaging.CreateTextMessage("Player " + strconv.Itoa(winner) + " WON THE GAME!").Parse())
+	switch policy {
+	case TimeoutRandomChoice:
+		choice, _ := l.game.MakeRandomChoice(player)
+		l.sendTo(l.seats[player], messaging.CreateTextMessage(fmt.Sprintf("Time is up! Random choice made: %d", choice)))
+	case TimeoutForfeitMatch:
+		l.game.ForfeitMatch(player)
+		l.publish(messaging.CreateTextMessage(fmt.Sprintf("Player %d forfeited the game!", player)))
+	default:
+		l.game.Forfeit(player)
+		l.publish(messaging.CreateTextMessage(fmt.Sprintf("Player %d forfeited the round!", player)))
+	}
+}
 
-	l.publish(messaging.CreateTextMessage("1").Parse())
+// saveReplay writes the replay of the finished game to the server's replay directory, if set.
+func (l *Lobby) saveReplay() {
+	dir := l.server.opts.replayDir
+	if dir == "" {
+		return
+	}
 
-	time.Sleep(5 * time.Second)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Error creating replay directory: %v", err)
+		return
+	}
 
-	l.server.disbandLobby(l)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.json", l.id, time.Now().Unix()))
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Error creating replay file: %v", err)
+		return
+	}
+	defer f.Close()
 
+	if err := game.NewReplay(l.game).Write(f); err != nil {
+		log.Printf("Error writing replay: %v", err)
+		return
+	}
+	log.Printf("Replay saved to %s", path)
 }
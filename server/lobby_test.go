@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+)
+
+// startTestGame starts a game in a new lobby with players a and b. Their subscribers have no connection,
+// a plays on seat 0 and b on seat 1.
+func startTestGame(t *testing.T) (*Lobby, []*subscriber) {
+	t.Helper()
+	return startTestGameWith(t, defaultLobbySettings())
+}
+
+func startTestGameWith(t *testing.T, settings LobbySettings) (*Lobby, []*subscriber) {
+	t.Helper()
+	cs := newGameServer(serverOptions{})
+	l, err := cs.createLobby("game", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs := []*subscriber{}
+	for _, id := range []string{"a", "b"} {
+		subs = append(subs, &subscriber{
+			player:    &Player{clientId: id},
+			msgs:      make(chan messaging.Message, 256),
+			closeSlow: func() {},
+		})
+	}
+	l.do(func() {
+		for _, s := range subs {
+			l.handleJoin(Player{clientId: s.player.clientId})
+			l.subscribers = append(l.subscribers, s)
+		}
+		l.state = LobbyStarting
+		l.handleStart()
+	})
+	return l, subs
+}
+
+func TestLeavingForfeitsTheGame(t *testing.T) {
+	tests := []struct {
+		name  string
+		leave func(l *Lobby, s *subscriber)
+	}{
+		{"exit", func(l *Lobby, s *subscriber) { l.exitLobby(s.player.clientId) }},
+		{"connection closed", func(l *Lobby, s *subscriber) { l.send(disconnectEvent{s: s, dropped: false}) }},
+		{"connection dropped without grace period", func(l *Lobby, s *subscriber) { l.send(disconnectEvent{s: s, dropped: true}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, subs := startTestGame(t)
+			l.send(choiceEvent{s: subs[1], msg: *messaging.CreateTextMessage("0")})
+			tt.leave(l, subs[0])
+
+			var state string
+			winner := -1
+			l.do(func() {
+				state = l.state
+				winner = l.game.GetWinner()
+			})
+			if state != LobbyFinished || winner != 1 {
+				t.Errorf("lobby %s, winner %d - want the game won by the player who stayed", state, winner)
+			}
+		})
+	}
+}
+
+func TestRevealNonce(t *testing.T) {
+	settings := defaultLobbySettings()
+	settings.CommitReveal = true
+	l, subs := startTestGameWith(t, settings)
+
+	// A plaintext choice isn't accepted before the commitment
+	l.send(choiceEvent{s: subs[0], msg: *messaging.CreateTextMessage("0")})
+	nonces := []string{"two words", ""}
+	for i, s := range subs {
+		l.send(choiceEvent{s: s, msg: *messaging.CreateCommandMessage(messaging.CommandCommit, game.CommitHash(game.ROCK, nonces[i]))})
+	}
+	for i, s := range subs {
+		l.send(choiceEvent{s: s, msg: *messaging.CreateCommandMessage(messaging.CommandReveal, "0 "+nonces[i])})
+	}
+
+	var rounds int
+	l.do(func() { rounds = l.game.CurrentRound() })
+	if rounds != 1 {
+		t.Errorf("%d rounds played, want the round resolved with both reveals", rounds)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+var ErrLobbyClosed = messaging.NewError(messaging.ErrLobbyClosed, "lobby is closed")
+
+// lobbyEvent is something that happened to a lobby. Events are applied one at a time by the
+// lobby's event loop, which is the only goroutine touching players, subscribers and the game.
+type lobbyEvent interface {
+	apply(l *Lobby)
+}
+
+// run is the lobby's event loop, it returns after the lobby is disbanded.
+func (l *Lobby) run() {
+	defer close(l.done)
+
+	for ev := range l.events {
+		ev.apply(l)
+		if l.stopped {
+			log.Printf("Event loop of lobby %s stopped", l.id)
+			return
+		}
+	}
+}
+
+// send queues the event. Returns false if the lobby's event loop already stopped.
+func (l *Lobby) send(ev lobbyEvent) bool {
+	select {
+	case l.events <- ev:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// do runs fn on the event loop and waits for it. Returns false if the lobby's event loop already stopped.
+// Must not be called from the event loop itself.
+func (l *Lobby) do(fn func()) bool {
+	ev := queryEvent{fn: fn, done: make(chan struct{})}
+	if !l.send(ev) {
+		return false
+	}
+	select {
+	case <-ev.done:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// joinEvent takes a seat in the lobby if there is one left.
+type joinEvent struct {
+	player Player
+	reply  chan error
+}
+
+func (ev joinEvent) apply(l *Lobby) {
+	ev.reply <- l.handleJoin(ev.player)
+}
+
+// leaveEvent removes a player from the lobby and closes their connection.
+type leaveEvent struct {
+	clientId string
+	reply    chan bool
+}
+
+func (ev leaveEvent) apply(l *Lobby) {
+	ev.reply <- l.handleLeave(ev.clientId)
+}
+
+// readyEvent sets a player's ready flag.
+type readyEvent struct {
+	clientId string
+	ready    bool
+	reply    chan bool
+}
+
+func (ev readyEvent) apply(l *Lobby) {
+	ev.reply <- l.handleReady(ev.clientId, ev.ready)
+}
+
+// subscribeEvent registers a connected client or bot.
+type subscribeEvent struct {
+	s *subscriber
+}
+
+func (ev subscribeEvent) apply(l *Lobby) {
+	l.handleSubscribe(ev.s)
+}
+
+// unsubscribeEvent removes a subscriber after its connection closed.
+type unsubscribeEvent struct {
+	s *subscriber
+}
+
+func (ev unsubscribeEvent) apply(l *Lobby) {
+	l.handleUnsubscribe(ev.s)
+}
+
+// disconnectEvent is sent when a subscriber's connection closed. Dropped connections of players
+// in a running game keep their seat for a grace period, everyone else leaves the lobby.
+type disconnectEvent struct {
+	s       *subscriber
+	dropped bool
+}
+
+func (ev disconnectEvent) apply(l *Lobby) {
+	l.handleDisconnect(ev.s, ev.dropped)
+}
+
+// rejoinEvent gives a disconnected player's seat to a new connection.
+type rejoinEvent struct {
+	s     *subscriber
+	token string
+	reply chan error
+}
+
+func (ev rejoinEvent) apply(l *Lobby) {
+	ev.reply <- l.handleRejoin(ev.s, ev.token)
+}
+
+// graceExpiredEvent is sent when a disconnected player didn't rejoin in time.
+type graceExpiredEvent struct {
+	s *subscriber
+}
+
+func (ev graceExpiredEvent) apply(l *Lobby) {
+	l.handleGraceExpired(ev.s)
+}
+
+// spectateEvent registers a read-only connection.
+type spectateEvent struct {
+	s *subscriber
+}
+
+func (ev spectateEvent) apply(l *Lobby) {
+	l.handleSpectate(ev.s)
+}
+
+// unspectateEvent removes a spectator after its connection closed.
+type unspectateEvent struct {
+	s *subscriber
+}
+
+func (ev unspectateEvent) apply(l *Lobby) {
+	l.handleUnspectate(ev.s)
+}
+
+// addBotEvent fills an empty seat with a bot.
+type addBotEvent struct {
+	strategy string
+	reply    chan error
+}
+
+func (ev addBotEvent) apply(l *Lobby) {
+	ev.reply <- l.handleAddBot(ev.strategy)
+}
+
+// kickEvent removes a player on the host's request.
+type kickEvent struct {
+	host     string
+	clientId string
+	reply    chan error
+}
+
+func (ev kickEvent) apply(l *Lobby) {
+	ev.reply <- l.handleKick(ev.host, ev.clientId)
+}
+
+// transferHostEvent makes another player the host.
+type transferHostEvent struct {
+	host     string
+	clientId string
+	reply    chan error
+}
+
+func (ev transferHostEvent) apply(l *Lobby) {
+	ev.reply <- l.handleTransferHost(ev.host, ev.clientId)
+}
+
+// settingsEvent changes the lobby's settings on the host's request.
+type settingsEvent struct {
+	host  string
+	query url.Values
+	reply chan error
+}
+
+func (ev settingsEvent) apply(l *Lobby) {
+	ev.reply <- l.handleSettings(ev.host, ev.query)
+}
+
+// closeEvent disbands the lobby on the host's request.
+type closeEvent struct {
+	token string
+	reply chan error
+}
+
+func (ev closeEvent) apply(l *Lobby) {
+	ev.reply <- l.handleClose(ev.token)
+}
+
+// rematchEvent is a player's vote for another game.
+type rematchEvent struct {
+	clientId string
+	swap     bool
+	reply    chan error
+}
+
+func (ev rematchEvent) apply(l *Lobby) {
+	ev.reply <- l.handleRematch(ev.clientId, ev.swap)
+}
+
+// hostAbsentEvent is sent some time after a lobby was created over the REST API, see handleHostAbsent.
+type hostAbsentEvent struct{}
+
+func (ev hostAbsentEvent) apply(l *Lobby) {
+	l.handleHostAbsent()
+}
+
+// idleEvent is sent some time after a game ended, see handleIdle.
+type idleEvent struct {
+	games int
+}
+
+func (ev idleEvent) apply(l *Lobby) {
+	l.handleIdle(ev.games)
+}
+
+// noShowEvent awards a tournament match whose players didn't all join in time.
+type noShowEvent struct{}
+
+func (ev noShowEvent) apply(l *Lobby) {
+	l.handleNoShow()
+}
+
+// startEvent starts the game after the countdown.
+type startEvent struct{}
+
+func (ev startEvent) apply(l *Lobby) {
+	l.handleStart()
+}
+
+// choiceEvent is game input from a subscriber: a choice, a commitment or a reveal.
+type choiceEvent struct {
+	s   *subscriber
+	msg messaging.Message
+}
+
+func (ev choiceEvent) apply(l *Lobby) {
+	l.handleChoice(ev.s, ev.msg)
+}
+
+// chatEvent is a chat message from a player.
+type chatEvent struct {
+	s   *subscriber
+	msg messaging.Message
+}
+
+func (ev chatEvent) apply(l *Lobby) {
+	l.handleChat(ev.s, ev.msg)
+}
+
+// tickEvent is sent every second while the round timer runs.
+type tickEvent struct {
+	round    int
+	deadline time.Time
+}
+
+func (ev tickEvent) apply(l *Lobby) {
+	l.handleTick(ev.round, ev.deadline)
+}
+
+// timeoutEvent is sent when the round timer runs out.
+type timeoutEvent struct {
+	round int
+}
+
+func (ev timeoutEvent) apply(l *Lobby) {
+	l.handleRoundTimeout(ev.round)
+}
+
+// queryEvent runs a function on the event loop, used to read lobby state from other goroutines.
+type queryEvent struct {
+	fn   func()
+	done chan struct{}
+}
+
+func (ev queryEvent) apply(l *Lobby) {
+	ev.fn()
+	close(ev.done)
+}
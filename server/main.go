@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
+
+	"github.com/venom1270/RPS/server/chat"
+	"github.com/venom1270/RPS/server/profile"
 )
 
 func main() {
@@ -20,25 +24,69 @@ func main() {
 	}
 }
 
+// serverOptions holds the settings passed to the server with command line flags.
+type serverOptions struct {
+	// seed fixes the random seed of every game, 0 means a random seed per game (useful for test environments)
+	seed int64
+	// replayDir is where replays of finished games are saved, empty disables saving
+	replayDir string
+	// profilesFile is the file player profiles are stored in, empty keeps them in memory only
+	profilesFile string
+	// chatFilterFile is a word list of words masked in chat messages, empty disables the filter
+	chatFilterFile string
+	// reconnectGrace is how long a player in a running game can rejoin after the connection dropped, 0 disables rejoining
+	reconnectGrace time.Duration
+	// idleTimeout is how long a lobby is kept after a game if nobody starts a rematch, and how long the creator of a
+	// lobby has to join it. 0 keeps lobbies until everyone left
+	idleTimeout time.Duration
+	// noShowTimeout is how long players have to join a tournament match before it is awarded without a game, 0 waits forever
+	noShowTimeout time.Duration
+}
+
 func run() error {
-	if len(os.Args) < 2 {
-		return errors.New("please provide an address to listen on as the first argument")
+	opts := serverOptions{}
+	flag.Int64Var(&opts.seed, "seed", 0, "fixed random seed for all games (0 = random)")
+	flag.StringVar(&opts.replayDir, "replays", "", "directory to save replays of finished games to")
+	flag.StringVar(&opts.profilesFile, "profiles", "", "file to store player profiles and ratings in (default: in memory)")
+	flag.StringVar(&opts.chatFilterFile, "chatFilter", "", "word list file, listed words are masked in chat messages")
+	flag.DurationVar(&opts.idleTimeout, "idle", defaultIdleTimeout, "how long a lobby is kept after a game if nobody starts a rematch or its creator doesn't join (0 = until everyone left)")
+	flag.DurationVar(&opts.noShowTimeout, "noShow", defaultNoShowTimeout, "how long players have to join a tournament match before it goes to the player who joined (0 = no limit)")
+	flag.DurationVar(&opts.reconnectGrace, "grace", defaultReconnectGrace, "how long players in a running game can rejoin after their connection dropped (0 = no rejoining)")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		return errors.New("please provide an address to listen on as the first argument (after flags)")
 	}
 
-	return startServer()
+	return startServer(flag.Arg(0), opts)
 
 }
 
-func startServer() error {
+func startServer(addr string, opts serverOptions) error {
 
-	l, err := net.Listen("tcp", os.Args[1])
+	l, err := net.Listen("tcp", addr)
 
 	if err != nil {
 		return err
 	}
 	log.Printf("listening on ws://%v", l.Addr())
 
-	cs := newGameServer()
+	cs := newGameServer(opts)
+	if opts.profilesFile != "" {
+		store, err := profile.NewFileStore(opts.profilesFile)
+		if err != nil {
+			return err
+		}
+		cs.profiles = store
+	}
+	if opts.chatFilterFile != "" {
+		words, err := chat.LoadWordList(opts.chatFilterFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded %d words for the chat filter", words.Len())
+		cs.chatFilter = words
+	}
 	s := &http.Server{
 		Handler:      cs,
 		ReadTimeout:  time.Second * 10,
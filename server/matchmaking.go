@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+)
+
+// How often queued clients get their queue position and estimated wait
+const queueStatusInterval = 2 * time.Second
+
+// Players are matched if their ratings differ by at most ratingWindow,
+// the window grows by ratingWindowGrowth for every 10 seconds a player waits.
+const (
+	ratingWindow       = 200.0
+	ratingWindowGrowth = 100.0
+)
+
+// matchTicket is a client waiting in the matchmaking queue.
+type matchTicket struct {
+	player  Player
+	rules   *game.RuleSet
+	rating  float64
+	joined  time.Time
+	matched chan *Lobby
+}
+
+// matchmaker pairs queued clients and creates lobbies for them.
+type matchmaker struct {
+	mu      sync.Mutex
+	queue   []*matchTicket
+	server  *gameServer
+	counter int
+	// Moving average of the time matched clients waited
+	avgWait time.Duration
+}
+
+func newMatchmaker(cs *gameServer) *matchmaker {
+	return &matchmaker{
+		queue:  []*matchTicket{},
+		server: cs,
+	}
+}
+
+// compatible reports whether two queued clients can play against each other.
+func (mm *matchmaker) compatible(a, b *matchTicket) bool {
+	if a.rules != b.rules || a.player.clientId == b.player.clientId {
+		return false
+	}
+
+	// The longer the players wait, the bigger rating difference is accepted
+	waited := time.Since(a.joined)
+	if w := time.Since(b.joined); w < waited {
+		waited = w
+	}
+	window := ratingWindow + ratingWindowGrowth*float64(waited/(10*time.Second))
+	return math.Abs(a.rating-b.rating) <= window
+}
+
+// enqueue adds the ticket to the queue and pairs it if an opponent is waiting.
+func (mm *matchmaker) enqueue(t *matchTicket) {
+	mm.mu.Lock()
+	var opponent *matchTicket
+	for i, other := range mm.queue {
+		if mm.compatible(other, t) {
+			mm.queue = append(mm.queue[:i], mm.queue[i+1:]...)
+			mm.recordWait(other, t)
+			opponent = other
+			break
+		}
+	}
+	if opponent == nil {
+		mm.queue = append(mm.queue, t)
+		log.Printf("Matchmaking: %s queued (%d in queue)", t.player.clientId, len(mm.queue))
+	}
+	mm.mu.Unlock()
+
+	if opponent != nil {
+		mm.match(opponent, t)
+	}
+}
+
+// matchQueued pairs clients in the queue that became compatible while waiting.
+func (mm *matchmaker) matchQueued() {
+	mm.mu.Lock()
+	pairs := [][2]*matchTicket{}
+	for i := 0; i < len(mm.queue); i++ {
+		for j := i + 1; j < len(mm.queue); j++ {
+			a, b := mm.queue[i], mm.queue[j]
+			if !mm.compatible(a, b) {
+				continue
+			}
+			mm.queue = append(mm.queue[:j], mm.queue[j+1:]...)
+			mm.queue = append(mm.queue[:i], mm.queue[i+1:]...)
+			mm.recordWait(a, b)
+			pairs = append(pairs, [2]*matchTicket{a, b})
+			// Queue shifted, check position i again
+			i--
+			break
+		}
+	}
+	mm.mu.Unlock()
+
+	// Adding players waits for the lobby, the queue isn't blocked meanwhile
+	for _, p := range pairs {
+		mm.match(p[0], p[1])
+	}
+}
+
+// recordWait updates the average wait with the tickets that leave the queue. Must be called with mu held.
+func (mm *matchmaker) recordWait(tickets ...*matchTicket) {
+	for _, t := range tickets {
+		wait := time.Since(t.joined)
+		if mm.avgWait == 0 {
+			mm.avgWait = wait
+		} else {
+			mm.avgWait = (mm.avgWait*3 + wait) / 4
+		}
+	}
+}
+
+// lobbyName returns the name for the next matchmaking lobby.
+func (mm *matchmaker) lobbyName() string {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.counter++
+	return fmt.Sprintf("mm-%d", mm.counter)
+}
+
+// match creates a lobby for the two clients, they have to be out of the queue. Must be called without mu held.
+func (mm *matchmaker) match(a, b *matchTicket) {
+	settings := defaultLobbySettings()
+	settings.Rules = a.rules
+
+	var lobby *Lobby
+	for lobby == nil {
+		lobby, _ = mm.server.createLobby(mm.lobbyName(), settings)
+	}
+
+	for _, t := range []*matchTicket{a, b} {
+		// Matched players are ready right away, the game starts once both are connected
+		t.player.ready = true
+		if err := lobby.addPlayer(t.player); err != nil {
+			log.Printf("Matchmaking: adding %s to lobby %s failed: %v", t.player.clientId, lobby.id, err)
+		}
+		t.matched <- lobby
+	}
+
+	log.Printf("Matchmaking: %s vs %s in lobby %s", a.player.clientId, b.player.clientId, lobby.id)
+}
+
+// remove takes the ticket out of the queue. Returns false if it was already matched.
+func (mm *matchmaker) remove(t *matchTicket) bool {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for i, v := range mm.queue {
+		if v == t {
+			mm.queue = append(mm.queue[:i], mm.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the ticket's position among the clients waiting for the same rule set and the estimated wait.
+func (mm *matchmaker) status(t *matchTicket) (int, time.Duration) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	position := 0
+	for _, v := range mm.queue {
+		if v.rules == t.rules {
+			position++
+		}
+		if v == t {
+			break
+		}
+	}
+
+	wait := mm.avgWait - time.Since(t.joined)
+	if wait < 0 {
+		wait = 0
+	}
+	return position, wait
+}
+
+// matchmakeHandler puts the client into the matchmaking queue: /matchmake/{clientId}?rules=classic
+// Queue position and estimated wait are sent until an opponent is found, then the connection
+// is subscribed to a new lobby and the game starts.
+func (cs *gameServer) matchmakeHandler(w http.ResponseWriter, r *http.Request) {
+	clientId := strings.TrimPrefix(r.URL.Path, "/matchmake/")
+	if clientId == "" || strings.Contains(clientId, "/") {
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, "expected /matchmake/{clientId}"))
+		return
+	}
+
+	rules := game.DefaultRuleSet()
+	if name := r.URL.Query().Get("rules"); name != "" {
+		var ok bool
+		rules, ok = game.GetRuleSet(name)
+		if !ok {
+			log.Printf("Matchmaking failed - unknown rule set '%s'", name)
+			writeError(w, messaging.Errorf(messaging.ErrInvalidSettings, "unknown rule set '%s'", name))
+			return
+		}
+	}
+
+	c, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	codec := messaging.CodecFor(c.Subprotocol())
+
+	t := &matchTicket{
+		player:  Player{clientId: clientId},
+		rules:   rules,
+		rating:  cs.rating(clientId),
+		joined:  time.Now(),
+		matched: make(chan *Lobby, 1),
+	}
+	cs.matchmaker.enqueue(t)
+
+	// Reading notices when the client closes the connection or it drops, the lobby keeps reading it after a match
+	done := make(chan struct{})
+	defer close(done)
+	reads := readConn(c, done)
+
+	leave := func() {
+		log.Printf("Matchmaking: %s disconnected while queued", clientId)
+		if !cs.matchmaker.remove(t) {
+			// Matched in the meantime, free the seat
+			lobby := <-t.matched
+			lobby.exitLobby(clientId)
+		}
+		c.CloseNow()
+	}
+
+	ticker := time.NewTicker(queueStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		cs.matchmaker.matchQueued()
+
+		position, wait := cs.matchmaker.status(t)
+		status := fmt.Sprintf("%d,%d", position, wait.Milliseconds())
+		err := writeTimeout(context.Background(), time.Second*5, c, codec.Encode(*messaging.CreateCommandMessage(messaging.CommandQueueStatus, status)))
+		if err != nil {
+			leave()
+			return
+		}
+
+		select {
+		case lobby := <-t.matched:
+			writeTimeout(context.Background(), time.Second*5, c, codec.Encode(*messaging.CreateCommandMessage(messaging.CommandQueueMatched, lobby.id)))
+			cs.serveLobby(lobby, c, &t.player, "", reads)
+			return
+		case r := <-reads:
+			if r.err != nil {
+				leave()
+				return
+			}
+			log.Printf("Matchmaking: ignoring a message from %s while queued", clientId)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/server/game"
+)
+
+func newTicket(clientId string, rules *game.RuleSet, rating float64, waited time.Duration) *matchTicket {
+	return &matchTicket{
+		player:  Player{clientId: clientId},
+		rules:   rules,
+		rating:  rating,
+		joined:  time.Now().Add(-waited),
+		matched: make(chan *Lobby, 1),
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	mm := newMatchmaker(nil)
+	tests := []struct {
+		name string
+		a, b *matchTicket
+		want bool
+	}{
+		{"same rating", newTicket("a", game.ClassicRules, 1500, 0), newTicket("b", game.ClassicRules, 1500, 0), true},
+		{"other rules", newTicket("a", game.ClassicRules, 1500, 0), newTicket("b", game.JokerRules, 1500, 0), false},
+		{"same client", newTicket("a", game.ClassicRules, 1500, 0), newTicket("a", game.ClassicRules, 1500, 0), false},
+		{"inside the window", newTicket("a", game.ClassicRules, 1500, 0), newTicket("b", game.ClassicRules, 1700, 0), true},
+		{"outside the window", newTicket("a", game.ClassicRules, 1500, 0), newTicket("b", game.ClassicRules, 1750, 0), false},
+		{"window grew", newTicket("a", game.ClassicRules, 1500, 15*time.Second), newTicket("b", game.ClassicRules, 1750, 15*time.Second), true},
+		{"window grew for one", newTicket("a", game.ClassicRules, 1500, time.Minute), newTicket("b", game.ClassicRules, 1750, 0), false},
+		{"window grew twice", newTicket("a", game.ClassicRules, 1500, 25*time.Second), newTicket("b", game.ClassicRules, 1900, 20*time.Second), true},
+	}
+	for _, tt := range tests {
+		if got := mm.compatible(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: compatible = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchQueued(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	mm := cs.matchmaker
+
+	waiting := newTicket("a", game.ClassicRules, 1500, 0)
+	far := newTicket("b", game.ClassicRules, 1750, 0)
+	other := newTicket("c", game.JokerRules, 1500, 0)
+	for _, ticket := range []*matchTicket{waiting, far, other} {
+		mm.enqueue(ticket)
+	}
+	if len(mm.queue) != 3 {
+		t.Fatalf("%d queued, want all 3 without a match", len(mm.queue))
+	}
+
+	// After waiting, the rating window is big enough
+	waiting.joined = waiting.joined.Add(-15 * time.Second)
+	far.joined = far.joined.Add(-15 * time.Second)
+	mm.matchQueued()
+
+	if len(mm.queue) != 1 || mm.queue[0] != other {
+		t.Fatalf("queue %v, want only c left", mm.queue)
+	}
+	lobby := <-waiting.matched
+	if <-far.matched != lobby {
+		t.Fatal("players matched into different lobbies")
+	}
+	var players []string
+	lobby.do(func() {
+		for _, p := range lobby.players {
+			players = append(players, p.clientId)
+		}
+	})
+	if strings.Join(players, ",") != "a,b" {
+		t.Errorf("lobby players %v, want a and b", players)
+	}
+}
+
+func TestQueuedClientLeaves(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/matchmake/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c.Close(websocket.StatusNormalClosure, "")
+
+	// Noticed before the next queue status would be sent
+	queued := func() int {
+		cs.matchmaker.mu.Lock()
+		defer cs.matchmaker.mu.Unlock()
+		return len(cs.matchmaker.queue)
+	}
+	deadline := time.Now().Add(queueStatusInterval / 2)
+	for queued() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queued() > 0 {
+		t.Error("client that closed the connection is still queued")
+	}
+}
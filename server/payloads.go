@@ -0,0 +1,135 @@
+package main
+
+import (
+	"time"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+)
+
+// lobbyState returns the lobby's players for CommandLobbyState. Called from the lobby's event loop.
+func (l *Lobby) lobbyState() messaging.LobbyState {
+	state := messaging.LobbyState{Lobby: l.id, Players: []messaging.PlayerState{}}
+	for _, p := range l.players {
+		state.Players = append(state.Players, messaging.PlayerState{ClientId: p.clientId, Ready: p.ready, Bot: p.bot, Host: p.clientId == l.host})
+	}
+	return state
+}
+
+// playerIds returns the client ids of the lobby's players. Called from the lobby's event loop.
+func (l *Lobby) playerIds() []string {
+	var playerIds []string
+	for _, p := range l.players {
+		playerIds = append(playerIds, p.clientId)
+	}
+	return playerIds
+}
+
+// seatIds returns the client ids of the game's players in seat order, players join in a different order.
+// Called from the lobby's event loop.
+func (l *Lobby) seatIds() []string {
+	ids := []string{}
+	for _, s := range l.seats {
+		id := ""
+		if s != nil {
+			id = s.player.clientId
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// resync returns the lobby and game state for a subscriber that rejoined. Called from the lobby's event loop.
+func (l *Lobby) resync(s *subscriber) messaging.Resync {
+	r := messaging.Resync{State: l.state, Lobby: l.lobbyState()}
+	if l.game == nil {
+		return r
+	}
+	details := newGameDetails(l.game, l.seatIds())
+	r.Game = &details
+	r.Round = l.game.CurrentRound()
+
+	if l.state != LobbyInGame {
+		return r
+	}
+	if player := l.seat(s); player >= 0 {
+		r.Input = !l.inputDone[player] && !l.committed[player]
+		r.Reveal = l.commitReveal && l.committed[player] && !l.inputDone[player]
+	}
+	if !l.roundDeadline.IsZero() {
+		r.TimeLeft = max(time.Until(l.roundDeadline).Milliseconds(), 0)
+	}
+	return r
+}
+
+// newGameDetails returns the scores, choices and rounds of the game for CommandGameState.
+func newGameDetails(g *game.Game, clientIds []string) messaging.GameDetails {
+	details := messaging.GameDetails{
+		Players:   []messaging.PlayerScore{},
+		Format:    g.Match().String(),
+		Seed:      g.Seed(),
+		CoinFlips: newCoinFlips(g.CoinFlips()),
+		Rounds:    []messaging.RoundResult{},
+	}
+
+	scores := g.GetScores()
+	for i := 0; i < g.NumPlayers(); i++ {
+		clientId := ""
+		if i < len(clientIds) {
+			clientId = clientIds[i]
+		}
+		details.Players = append(details.Players, messaging.PlayerScore{
+			ClientId: clientId,
+			Score:    scores[i],
+			Choices:  choicesToInts(g.History(i)),
+		})
+	}
+
+	for _, e := range g.Events() {
+		if e.Type != game.EventRoundResolved {
+			continue
+		}
+		flips := []messaging.CoinFlip{}
+		for _, f := range details.CoinFlips {
+			if f.Round == e.Round {
+				flips = append(flips, f)
+			}
+		}
+		details.Rounds = append(details.Rounds, messaging.RoundResult{
+			Round:     e.Round,
+			Choices:   choicesToInts(e.Choices),
+			Deltas:    e.Deltas,
+			Winners:   e.Winners,
+			CoinFlips: flips,
+		})
+	}
+
+	return details
+}
+
+// newRoundResult converts a completed round for CommandRoundResult.
+func newRoundResult(r *game.RoundResult) messaging.RoundResult {
+	return messaging.RoundResult{
+		Round:     r.Round,
+		Choices:   choicesToInts(r.Choices),
+		Deltas:    r.Deltas,
+		Winners:   r.Winners,
+		CoinFlips: newCoinFlips(r.CoinFlips),
+	}
+}
+
+func newCoinFlips(flips []game.CoinFlip) []messaging.CoinFlip {
+	c := []messaging.CoinFlip{}
+	for _, f := range flips {
+		c = append(c, messaging.CoinFlip{Round: f.Round, PlayerA: f.PlayerA, PlayerB: f.PlayerB, Winner: f.Winner})
+	}
+	return c
+}
+
+func choicesToInts(choices []game.PlayerChoice) []int {
+	ints := make([]int, len(choices))
+	for i, c := range choices {
+		ints[i] = int(c)
+	}
+	return ints
+}
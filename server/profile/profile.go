@@ -0,0 +1,197 @@
+// Package profile keeps persistent player profiles: game results, Joker usage and ratings.
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("profile not found")
+
+type Profile struct {
+	ClientId  string    `json:"clientId"`
+	Wins      int       `json:"wins"`
+	Losses    int       `json:"losses"`
+	Draws     int       `json:"draws"`
+	JokerUsed int       `json:"jokerUsed"`
+	Rating    float64   `json:"rating"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func newProfile(clientId string) *Profile {
+	return &Profile{
+		ClientId: clientId,
+		Rating:   DefaultRating,
+	}
+}
+
+func (p *Profile) GamesPlayed() int {
+	return p.Wins + p.Losses + p.Draws
+}
+
+// Store keeps player profiles keyed by client id.
+type Store interface {
+	Get(clientId string) (*Profile, error)
+	List() ([]*Profile, error)
+	// Update loads the profiles (creating missing ones), passes them to fn in the same order and saves them.
+	// The whole update is atomic.
+	Update(clientIds []string, fn func(profiles []*Profile)) error
+}
+
+// Leaderboard returns up to limit profiles with the highest rating (all if limit <= 0).
+func Leaderboard(s Store, limit int) ([]*Profile, error) {
+	profiles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Rating != profiles[j].Rating {
+			return profiles[i].Rating > profiles[j].Rating
+		}
+		return profiles[i].ClientId < profiles[j].ClientId
+	})
+
+	if limit > 0 && len(profiles) > limit {
+		profiles = profiles[:limit]
+	}
+	return profiles, nil
+}
+
+// MemoryStore keeps profiles in memory only.
+type MemoryStore struct {
+	mu       sync.Mutex
+	profiles map[string]*Profile
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{profiles: map[string]*Profile{}}
+}
+
+func (s *MemoryStore) Get(clientId string) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.profiles[clientId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *p
+	return &c, nil
+}
+
+func (s *MemoryStore) List() ([]*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(), nil
+}
+
+func (s *MemoryStore) list() []*Profile {
+	profiles := []*Profile{}
+	for _, p := range s.profiles {
+		c := *p
+		profiles = append(profiles, &c)
+	}
+	return profiles
+}
+
+func (s *MemoryStore) Update(clientIds []string, fn func(profiles []*Profile)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(clientIds, fn)
+	return nil
+}
+
+// update must be called with mu held.
+func (s *MemoryStore) update(clientIds []string, fn func(profiles []*Profile)) {
+	profiles := make([]*Profile, len(clientIds))
+	for i, id := range clientIds {
+		p, ok := s.profiles[id]
+		if !ok {
+			p = newProfile(id)
+		}
+		c := *p
+		profiles[i] = &c
+	}
+
+	fn(profiles)
+
+	now := time.Now()
+	for _, p := range profiles {
+		p.UpdatedAt = now
+		s.profiles[p.ClientId] = p
+	}
+}
+
+// FileStore keeps profiles in memory and writes all of them to a JSON file on every update.
+type FileStore struct {
+	MemoryStore
+	path string
+}
+
+// NewFileStore loads the profiles from the file at path, if it exists.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: MemoryStore{profiles: map[string]*Profile{}},
+		path:        path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []*Profile{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+	for _, p := range profiles {
+		s.profiles[p.ClientId] = p
+	}
+	return s, nil
+}
+
+func (s *FileStore) Update(clientIds []string, fn func(profiles []*Profile)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(clientIds, fn)
+	return s.save()
+}
+
+// save writes all profiles to a temporary file first, so a crash never leaves a half written file.
+// Must be called with mu held.
+func (s *FileStore) save() error {
+	profiles := s.list()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].ClientId < profiles[j].ClientId })
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,83 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordGame(s, []Result{{ClientId: "a", Placement: 1, Won: true, JokerUsed: 2}, {ClientId: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordGame(s, []Result{{ClientId: "a", Draw: true}, {ClientId: "c", Draw: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		want, _ := s.Get(id)
+		got, err := loaded.Get(id)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if !got.UpdatedAt.Equal(want.UpdatedAt) {
+			t.Errorf("%s updated at %v, saved %v", id, got.UpdatedAt, want.UpdatedAt)
+		}
+		// The file doesn't keep the monotonic clock reading
+		got.UpdatedAt, want.UpdatedAt = time.Time{}, time.Time{}
+		if *got != *want {
+			t.Errorf("%s loaded as %+v, saved %+v", id, got, want)
+		}
+	}
+	if a, _ := loaded.Get("a"); a.Wins != 1 || a.Draws != 1 || a.JokerUsed != 2 {
+		t.Errorf("a loaded as %+v, want 1 win, 1 draw and 2 Jokers", a)
+	}
+
+	// The temporary file was renamed over the profiles file
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "profiles.json" {
+		t.Errorf("files %v, want only profiles.json", entries)
+	}
+}
+
+func TestFileStoreMissingFile(t *testing.T) {
+	s, err := NewFileStore(filepath.Join(t.TempDir(), "profiles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileStoreCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := NewFileStore(path); err == nil {
+		t.Error("corrupt file loaded")
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	s := NewMemoryStore()
+	RecordGame(s, []Result{{ClientId: "b", Placement: 1, Won: true}, {ClientId: "c"}})
+	RecordGame(s, []Result{{ClientId: "a", Placement: 1, Won: true}, {ClientId: "d"}})
+
+	top, _ := Leaderboard(s, 3)
+	if len(top) != 3 || top[0].ClientId != "a" || top[1].ClientId != "b" || top[2].Rating >= top[1].Rating {
+		t.Errorf("leaderboard %v, want a and b (tied, by id) first", top)
+	}
+}
@@ -0,0 +1,85 @@
+package profile
+
+import (
+	"math"
+)
+
+const (
+	DefaultRating = 1500.0
+	// KFactor is the most a rating can change in a two player game
+	KFactor = 32.0
+)
+
+// expectedScore is the Elo expected score of a player with rating a against rating b.
+func expectedScore(a, b float64) float64 {
+	return 1 / (1 + math.Pow(10, (b-a)/400))
+}
+
+// EloDeltas returns the rating changes of a game with any number of players.
+// Every pair of players is treated as a separate match, decided by their placement
+// (higher is better, equal placements are a draw). Changes are scaled so a player's
+// total change is at most KFactor.
+func EloDeltas(ratings []float64, placements []int) []float64 {
+	deltas := make([]float64, len(ratings))
+	if len(ratings) < 2 {
+		return deltas
+	}
+	k := KFactor / float64(len(ratings)-1)
+
+	for a := 0; a < len(ratings); a++ {
+		for b := a + 1; b < len(ratings); b++ {
+			score := 0.5
+			if placements[a] > placements[b] {
+				score = 1
+			} else if placements[a] < placements[b] {
+				score = 0
+			}
+			change := k * (score - expectedScore(ratings[a], ratings[b]))
+			deltas[a] += change
+			deltas[b] -= change
+		}
+	}
+	return deltas
+}
+
+// Result is the outcome of a finished game for a single player.
+type Result struct {
+	ClientId string
+	// Placement ranks the players, higher is better
+	Placement int
+	Won       bool
+	Draw      bool
+	JokerUsed int
+}
+
+// RecordGame updates the profiles and ratings of all players of a finished game.
+func RecordGame(s Store, results []Result) error {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ClientId
+	}
+
+	return s.Update(ids, func(profiles []*Profile) {
+		ratings := make([]float64, len(profiles))
+		placements := make([]int, len(profiles))
+		for i, p := range profiles {
+			ratings[i] = p.Rating
+			placements[i] = results[i].Placement
+		}
+
+		deltas := EloDeltas(ratings, placements)
+		for i, p := range profiles {
+			r := results[i]
+			switch {
+			case r.Draw:
+				p.Draws++
+			case r.Won:
+				p.Wins++
+			default:
+				p.Losses++
+			}
+			p.JokerUsed += r.JokerUsed
+			p.Rating += deltas[i]
+		}
+	})
+}
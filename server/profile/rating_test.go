@@ -0,0 +1,53 @@
+package profile
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEloDeltas(t *testing.T) {
+	tests := []struct {
+		name       string
+		ratings    []float64
+		placements []int
+		want       []float64 // nil only checks the sum and signs
+	}{
+		{"equal ratings", []float64{1500, 1500}, []int{1, 0}, []float64{16, -16}},
+		{"draw between equals", []float64{1500, 1500}, []int{0, 0}, []float64{0, 0}},
+		{"draw against a stronger player", []float64{1400, 1600}, []int{0, 0}, nil},
+		{"upset", []float64{1200, 1800}, []int{1, 0}, nil},
+		{"three players", []float64{1500, 1550, 1450}, []int{2, 1, 0}, nil},
+		{"three players, shared first place", []float64{1500, 1500, 1500}, []int{1, 1, 0}, []float64{8, 8, -16}},
+		{"single player", []float64{1500}, []int{0}, []float64{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltas := EloDeltas(tt.ratings, tt.placements)
+
+			sum := 0.0
+			for i, d := range deltas {
+				sum += d
+				if math.Abs(d) > KFactor {
+					t.Errorf("player %d changed by %v, more than %v", i, d, KFactor)
+				}
+				if tt.want != nil && math.Abs(d-tt.want[i]) > 1e-9 {
+					t.Errorf("player %d changed by %v, want %v", i, d, tt.want[i])
+				}
+			}
+			if math.Abs(sum) > 1e-9 {
+				t.Errorf("deltas %v sum up to %v, want 0", deltas, sum)
+			}
+		})
+	}
+}
+
+func TestEloDeltasFavourTheUnderdog(t *testing.T) {
+	if d := EloDeltas([]float64{1400, 1600}, []int{0, 0}); d[0] <= 0 {
+		t.Errorf("weaker player changed by %v in a draw, want a gain", d[0])
+	}
+	upset := EloDeltas([]float64{1200, 1800}, []int{1, 0})
+	expected := EloDeltas([]float64{1800, 1200}, []int{1, 0})
+	if upset[0] <= expected[0] {
+		t.Errorf("underdog won %v, favourite %v - want more for the underdog", upset[0], expected[0])
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+	"github.com/venom1270/RPS/server/profile"
+)
+
+const defaultLeaderboardLimit = 10
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// playerHandler returns the profile of a player: /players/{clientId}
+func (cs *gameServer) playerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use GET"))
+		return
+	}
+
+	clientId := strings.TrimPrefix(r.URL.Path, "/players/")
+	if clientId == "" || strings.Contains(clientId, "/") {
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, "expected /players/{clientId}"))
+		return
+	}
+
+	p, err := cs.profiles.Get(clientId)
+	if errors.Is(err, profile.ErrNotFound) {
+		writeError(w, messaging.Errorf(messaging.ErrPlayerNotFound, "no profile for player %s", clientId))
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading profile %s: %v", clientId, err)
+		writeError(w, messaging.NewError(messaging.ErrInternal, "could not load profile"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+// leaderboardHandler returns the profiles with the highest rating: /leaderboard?limit=10
+func (cs *gameServer) leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use GET"))
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			writeError(w, messaging.Errorf(messaging.ErrBadRequest, "invalid limit '%s'", l))
+			return
+		}
+	}
+
+	profiles, err := profile.Leaderboard(cs.profiles, limit)
+	if err != nil {
+		log.Printf("Error loading leaderboard: %v", err)
+		writeError(w, messaging.NewError(messaging.ErrInternal, "could not load leaderboard"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+// rating returns the player's current rating, or the default one for new players.
+func (cs *gameServer) rating(clientId string) float64 {
+	p, err := cs.profiles.Get(clientId)
+	if err != nil {
+		return profile.DefaultRating
+	}
+	return p.Rating
+}
+
+// recordResults updates the profiles of the game's players after a finished game.
+// Games with bots are practice and don't count. Players who left or forfeited the game lose it.
+// Called from the lobby's event loop.
+func (l *Lobby) recordResults() {
+	if len(l.seats) != l.game.NumPlayers() {
+		return
+	}
+	for _, s := range l.seats {
+		if s == nil || s.player.bot {
+			return
+		}
+	}
+
+	scores := l.game.GetScores()
+	winner := l.game.GetWinner()
+	draw := l.game.IsDraw()
+
+	best := 0
+	for _, s := range scores {
+		if s > best {
+			best = s
+		}
+	}
+
+	results := make([]profile.Result, len(l.seats))
+	for i, s := range l.seats {
+		jokers := 0
+		for _, c := range l.game.History(i) {
+			if c == game.JOKER {
+				jokers++
+			}
+		}
+
+		placement := scores[i]
+		if !draw && i == winner {
+			// The winner always places first, even after an opponent forfeited
+			placement = best + 1
+		}
+		if l.game.IsForfeited(i) {
+			// Leaving never places better than playing on
+			placement = -1
+		}
+
+		results[i] = profile.Result{
+			ClientId:  s.player.clientId,
+			Placement: placement,
+			Won:       !draw && i == winner,
+			Draw:      draw,
+			JokerUsed: jokers,
+		}
+	}
+
+	if err := profile.RecordGame(l.server.profiles, results); err != nil {
+		log.Printf("Error recording results of lobby %s: %v", l.id, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/venom1270/RPS/server/game"
+	"github.com/venom1270/RPS/server/profile"
+)
+
+// finishedLobby returns a lobby whose players joined in a different order than they got their seats.
+func finishedLobby(cs *gameServer) *Lobby {
+	l := &Lobby{id: "a", server: cs, players: []Player{{clientId: "first"}, {clientId: "second"}}}
+	l.seats = []*subscriber{{player: &Player{clientId: "second"}}, {player: &Player{clientId: "first"}}}
+	l.game = game.NewGame(game.Config{Rules: game.ClassicRules, Match: game.MatchConfig{Format: game.FIRST_TO, Target: 2}, Seed: 1})
+	l.game.MakeChoice(0, game.ROCK)
+	l.game.MakeChoice(1, game.SCISSORS)
+	l.game.CompleteRound()
+	return l
+}
+
+func wantRecord(t *testing.T, cs *gameServer, clientId string, wins int, losses int) *profile.Profile {
+	t.Helper()
+	p, err := cs.profiles.Get(clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Wins != wins || p.Losses != losses {
+		t.Errorf("%s: %d wins %d losses, want %d and %d", clientId, p.Wins, p.Losses, wins, losses)
+	}
+	return p
+}
+
+func TestRecordResultsBySeat(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	l := finishedLobby(cs)
+	l.game.MakeChoice(0, game.PAPER)
+	l.game.MakeChoice(1, game.ROCK)
+	l.game.CompleteRound()
+
+	l.recordResults()
+	if p := wantRecord(t, cs, "second", 1, 0); p.Rating <= profile.DefaultRating {
+		t.Errorf("winner's rating %v didn't go up", p.Rating)
+	}
+	wantRecord(t, cs, "first", 0, 1)
+}
+
+func TestRecordResultsCountsLeaverAsLoss(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	l := finishedLobby(cs)
+
+	// The leader leaves in the middle of the game
+	l.players = l.players[:1]
+	l.game.ForfeitMatch(0)
+
+	l.recordResults()
+	if p := wantRecord(t, cs, "second", 0, 1); p.Rating >= profile.DefaultRating {
+		t.Errorf("leaver's rating %v didn't go down", p.Rating)
+	}
+	wantRecord(t, cs, "first", 1, 0)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// How long players in a running game can rejoin after their connection dropped, see the -grace flag
+const defaultReconnectGrace = 30 * time.Second
+
+var ErrInvalidSession = messaging.NewError(messaging.ErrInvalidSession, "invalid session token")
+
+// newSessionToken returns a random token that identifies a player's seat.
+func newSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// connectionDropped reports whether the connection was lost rather than closed on purpose.
+func connectionDropped(err error) bool {
+	switch websocket.CloseStatus(err) {
+	case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+		return false
+	}
+	return true
+}
+
+// rejoinHandler gives a player whose connection dropped their seat back: /rejoin/{lobby}/{clientId}?token=...
+// The new connection gets a resync message with the full lobby and game state.
+func (cs *gameServer) rejoinHandler(w http.ResponseWriter, r *http.Request) {
+	lobbyId, clientId, err := lobbyParams(r.URL.Path, "/rejoin/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, "missing session token"))
+		return
+	}
+
+	lobby := cs.lobbies.Get(lobbyId)
+	if lobby == nil {
+		writeError(w, ErrLobbyNotFound)
+		return
+	}
+	if err := lobby.checkSession(clientId, token); err != nil {
+		log.Printf("Rejoining player %s to lobby %s fail! %v", clientId, lobbyId, err)
+		writeError(w, err)
+		return
+	}
+
+	c, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	player := Player{clientId: clientId}
+	cs.serveLobby(lobby, c, &player, token, nil)
+}
+
+// checkSession returns an error if the token doesn't let the player rejoin the lobby.
+func (l *Lobby) checkSession(clientId string, token string) error {
+	err := error(ErrLobbyClosed)
+	l.do(func() {
+		err = l.sessionError(clientId, token)
+	})
+	return err
+}
+
+// rejoin hands the subscriber to the event loop to take over the player's seat, see handleRejoin.
+func (l *Lobby) rejoin(s *subscriber, token string) error {
+	reply := make(chan error, 1)
+	if !l.send(rejoinEvent{s: s, token: token, reply: reply}) {
+		return ErrLobbyClosed
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-l.done:
+		return ErrLobbyClosed
+	}
+}
+
+// sendSession tells a player their session token after joining.
+func (l *Lobby) sendSession(s *subscriber) {
+	p := l.findPlayer(s.player.clientId)
+	if p == nil || p.bot {
+		return
+	}
+	l.sendTo(s, messaging.CreatePayloadMessage(messaging.CommandSession, messaging.Session{
+		Lobby:    l.id,
+		ClientId: p.clientId,
+		Token:    p.token,
+		Grace:    l.server.opts.reconnectGrace.Milliseconds(),
+	}))
+}
+
+func (l *Lobby) sessionError(clientId string, token string) error {
+	p := l.findPlayer(clientId)
+	if p == nil || p.bot || l.subscriberOf(clientId) == nil {
+		return notInLobby(false)
+	}
+	if p.token == "" || subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) != 1 {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+// handleRejoin replaces the player's subscriber with the new one, keeping its seat in the game.
+// A connection that wasn't noticed as dropped yet is closed.
+func (l *Lobby) handleRejoin(s *subscriber, token string) error {
+	if err := l.sessionError(s.player.clientId, token); err != nil {
+		return err
+	}
+
+	old := l.subscriberOf(s.player.clientId)
+	s.id = old.id
+	s.player = old.player
+	for i := range l.subscribers {
+		if l.subscribers[i] == old {
+			l.subscribers[i] = s
+		}
+	}
+	for i := range l.seats {
+		if l.seats[i] == old {
+			l.seats[i] = s
+		}
+	}
+	if !old.disconnected {
+		go old.close(websocket.StatusPolicyViolation, "Replaced by a new connection")
+	}
+
+	log.Printf("Player %s rejoined lobby %s", s.player.clientId, l.id)
+	l.publishExcept(messaging.CreateTextMessage("REJOINED "+s.player.clientId), s.player.clientId)
+	l.sendTo(s, messaging.CreatePayloadMessage(messaging.CommandResync, l.resync(s)))
+	l.sendInvite(s)
+	l.sendLobbyInfo(s)
+	l.sendChatLog(s)
+	return nil
+}
+
+// handleDisconnect keeps the seat of a player whose connection dropped during a game until the grace
+// period runs out. Otherwise the player leaves, and the lobby is disbanded if only bots are left.
+func (l *Lobby) handleDisconnect(s *subscriber, dropped bool) {
+	if !l.subscribed(s) {
+		// Replaced by a rejoin
+		return
+	}
+
+	clientId := s.player.clientId
+	inLobby := l.findPlayer(clientId) != nil
+	grace := l.server.opts.reconnectGrace
+	if dropped && inLobby && grace > 0 && (l.state == LobbyStarting || l.state == LobbyInGame) {
+		log.Printf("Connection of player %s in lobby %s dropped, keeping the seat for %v", clientId, l.id, grace)
+		s.disconnected = true
+		l.publishExcept(messaging.CreateTextMessage("DISCONNECTED "+clientId), clientId)
+		time.AfterFunc(grace, func() {
+			l.send(graceExpiredEvent{s: s})
+		})
+		return
+	}
+
+	if inLobby {
+		l.handleLeave(clientId)
+	}
+	l.handleUnsubscribe(s)
+
+	if dropped && l.countHumans() == 0 {
+		l.handleDisband()
+	}
+}
+
+// handleGraceExpired removes a disconnected player that didn't rejoin in time, they forfeit a running game.
+func (l *Lobby) handleGraceExpired(s *subscriber) {
+	if !s.disconnected || !l.subscribed(s) {
+		// Rejoined
+		return
+	}
+
+	clientId := s.player.clientId
+	log.Printf("Player %s didn't rejoin lobby %s in time", clientId, l.id)
+
+	l.handleLeave(clientId)
+	l.handleUnsubscribe(s)
+
+	if l.countHumans() == 0 && !l.stopped {
+		l.handleDisband()
+	}
+}
+
+// forfeitSeat makes a player who leaves a running game forfeit it, so the others don't wait for their choice.
+func (l *Lobby) forfeitSeat(clientId string) {
+	if l.state != LobbyInGame || l.game.IsFinished() {
+		return
+	}
+	player := -1
+	for i, s := range l.seats {
+		if s != nil && s.player.clientId == clientId {
+			player = i
+		}
+	}
+	if player < 0 {
+		return
+	}
+
+	l.game.ForfeitMatch(player)
+	l.publish(messaging.CreateTextMessage(fmt.Sprintf("Player %d forfeited the game!", player)))
+	for i := range l.inputDone {
+		l.inputDone[i] = true
+	}
+	l.checkRoundFinished()
+}
+
+// findPlayer returns the lobby's player with the client id, nil if there is none.
+func (l *Lobby) findPlayer(clientId string) *Player {
+	for i := range l.players {
+		if l.players[i].clientId == clientId {
+			return &l.players[i]
+		}
+	}
+	return nil
+}
+
+// subscriberOf returns the player's subscriber, nil if the player isn't connected.
+func (l *Lobby) subscriberOf(clientId string) *subscriber {
+	for _, s := range l.subscribers {
+		if s.player.clientId == clientId {
+			return s
+		}
+	}
+	return nil
+}
+
+func (l *Lobby) subscribed(s *subscriber) bool {
+	for _, v := range l.subscribers {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+var (
+	ErrLobbyExists   = messaging.NewError(messaging.ErrLobbyExists, "lobby already exists")
+	ErrLobbyNotFound = messaging.NewError(messaging.ErrLobbyNotFound, "lobby does not exist")
+	ErrLobbyFull     = messaging.NewError(messaging.ErrLobbyFull, "lobby is full")
+	ErrAlreadyJoined = messaging.NewError(messaging.ErrAlreadyInLobby, "player already in lobby")
+)
+
+// LobbyRegistry holds all lobbies of the server. It is safe for concurrent use.
+type LobbyRegistry struct {
+	mu      sync.RWMutex
+	lobbies []*Lobby
+	invites map[string]*Lobby // by invite code
+}
+
+func newLobbyRegistry() *LobbyRegistry {
+	return &LobbyRegistry{lobbies: []*Lobby{}, invites: map[string]*Lobby{}}
+}
+
+// CreateIfAbsent registers the lobby returned by create, unless a lobby with the same name already exists.
+// Checking and registering is atomic, create is only called if the name is free.
+// The lobby gets an invite code no other lobby has.
+func (r *LobbyRegistry) CreateIfAbsent(name string, create func() *Lobby) (*Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.get(name) != nil {
+		return nil, ErrLobbyExists
+	}
+
+	l := create()
+	for l.invite == "" || r.invites[l.invite] != nil {
+		l.invite = newInviteCode()
+	}
+	r.lobbies = append(r.lobbies, l)
+	r.invites[l.invite] = l
+	return l, nil
+}
+
+// Join adds the player to the named lobby if there is space left.
+func (r *LobbyRegistry) Join(name string, player Player) (*Lobby, error) {
+	l := r.Get(name)
+	if l == nil {
+		return nil, ErrLobbyNotFound
+	}
+	// The lobby's event loop decides if there is space, a disbanded lobby returns ErrLobbyClosed
+	if err := l.addPlayer(player); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (r *LobbyRegistry) Get(name string) *Lobby {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.get(name)
+}
+
+// get must be called with mu held.
+func (r *LobbyRegistry) get(name string) *Lobby {
+	for _, l := range r.lobbies {
+		if l.id == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// ByInvite returns the lobby with the invite code, codes are not case-sensitive. Nil if there is none.
+func (r *LobbyRegistry) ByInvite(code string) *Lobby {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.invites[strings.ToUpper(code)]
+}
+
+// Remove unregisters the lobby. Returns false if it wasn't registered.
+func (r *LobbyRegistry) Remove(l *Lobby) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, v := range r.lobbies {
+		if v == l {
+			r.lobbies = append(r.lobbies[:i], r.lobbies[i+1:]...)
+			delete(r.invites, l.invite)
+			return true
+		}
+	}
+	return false
+}
+
+// List returns a snapshot of all lobbies in creation order.
+func (r *LobbyRegistry) List() []*Lobby {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lobbies := make([]*Lobby, len(r.lobbies))
+	copy(lobbies, r.lobbies)
+	return lobbies
+}
+
+func (r *LobbyRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.lobbies)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLobbyRegistryCreateIfAbsent(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+
+	const workers = 200
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cs.createLobby("lobby", defaultLobbySettings())
+			if err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrLobbyExists) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("lobby created %d times, want 1", created)
+	}
+	if n := cs.lobbies.Len(); n != 1 {
+		t.Fatalf("registry has %d lobbies, want 1", n)
+	}
+}
+
+func TestLobbyRegistryJoinIfSpace(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	settings := defaultLobbySettings()
+	settings.MaxPlayers = 4
+	lobby, err := cs.createLobby("lobby", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 300
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	joined := 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cs.lobbies.Join("lobby", Player{clientId: fmt.Sprintf("p%d", i)})
+			switch {
+			case err == nil:
+				mu.Lock()
+				joined++
+				mu.Unlock()
+			case !errors.Is(err, ErrLobbyFull):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if joined != settings.MaxPlayers {
+		t.Fatalf("%d players joined, want %d", joined, settings.MaxPlayers)
+	}
+	if n := len(lobby.getPlayers()); n != settings.MaxPlayers {
+		t.Fatalf("lobby has %d players, want %d", n, settings.MaxPlayers)
+	}
+
+	if _, err := cs.lobbies.Join("lobby", Player{clientId: "p0"}); err == nil {
+		t.Fatal("joined a full lobby")
+	}
+	if _, err := cs.lobbies.Join("missing", Player{clientId: "x"}); !errors.Is(err, ErrLobbyNotFound) {
+		t.Fatalf("joining missing lobby: got %v, want ErrLobbyNotFound", err)
+	}
+}
+
+func TestLobbyRegistryDuplicateJoin(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	if _, err := cs.createLobby("lobby", defaultLobbySettings()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.lobbies.Join("lobby", Player{clientId: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.lobbies.Join("lobby", Player{clientId: "a"}); !errors.Is(err, ErrAlreadyJoined) {
+		t.Fatalf("got %v, want ErrAlreadyJoined", err)
+	}
+}
+
+// TestLobbyRegistryConcurrent mixes creating, joining, leaving, listing and removing lobbies.
+// Run with -race to catch unsynchronized access.
+func TestLobbyRegistryConcurrent(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+
+	const workers = 400
+	const lobbies = 10
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("lobby-%d", i%lobbies)
+			clientId := fmt.Sprintf("p%d", i)
+
+			cs.createLobby(name, defaultLobbySettings())
+
+			lobby, err := cs.lobbies.Join(name, Player{clientId: clientId})
+			if err == nil {
+				lobby.setReady(clientId, true)
+				_ = lobby.String()
+				lobby.exitLobby(clientId)
+			}
+
+			for _, l := range cs.lobbies.List() {
+				_ = l.String()
+				_ = l.humanPlayers()
+			}
+
+			if i%7 == 0 {
+				if l := cs.getLobbyByName(name); l != nil {
+					cs.lobbies.Remove(l)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, l := range cs.lobbies.List() {
+		if n := len(l.getPlayers()); n != 0 {
+			t.Errorf("lobby %s has %d players left, want 0", l.id, n)
+		}
+	}
+}
+
+func TestLobbyRegistryInvites(t *testing.T) {
+	cs := newGameServer(serverOptions{})
+	a, err := cs.createLobby("a", defaultLobbySettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := cs.createLobby("b", defaultLobbySettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.invite) != inviteLength || a.invite == b.invite {
+		t.Fatalf("invite codes %q and %q, want two different codes of length %d", a.invite, b.invite, inviteLength)
+	}
+	if got := cs.lobbies.ByInvite(strings.ToLower(a.invite)); got != a {
+		t.Fatalf("ByInvite(%q) = %v, want lobby a", a.invite, got)
+	}
+
+	cs.lobbies.Remove(a)
+	if got := cs.lobbies.ByInvite(a.invite); got != nil {
+		t.Fatalf("invite code of a removed lobby still maps to %s", got.id)
+	}
+	if got := cs.lobbies.ByInvite(b.invite); got != b {
+		t.Fatalf("ByInvite(%q) = %v, want lobby b", b.invite, got)
+	}
+}
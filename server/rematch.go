@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// How long a lobby is kept after a game if nobody starts a rematch, see the -idle flag
+const defaultIdleTimeout = 5 * time.Minute
+
+// rematch votes for another game after the game ended. With swap the players change seats.
+func (l *Lobby) rematch(clientId string, swap bool) error {
+	reply := make(chan error, 1)
+	return l.call(rematchEvent{clientId: clientId, swap: swap, reply: reply}, reply)
+}
+
+// handleRematch marks the player as ready for another game, the game starts once everyone voted.
+func (l *Lobby) handleRematch(clientId string, swap bool) error {
+	if l.state != LobbyFinished {
+		return messaging.NewError(messaging.ErrGameNotFinished, "the game isn't over yet")
+	}
+	if l.tournament != nil && l.tournament.decided {
+		return messaging.NewError(messaging.ErrRejected, "the tournament match is over")
+	}
+	p := l.findPlayer(clientId)
+	if p == nil {
+		return notInLobby(false)
+	}
+
+	p.ready = true
+	if swap {
+		l.swapSeats = true
+	}
+	l.publish(messaging.CreateTextMessage("REMATCH " + clientId))
+	l.sendLobbyState()
+	l.checkRematch()
+	return nil
+}
+
+// betweenGames reports whether no game is starting or running.
+func (l *Lobby) betweenGames() bool {
+	return l.state == LobbyCreated || l.state == LobbyFinished
+}
+
+// checkRematch prepares the lobby for a new game once all players after a game voted for a rematch.
+// The game starts like the first one, as soon as the lobby is full.
+func (l *Lobby) checkRematch() {
+	if l.state != LobbyFinished || l.stopped || len(l.players) == 0 {
+		return
+	}
+	if l.tournament != nil && l.tournament.decided {
+		return
+	}
+	for _, p := range l.players {
+		if !p.ready {
+			return
+		}
+	}
+
+	if l.swapSeats {
+		// Everyone moves one seat on, the first player takes the last seat
+		l.players = append(l.players[1:], l.players[0])
+		if len(l.subscribers) > 1 {
+			l.subscribers = append(l.subscribers[1:], l.subscribers[0])
+		}
+		l.swapSeats = false
+		l.publish(messaging.CreateTextMessage("Players changed seats"))
+	}
+
+	log.Printf("Rematch in lobby %s", l.id)
+	l.state = LobbyCreated
+	l.game = nil
+	l.sendLobbyState()
+	l.checkStartGame()
+}
+
+// recordSeries counts the finished game in the series and sends the series to everyone.
+func (l *Lobby) recordSeries() {
+	l.series.Games++
+	if clientId := l.gameWinner(); clientId == "" {
+		l.series.Draws++
+	} else {
+		for i := range l.series.Players {
+			if l.series.Players[i].ClientId == clientId {
+				l.series.Players[i].Wins++
+			}
+		}
+	}
+
+	l.publish(messaging.CreatePayloadMessage(messaging.CommandSeries, l.series))
+	l.publish(messaging.CreateTextMessage(seriesSummary(l.series)))
+}
+
+// gameWinner returns the client id of the finished game's winner, empty for a draw.
+func (l *Lobby) gameWinner() string {
+	winner := l.game.GetWinner()
+	if l.game.IsDraw() || winner < 0 || winner >= len(l.seats) {
+		return ""
+	}
+	return l.seats[winner].player.clientId
+}
+
+// resetSeries starts a new series if other players than in the last game play.
+func (l *Lobby) resetSeries() {
+	ids := l.playerIds()
+	same := len(ids) == len(l.series.Players)
+	for _, id := range ids {
+		found := false
+		for _, p := range l.series.Players {
+			found = found || p.ClientId == id
+		}
+		same = same && found
+	}
+	if same {
+		return
+	}
+
+	l.series = messaging.Series{Players: []messaging.SeriesScore{}}
+	for _, id := range ids {
+		l.series.Players = append(l.series.Players, messaging.SeriesScore{ClientId: id})
+	}
+}
+
+// seriesSummary formats the series, e.g. "Series after 3 games: a 2, b 1"
+func seriesSummary(s messaging.Series) string {
+	scores := []string{}
+	for _, p := range s.Players {
+		scores = append(scores, fmt.Sprintf("%s %d", p.ClientId, p.Wins))
+	}
+	games := "games"
+	if s.Games == 1 {
+		games = "game"
+	}
+	str := fmt.Sprintf("Series after %d %s: %s", s.Games, games, strings.Join(scores, ", "))
+	if s.Draws > 0 {
+		str += fmt.Sprintf(" (%d draws)", s.Draws)
+	}
+	return str
+}
+
+// handleIdle closes the lobby if nobody started a rematch since the game ended.
+func (l *Lobby) handleIdle(games int) {
+	if l.state != LobbyFinished || l.series.Games != games {
+		return
+	}
+	if l.tournament != nil && !l.tournament.decided {
+		// The tournament waits for the match to be played again
+		return
+	}
+	log.Printf("Lobby %s was idle after the game", l.id)
+	l.publish(messaging.CreateTextMessage("Nobody wanted a rematch, closing the lobby"))
+	l.handleDisband()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+)
+
+// finishedTestGame returns a lobby after its first game, a single round that a won.
+func finishedTestGame(t *testing.T) (*Lobby, []*subscriber) {
+	t.Helper()
+	settings := defaultLobbySettings()
+	settings.Match = game.MatchConfig{Format: game.FIRST_TO, Target: 1}
+	l, subs := startTestGameWith(t, settings)
+	playTestRound(l, subs, game.PAPER, game.ROCK)
+	return l, subs
+}
+
+// playTestRound makes the choices of the subscribers, in order.
+func playTestRound(l *Lobby, subs []*subscriber, choices ...game.PlayerChoice) {
+	for i, c := range choices {
+		l.send(choiceEvent{s: subs[i], msg: *messaging.CreateTextMessage(strconv.Itoa(int(c)))})
+	}
+}
+
+// startRematch starts the game the lobby counts down to, without waiting for the countdown.
+func startRematch(t *testing.T, l *Lobby) {
+	t.Helper()
+	l.do(func() {
+		if l.state != LobbyStarting {
+			t.Fatalf("lobby %s, want the rematch starting", l.state)
+		}
+		l.handleStart()
+	})
+}
+
+func TestRematchNeedsEveryVote(t *testing.T) {
+	l, _ := finishedTestGame(t)
+	if err := l.rematch("a", false); err != nil {
+		t.Fatal(err)
+	}
+	if state := l.getLobbyState(); state != LobbyFinished {
+		t.Errorf("lobby %s after one vote, want it to wait for the other player", state)
+	}
+
+	if err := l.rematch("b", false); err != nil {
+		t.Fatal(err)
+	}
+	if state := l.getLobbyState(); state != LobbyStarting {
+		t.Errorf("lobby %s after everyone voted, want the rematch starting", state)
+	}
+}
+
+func TestRematchNotFinished(t *testing.T) {
+	l, _ := startTestGame(t)
+	if err := l.rematch("a", false); messaging.CodeOf(err) != messaging.ErrGameNotFinished {
+		t.Errorf("rematch during the game: %v, want %s", err, messaging.ErrGameNotFinished)
+	}
+}
+
+func TestRematchSwap(t *testing.T) {
+	l, subs := finishedTestGame(t)
+	l.rematch("a", true)
+	l.rematch("b", false)
+	startRematch(t, l)
+
+	l.do(func() {
+		if ids := l.playerIds(); ids[0] != "b" || ids[1] != "a" {
+			t.Errorf("players %v, want them to change seats", ids)
+		}
+		if l.subscribers[0] != subs[1] || l.subscribers[1] != subs[0] {
+			t.Error("subscribers didn't change seats with the players")
+		}
+		if l.seats[0] != subs[1] {
+			t.Error("b doesn't play the first seat")
+		}
+	})
+}
+
+func TestSeries(t *testing.T) {
+	l, subs := finishedTestGame(t)
+	wantSeries := func(games int, wins ...int) {
+		t.Helper()
+		l.do(func() {
+			if l.series.Games != games || len(l.series.Players) != len(wins) {
+				t.Fatalf("series %+v, want %d games of %d players", l.series, games, len(wins))
+			}
+			for i, p := range l.series.Players {
+				if p.Wins != wins[i] {
+					t.Errorf("series %+v, want wins %v", l.series, wins)
+				}
+			}
+		})
+	}
+	wantSeries(1, 1, 0)
+
+	// The series goes on in the rematch
+	l.rematch("a", false)
+	l.rematch("b", false)
+	startRematch(t, l)
+	playTestRound(l, subs, game.ROCK, game.PAPER)
+	wantSeries(2, 1, 1)
+
+	// Another player joins in, a new series starts
+	l.exitLobby("a")
+	c := &subscriber{player: &Player{clientId: "c"}, msgs: make(chan messaging.Message, 256), closeSlow: func() {}}
+	l.do(func() {
+		l.handleJoin(Player{clientId: "c"})
+		l.subscribers = append(l.subscribers, c)
+	})
+	l.rematch("b", false)
+	l.rematch("c", false)
+	startRematch(t, l)
+	wantSeries(0, 0, 0)
+	l.do(func() {
+		if l.series.Players[0].ClientId != "b" || l.series.Players[1].ClientId != "c" {
+			t.Errorf("series players %+v, want b and c", l.series.Players)
+		}
+	})
+}
+
+func TestIdleLobby(t *testing.T) {
+	l, subs := finishedTestGame(t)
+	l.rematch("a", false)
+	l.rematch("b", false)
+	startRematch(t, l)
+
+	// Idle after the first game, but the second one is running
+	l.send(idleEvent{games: 1})
+	if state := l.getLobbyState(); state != LobbyInGame {
+		t.Fatalf("lobby %s, want the rematch still running", state)
+	}
+
+	playTestRound(l, subs, game.ROCK, game.PAPER)
+	l.send(idleEvent{games: 1})
+	if state := l.getLobbyState(); state != LobbyFinished {
+		t.Fatalf("lobby %s, want it kept after a rematch was played", state)
+	}
+
+	l.send(idleEvent{games: 2})
+	<-l.done
+	if l.server.lobbies.Get("game") != nil {
+		t.Error("lobby idle after the last game wasn't closed")
+	}
+}
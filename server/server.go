@@ -7,41 +7,76 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/coder/websocket"
-	"github.com/venom1270/RPS/game"
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/chat"
+	"github.com/venom1270/RPS/server/game"
+	"github.com/venom1270/RPS/server/profile"
 )
 
 type gameServer struct {
 	// serveMux routes the various endpoints to the appropriate handler.
 	serveMux http.ServeMux
 	// LOBBIES
-	lobbies []*Lobby
+	lobbies     *LobbyRegistry
+	tournaments *TournamentRegistry
+
+	opts serverOptions
+
+	matchmaker *matchmaker
+	profiles   profile.Store
+	// chatFilter checks chat messages before they are sent, nil lets everything through
+	chatFilter chat.Filter
 }
 
-func newGameServer() *gameServer {
-	cs := &gameServer{}
+// acceptOptions offers the JSON and legacy wire formats, clients without a subprotocol get the legacy format.
+var acceptOptions = &websocket.AcceptOptions{Subprotocols: messaging.Subprotocols}
+
+func newGameServer(opts serverOptions) *gameServer {
+	cs := &gameServer{opts: opts, lobbies: newLobbyRegistry(), tournaments: newTournamentRegistry()}
+	cs.matchmaker = newMatchmaker(cs)
+	cs.profiles = profile.NewMemoryStore()
 	cs.serveMux.Handle("/", http.FileServer(http.Dir(".")))
 
 	// Lobby functions
 	cs.serveMux.HandleFunc("/getLobbyList", cs.getLobbyList)
 	cs.serveMux.HandleFunc("/createLobby/", cs.createLobbyHandler)
 	cs.serveMux.HandleFunc("/joinLobby/", cs.joinLobbyHandler)
+	cs.serveMux.HandleFunc("/rejoin/", cs.rejoinHandler)
+	cs.serveMux.HandleFunc("/spectateLobby/", cs.spectateHandler)
+	cs.serveMux.HandleFunc("/invite/", cs.inviteHandler)
+	cs.serveMux.HandleFunc("/matchmake/", cs.matchmakeHandler)
+
+	// Player profiles
+	cs.serveMux.HandleFunc("/players/", cs.playerHandler)
+	cs.serveMux.HandleFunc("/leaderboard", cs.leaderboardHandler)
+
+	// Tournaments
+	cs.serveMux.HandleFunc("/tournaments", cs.tournamentListHandler)
+	cs.serveMux.HandleFunc("/tournaments/", cs.tournamentHandler)
+
+	// REST API
+	cs.serveMux.HandleFunc(apiPrefix+"/", cs.apiNotFound)
+	cs.serveMux.HandleFunc(apiPrefix+"/lobbies", cs.apiLobbies)
+	cs.serveMux.HandleFunc(apiPrefix+"/lobbies/{name}", cs.apiLobby)
 
 	return cs
 }
 
 func (cs *gameServer) getMsg(w http.ResponseWriter, r *http.Request) ([]byte, error) {
 	if r.Method != "POST" {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use POST"))
 		return []byte{}, errors.New("wrong method")
 	}
 	body := http.MaxBytesReader(w, r.Body, 8192)
 	msg, err := io.ReadAll(body)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		writeJSON(w, http.StatusRequestEntityTooLarge, messaging.Error{Code: messaging.ErrBadRequest, Message: "message too large"})
 		return []byte{}, errors.New("error reading meassage")
 	}
 	return msg, nil
@@ -77,7 +112,7 @@ func (cs *gameServer) getLobbyList(w http.ResponseWriter, r *http.Request) {
 
 	_, _, err = cs.splitClientMsg(msg)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, err.Error()))
 		log.Println("error splitting client msg")
 		return
 	}
@@ -86,7 +121,10 @@ func (cs *gameServer) getLobbyList(w http.ResponseWriter, r *http.Request) {
 
 	// Format lobby string
 	responseStr := ""
-	for _, l := range cs.lobbies {
+	for _, l := range cs.lobbies.List() {
+		if !l.listed() {
+			continue
+		}
 		responseStr += l.String() + ";"
 	}
 	if len(responseStr) > 0 {
@@ -102,95 +140,209 @@ func (cs *gameServer) getLobbyList(w http.ResponseWriter, r *http.Request) {
 
 func (cs *gameServer) createLobbyHandler(w http.ResponseWriter, r *http.Request) {
 
-	params := strings.Split(strings.TrimPrefix(r.URL.Path, "/createLobby/"), "/")
-
-	lobbyId := params[0]
-	clientId := params[1]
+	lobbyId, clientId, err := lobbyParams(r.URL.Path, "/createLobby/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
 	log.Println("Mesage accepted with lobby name:", lobbyId)
 
-	lobby := cs.createLobby(lobbyId)
-	if lobby == nil {
-		w.WriteHeader(http.StatusBadRequest)
+	settings, err := parseLobbySettings(r.URL.Query())
+	if err != nil {
+		log.Printf("Lobby creation failed - %v", err)
+		writeError(w, messaging.NewError(messaging.ErrInvalidSettings, err.Error()))
 		return
 	}
 
-	cs.joinLobby(w, r, lobby, clientId)
-	//ok := cs.joinLobby(w, r, lobby, clientId)
-	/*if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-	}*/
-}
-
-func (cs *gameServer) createLobby(lobbyName string) *Lobby {
-	exists := cs.getLobbyByName(lobbyName)
-	if exists != nil {
-		log.Printf("Lobby creation failed - '%s' already exists", lobbyName)
-		return nil
+	_, err = cs.createLobby(lobbyId, settings)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	newLobby := &Lobby{
-		id:         lobbyName,
-		maxPlayers: 2,
-		state:      "CREATED",
+	cs.joinLobby(w, r, lobbyId, clientId)
+}
 
-		subscriberMessageBuffer: 16,
-		subscriberIdCount:       0,
-		logf:                    log.Printf,
-		subscribers:             []*subscriber{},
-		server:                  cs,
+// lobbyParams reads the lobby name and client id from a path like /joinLobby/{lobby}/{clientId}
+func lobbyParams(path string, prefix string) (string, string, error) {
+	params := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	if len(params) != 2 || params[0] == "" || params[1] == "" {
+		return "", "", messaging.Errorf(messaging.ErrBadRequest, "expected %s{lobby}/{clientId}", prefix)
 	}
+	return params[0], params[1], nil
+}
 
-	cs.lobbies = append(cs.lobbies, newLobby)
+// Longest lobby password accepted
+const maxPasswordLength = 64
 
-	return newLobby
+// parseLobbySettings reads lobby options from the query string, e.g. ?rules=classic&players=4&format=best-of&target=5
+func parseLobbySettings(q url.Values) (LobbySettings, error) {
+	return applyLobbySettings(defaultLobbySettings(), q)
 }
 
-func (cs *gameServer) joinLobbyHandler(w http.ResponseWriter, r *http.Request) {
-	params := strings.Split(strings.TrimPrefix(r.URL.Path, "/joinLobby/"), "/")
+// applyLobbySettings changes the settings given in the query string, the others are kept.
+func applyLobbySettings(settings LobbySettings, q url.Values) (LobbySettings, error) {
+	if name := q.Get("rules"); name != "" {
+		rules, ok := game.GetRuleSet(name)
+		if !ok {
+			return settings, fmt.Errorf("unknown rule set '%s'", name)
+		}
+		settings.Rules = rules
+	}
+
+	if p := q.Get("players"); p != "" {
+		players, err := strconv.Atoi(p)
+		if err != nil || players < game.MinPlayers || players > game.MaxPlayers {
+			return settings, fmt.Errorf("invalid number of players '%s'", p)
+		}
+		settings.MaxPlayers = players
+	}
 
-	fmt.Println(r.URL.Path)
-	fmt.Println(params)
-	fmt.Println(params[0])
+	switch q.Get("scoring") {
+	case "":
+	case "pairwise":
+		settings.Scoring = game.PAIRWISE
+	case "minority":
+		settings.Scoring = game.MINORITY
+	default:
+		return settings, fmt.Errorf("unknown scoring mode '%s'", q.Get("scoring"))
+	}
+	if settings.Scoring == game.MINORITY && settings.MaxPlayers < game.MinMinorityPlayers {
+		// Also checked when only the number of players changes
+		return settings, fmt.Errorf("minority scoring needs at least %d players", game.MinMinorityPlayers)
+	}
 
-	lobbyId := params[0]
-	clientId := params[1]
+	if f := q.Get("format"); f != "" {
+		format, err := game.ParseMatchFormat(f)
+		if err != nil {
+			return settings, err
+		}
+		settings.Match.Format = format
+	}
+	if t := q.Get("target"); t != "" {
+		target, err := strconv.Atoi(t)
+		if err != nil {
+			return settings, fmt.Errorf("invalid match target '%s'", t)
+		}
+		settings.Match.Target = target
+	}
+	if m := q.Get("maxRounds"); m != "" {
+		maxRounds, err := strconv.Atoi(m)
+		if err != nil {
+			return settings, fmt.Errorf("invalid max rounds '%s'", m)
+		}
+		settings.Match.MaxRounds = maxRounds
+	}
+	if sd := q.Get("suddenDeath"); sd != "" {
+		suddenDeath, err := strconv.ParseBool(sd)
+		if err != nil {
+			return settings, fmt.Errorf("invalid sudden death flag '%s'", sd)
+		}
+		settings.Match.SuddenDeath = suddenDeath
+	}
+	if err := settings.Match.Validate(); err != nil {
+		return settings, err
+	}
 
-	log.Println("Mesage accepted with lobby name:", lobbyId)
+	if t := q.Get("timeout"); t != "" {
+		seconds, err := strconv.Atoi(t)
+		if err != nil || seconds < 0 {
+			return settings, fmt.Errorf("invalid round timeout '%s'", t)
+		}
+		settings.RoundTimeout = time.Duration(seconds) * time.Second
+	}
+	if p := q.Get("onTimeout"); p != "" {
+		policy, err := ParseTimeoutPolicy(p)
+		if err != nil {
+			return settings, err
+		}
+		settings.TimeoutPolicy = policy
+	}
 
-	var lobby *Lobby
-	for i, l := range cs.lobbies {
-		if l.id == lobbyId {
-			lobby = cs.lobbies[i]
-			break
+	if cr := q.Get("commitReveal"); cr != "" {
+		commitReveal, err := strconv.ParseBool(cr)
+		if err != nil {
+			return settings, fmt.Errorf("invalid commit-reveal flag '%s'", cr)
 		}
+		settings.CommitReveal = commitReveal
 	}
 
+	if p := q.Get("private"); p != "" {
+		private, err := strconv.ParseBool(p)
+		if err != nil {
+			return settings, fmt.Errorf("invalid private flag '%s'", p)
+		}
+		settings.Private = private
+	}
+	if q.Has("password") {
+		if len(q.Get("password")) > maxPasswordLength {
+			return settings, fmt.Errorf("password longer than %d characters", maxPasswordLength)
+		}
+		settings.Password = q.Get("password")
+	}
+
+	return settings, nil
+}
+
+// createLobby registers a new lobby, fails with ErrLobbyExists if the name is taken.
+func (cs *gameServer) createLobby(lobbyName string, settings LobbySettings) (*Lobby, error) {
+	lobby, err := cs.lobbies.CreateIfAbsent(lobbyName, func() *Lobby {
+		return newLobby(lobbyName, settings, cs)
+	})
+	if err != nil {
+		log.Printf("Lobby creation failed - '%s': %v", lobbyName, err)
+	}
+	return lobby, err
+}
+
+func (cs *gameServer) joinLobbyHandler(w http.ResponseWriter, r *http.Request) {
+	lobbyId, clientId, err := lobbyParams(r.URL.Path, "/joinLobby/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Println("Mesage accepted with lobby name:", lobbyId)
+
+	lobby := cs.lobbies.Get(lobbyId)
 	if lobby == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("Error joining to lobby %s - it does not exist!", lobbyId)
+		writeError(w, ErrLobbyNotFound)
+		return
+	}
+	if err := lobbyAccess(r, lobby); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	cs.joinLobby(w, r, lobby, clientId)
-	/*ok := cs.joinLobby(w, r, lobby, clientId)
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-	}*/
+	cs.joinLobby(w, r, lobbyId, clientId)
 }
 
-func (cs *gameServer) joinLobby(w http.ResponseWriter, r *http.Request, lobby *Lobby, clientId string) bool {
+// joinLobby takes a seat in the lobby if there is one left and subscribes the client's connection.
+func (cs *gameServer) joinLobby(w http.ResponseWriter, r *http.Request, lobbyId string, clientId string) bool {
 
 	player := Player{clientId: clientId, ready: false}
-	if len(lobby.players) < lobby.maxPlayers {
-		lobby.players = append(lobby.players, player)
-		log.Printf("Joining player %s to lobby %s successful! %d/%d", clientId, lobby.id, len(lobby.players), lobby.maxPlayers)
-	} else {
-		log.Printf("Joining player %s to lobby %s fail! Not enough space!", clientId, lobby.id)
+	lobby, err := cs.lobbies.Join(lobbyId, player)
+	if err != nil {
+		log.Printf("Joining player %s to lobby %s fail! %v", clientId, lobbyId, err)
+		writeError(w, err)
 		return false
 	}
 
-	err := lobby.subscribe(w, r, &player)
+	c, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		log.Printf("%v", err)
+		lobby.exitLobby(clientId)
+		return false
+	}
+
+	return cs.serveLobby(lobby, c, &player, "", nil)
+}
+
+// serveLobby subscribes the connection to the lobby until it disconnects. A session token rejoins the player's seat,
+// reads are the connection's messages if it is already read (see readConn).
+func (cs *gameServer) serveLobby(lobby *Lobby, c *websocket.Conn, player *Player, token string, reads <-chan connMessage) bool {
+	err := lobby.subscribeConn(c, player, token, reads)
 
 	if errors.Is(err, context.Canceled) {
 		return false
@@ -200,12 +352,6 @@ func (cs *gameServer) joinLobby(w http.ResponseWriter, r *http.Request, lobby *L
 		return false
 	}
 
-	if websocket.CloseStatus(err) == -1 {
-		// TODO: or if "host"?
-		if len(lobby.players) == 0 {
-			cs.disbandLobby(lobby)
-		}
-	}
 	if err != nil {
 		//cs.logf("%v", err)
 		log.Printf("%v", err)
@@ -243,46 +389,7 @@ func (cs *gameServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cs *gameServer) getLobbyByName(name string) *Lobby {
-	for i, _ := range cs.lobbies {
-		if cs.lobbies[i].id == name {
-			return cs.lobbies[i]
-		}
-	}
-	return nil
-}
-
-func (cs *gameServer) disbandLobby(l *Lobby) {
-	log.Printf("Disconnecting subscribers from lobby %s", l.id)
-	for i, _ := range l.subscribers {
-		if len(l.subscribers) > i && l.subscribers[i] != nil { // TODO: len check is a workaround for deleting subscibers...
-			l.subscribers[i].c.Close(websocket.StatusAbnormalClosure, "TIMOUT")
-		}
-	}
-
-	log.Printf("Removing lobby %s", l.id)
-	for i, _ := range cs.lobbies {
-		if cs.lobbies[i].id == l.id {
-			cs.lobbies = append(cs.lobbies[:i], cs.lobbies[i+1:]...)
-			break
-		}
-	}
-
-	log.Printf("Done disbanding lobby %s", l.id)
-
-}
-
-func intToPlayerChoice(choice int) game.PlayerChoice {
-	switch choice {
-	case int(game.ROCK):
-		return game.ROCK
-	case int(game.SCISSORS):
-		return game.SCISSORS
-	case int(game.PAPER):
-		return game.PAPER
-	case int(game.JOKER):
-		return game.JOKER
-	}
-	return game.ROCK // TODO!!!
+	return cs.lobbies.Get(name)
 }
 
 func writeTimeout(ctx context.Context, timeout time.Duration, c *websocket.Conn, msg []byte) error {
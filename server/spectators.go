@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// spectateHandler subscribes a read-only connection to the lobby: /spectateLobby/{lobby} or /spectateLobby/{lobby}/{clientId}
+func (cs *gameServer) spectateHandler(w http.ResponseWriter, r *http.Request) {
+	params := strings.Split(strings.TrimPrefix(r.URL.Path, "/spectateLobby/"), "/")
+	if params[0] == "" || len(params) > 2 {
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, "expected /spectateLobby/{lobby}"))
+		return
+	}
+	lobbyId := params[0]
+	clientId := ""
+	if len(params) == 2 {
+		clientId = params[1]
+	}
+
+	lobby := cs.lobbies.Get(lobbyId)
+	if lobby == nil {
+		writeError(w, ErrLobbyNotFound)
+		return
+	}
+	if err := lobbyAccess(r, lobby); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	c, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	err = lobby.spectateConn(c, clientId)
+	if err != nil && connectionDropped(err) {
+		log.Printf("Spectator of lobby %s disconnected: %v", lobbyId, err)
+	}
+}
+
+// spectateConn sends everything published in the lobby to the connection until it closes.
+// Spectators can ask for the game state, any other command is refused.
+func (l *Lobby) spectateConn(c *websocket.Conn, clientId string) error {
+	s := &subscriber{
+		player:    &Player{clientId: clientId},
+		msgs:      make(chan messaging.Message, l.subscriberMessageBuffer),
+		codec:     messaging.CodecFor(c.Subprotocol()),
+		readCmdCh: make(chan messaging.Message, l.subscriberMessageBuffer),
+		readErrCh: make(chan error, 1),
+		closeSlow: func() {
+			c.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
+		},
+		c: c,
+	}
+
+	defer c.CloseNow()
+	if !l.send(spectateEvent{s: s}) {
+		return ErrLobbyClosed
+	}
+	defer l.send(unspectateEvent{s: s})
+
+	ctx := context.Background()
+	s.write(ctx, messaging.CreateTextMessage("Welcome to lobby "+l.id+", you are spectating"))
+
+	go func() {
+		for {
+			_, m, err := c.Read(ctx)
+			if err != nil {
+				s.readErrCh <- err
+				return
+			}
+			s.readCmdCh <- s.codec.Decode(m)
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-s.msgs:
+			if err := writeTimeout(ctx, time.Second*5, c, s.codec.Encode(msg)); err != nil {
+				return err
+			}
+
+		case req := <-s.readCmdCh:
+			switch {
+			case req.Type == messaging.MessageCorrupted:
+				s.write(ctx, s.reply(req, messaging.NewError(messaging.ErrBadRequest, req.Content), nil))
+			case req.Type == messaging.MessageCommand && req.Cmd == messaging.CommandGameState:
+				s.write(ctx, l.gameStateReply(s, req))
+			case req.Type == messaging.MessageCommand && req.Cmd == messaging.CommandLobbyExit:
+				s.write(ctx, s.reply(req, nil, nil))
+				return c.Close(websocket.StatusNormalClosure, "Stopped spectating")
+			case req.Type == messaging.MessageCommand && req.Cmd == 123:
+				s.write(ctx, s.reply(req, nil, messaging.CreateTextMessage("Pong")))
+			default:
+				s.write(ctx, s.reply(req, messaging.NewError(messaging.ErrReadOnly, "Spectators can't play"), nil))
+			}
+
+		case err := <-s.readErrCh:
+			return err
+		}
+	}
+}
+
+// handleSpectate adds a spectator and sends it the current lobby and game state.
+func (l *Lobby) handleSpectate(s *subscriber) {
+	l.spectators = append(l.spectators, s)
+	log.Printf("Spectator joined lobby %s, %d watching", l.id, len(l.spectators))
+
+	l.sendTo(s, messaging.CreatePayloadMessage(messaging.CommandLobbyState, l.lobbyState()))
+	if l.game != nil {
+		l.sendTo(s, messaging.CreatePayloadMessage(messaging.CommandGameState, newGameDetails(l.game, l.seatIds())))
+	}
+	l.sendLobbyInfo(s)
+	l.sendChatLog(s)
+}
+
+func (l *Lobby) handleUnspectate(s *subscriber) {
+	for i := range l.spectators {
+		if l.spectators[i] == s {
+			l.spectators = append(l.spectators[:i], l.spectators[i+1:]...)
+			break
+		}
+	}
+}
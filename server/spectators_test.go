@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+)
+
+// spectatedChoices returns the choices of each player the game details show.
+func spectatedChoices(d messaging.GameDetails) [][]int {
+	choices := [][]int{}
+	for _, p := range d.Players {
+		choices = append(choices, p.Choices)
+	}
+	return choices
+}
+
+func TestSpectatorsDontSeeLockedChoices(t *testing.T) {
+	l, subs := startTestGame(t)
+	l.send(choiceEvent{s: subs[0], msg: *messaging.CreateTextMessage("1")})
+
+	s := &subscriber{player: &Player{}, msgs: make(chan messaging.Message, 256), closeSlow: func() {}}
+	l.send(spectateEvent{s: s})
+
+	var joined *messaging.GameDetails
+	l.do(func() {
+		for len(s.msgs) > 0 {
+			if msg := <-s.msgs; msg.Cmd == messaging.CommandGameState {
+				d := msg.Payload.(messaging.GameDetails)
+				joined = &d
+			}
+		}
+	})
+	if joined == nil {
+		t.Fatal("spectator didn't get the game state after joining")
+	}
+	for _, c := range spectatedChoices(*joined) {
+		if len(c) > 0 {
+			t.Errorf("spectator joining sees choices %v before the round is resolved", spectatedChoices(*joined))
+		}
+	}
+
+	req := *messaging.CreateCommandMessage(messaging.CommandGameState, "")
+	reply := l.gameStateReply(s, req)
+	for _, c := range spectatedChoices(reply.Payload.(messaging.GameDetails)) {
+		if len(c) > 0 {
+			t.Errorf("game state request shows choices %v before the round is resolved", spectatedChoices(reply.Payload.(messaging.GameDetails)))
+		}
+	}
+
+	l.send(choiceEvent{s: subs[1], msg: *messaging.CreateTextMessage("0")})
+	reply = l.gameStateReply(s, req)
+	if got := spectatedChoices(reply.Payload.(messaging.GameDetails)); len(got[0]) != 1 || got[0][0] != 1 {
+		t.Errorf("choices %v after the round, want a's PAPER", got)
+	}
+}
+
+func TestSpectatorsAreReadOnly(t *testing.T) {
+	l, _ := startTestGame(t)
+	srv := httptest.NewServer(l.server)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/spectateLobby/game/watcher",
+		&websocket.DialOptions{Subprotocols: []string{messaging.SubprotocolJSON}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseNow()
+
+	requests := []*messaging.Message{
+		messaging.CreateCommandMessage(messaging.CommandLobbyReady, ""),
+		messaging.CreateCommandMessage(messaging.CommandChoice, "0"),
+		messaging.CreateCommandMessage(messaging.CommandLobbyAddBot, "random"),
+		messaging.CreateCommandMessage(messaging.CommandLobbyKick, "a"),
+		messaging.CreateCommandMessage(messaging.CommandChat, "hi"),
+		messaging.CreateTextMessage("0"),
+	}
+	for i, req := range requests {
+		req.Id = string(rune('a' + i))
+		if err := c.Write(ctx, websocket.MessageText, messaging.JSONCodec.Encode(*req)); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, b, err := c.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := messaging.JSONCodec.Decode(b)
+			if msg.Id != req.Id {
+				continue
+			}
+			var r messaging.Reply
+			if err := msg.DecodePayload(&r); err != nil {
+				t.Fatal(err)
+			}
+			if messaging.CodeOf(r.Err()) != messaging.ErrReadOnly {
+				t.Errorf("%v request of a spectator: %v, want %s", req.Cmd, r.Err(), messaging.ErrReadOnly)
+			}
+			break
+		}
+	}
+}
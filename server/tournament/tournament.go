@@ -0,0 +1,590 @@
+// Package tournament generates the pairings of a tournament and advances it with the results of its matches.
+// Matches are played in rounds, a round starts once all matches of the previous one are done.
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+var (
+	ErrStarted           = errors.New("tournament already started")
+	ErrNotStarted        = errors.New("tournament not started")
+	ErrAlreadyRegistered = errors.New("player already registered")
+	ErrTooFewPlayers     = errors.New("at least 2 players are needed")
+	ErrUnknownMatch      = errors.New("unknown match")
+	ErrMatchDone         = errors.New("match already done")
+	ErrNotInMatch        = errors.New("player doesn't play in the match")
+)
+
+// Format decides how players are paired.
+type Format int
+
+const (
+	SingleElimination Format = iota // losers are out, winners play each other until one is left
+	DoubleElimination               // players are out after their second loss, see pairDoubleElimination
+	Swiss                           // a fixed number of rounds, players with the same points play each other
+	RoundRobin                      // everyone plays everyone once
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "single-elimination":
+		return SingleElimination, nil
+	case "double-elimination":
+		return DoubleElimination, nil
+	case "swiss":
+		return Swiss, nil
+	case "round-robin":
+		return RoundRobin, nil
+	}
+	return SingleElimination, fmt.Errorf("unknown tournament format '%s'", s)
+}
+
+func (f Format) String() string {
+	switch f {
+	case DoubleElimination:
+		return "double-elimination"
+	case Swiss:
+		return "swiss"
+	case RoundRobin:
+		return "round-robin"
+	default:
+		return "single-elimination"
+	}
+}
+
+// Elimination reports whether a match has to have a winner, drawn matches are played again.
+func (f Format) Elimination() bool {
+	return f == SingleElimination || f == DoubleElimination
+}
+
+// Brackets of a double elimination tournament, matches of other formats have none.
+const (
+	WinnersBracket = "winners"
+	LosersBracket  = "losers"
+	GrandFinal     = "final"
+)
+
+// Match is a game between two players. A bye has a single player who wins right away.
+type Match struct {
+	Id      int
+	Round   int
+	Bracket string
+	Players []string
+	Winner  string // empty for a draw and while the match isn't done
+	Draws   int    // drawn games of an elimination match, they are played again
+	Done    bool
+}
+
+// Bye reports whether the match has no opponent.
+func (m *Match) Bye() bool {
+	return len(m.Players) == 1
+}
+
+// Standing is a player's record in the tournament.
+type Standing struct {
+	ClientId string
+	Wins     int // byes count as wins
+	Losses   int
+	Draws    int
+	// Points are 1 per win and 0.5 per draw
+	Points     float64
+	Eliminated bool
+	// Round the player was eliminated in, players out later rank higher
+	eliminatedRound int
+	seed            int
+}
+
+// Tournament holds the players, matches and standings. It is not safe for concurrent use.
+type Tournament struct {
+	format  Format
+	rounds  int // planned rounds of Swiss and round-robin tournaments
+	players []string
+	matches []*Match
+	round   int
+	started bool
+	done    bool
+
+	standings map[string]*Standing
+	// Players still in the winners and losers bracket of elimination tournaments, in bracket order
+	winners []string
+	losers  []string
+	// Opponents each player already played, Swiss avoids rematches
+	played map[string]map[string]bool
+}
+
+// New creates a tournament open for registration. Swiss tournaments play the given number of rounds,
+// 0 picks enough rounds to find a single winner. Other formats ignore rounds.
+func New(format Format, rounds int) *Tournament {
+	return &Tournament{
+		format:    format,
+		rounds:    rounds,
+		players:   []string{},
+		matches:   []*Match{},
+		standings: map[string]*Standing{},
+		played:    map[string]map[string]bool{},
+	}
+}
+
+func (t *Tournament) Format() Format {
+	return t.format
+}
+
+// Register adds a player until the tournament starts.
+func (t *Tournament) Register(clientId string) error {
+	if t.started {
+		return ErrStarted
+	}
+	if t.standings[clientId] != nil {
+		return ErrAlreadyRegistered
+	}
+	t.players = append(t.players, clientId)
+	t.standings[clientId] = &Standing{ClientId: clientId}
+	return nil
+}
+
+// Players returns the registered players in seed order.
+func (t *Tournament) Players() []string {
+	players := make([]string, len(t.players))
+	copy(players, t.players)
+	return players
+}
+
+// Start seeds the players by rating, highest first, and pairs the first round.
+// With a nil rating the players are seeded in the order they registered.
+// Returns the matches to play, byes are done already.
+func (t *Tournament) Start(rating func(clientId string) float64) ([]Match, error) {
+	if t.started {
+		return nil, ErrStarted
+	}
+	if len(t.players) < 2 {
+		return nil, ErrTooFewPlayers
+	}
+	t.started = true
+
+	if rating != nil {
+		sort.SliceStable(t.players, func(i, j int) bool {
+			return rating(t.players[i]) > rating(t.players[j])
+		})
+	}
+	for i, p := range t.players {
+		t.standings[p].seed = i
+		t.played[p] = map[string]bool{}
+	}
+
+	switch t.format {
+	case Swiss:
+		if t.rounds <= 0 {
+			t.rounds = bits.Len(uint(len(t.players) - 1))
+		}
+	case RoundRobin:
+		t.rounds = len(t.players) - 1
+		if len(t.players)%2 == 1 {
+			t.rounds++
+		}
+	default:
+		t.winners = seedBracket(t.players)
+		t.losers = []string{}
+	}
+
+	return t.nextRound(), nil
+}
+
+// Started reports whether registration is closed.
+func (t *Tournament) Started() bool {
+	return t.started
+}
+
+// Finished reports whether all rounds were played.
+func (t *Tournament) Finished() bool {
+	return t.done
+}
+
+// Round returns the current round counted from 1, 0 before the start.
+func (t *Tournament) Round() int {
+	return t.round
+}
+
+// Rounds returns the planned number of rounds of Swiss and round-robin tournaments, 0 for elimination.
+func (t *Tournament) Rounds() int {
+	return t.rounds
+}
+
+// Match returns a copy of the match, false if there is none with the id.
+func (t *Tournament) Match(id int) (Match, bool) {
+	if id < 0 || id >= len(t.matches) {
+		return Match{}, false
+	}
+	return t.copyMatch(t.matches[id]), true
+}
+
+// Matches returns copies of all matches in the order they were paired.
+func (t *Tournament) Matches() []Match {
+	matches := make([]Match, len(t.matches))
+	for i, m := range t.matches {
+		matches[i] = t.copyMatch(m)
+	}
+	return matches
+}
+
+func (t *Tournament) copyMatch(m *Match) Match {
+	c := *m
+	c.Players = make([]string, len(m.Players))
+	copy(c.Players, m.Players)
+	return c
+}
+
+// Standings returns the players ranked by how far they got: players still in or eliminated later first,
+// then by points, wins and seed.
+func (t *Tournament) Standings() []Standing {
+	standings := make([]Standing, 0, len(t.players))
+	for _, p := range t.players {
+		standings = append(standings, *t.standings[p])
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Eliminated != b.Eliminated {
+			return !a.Eliminated
+		}
+		if a.eliminatedRound != b.eliminatedRound {
+			return a.eliminatedRound > b.eliminatedRound
+		}
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		return a.seed < b.seed
+	})
+	return standings
+}
+
+// Winner returns the winner of a finished tournament, empty before.
+func (t *Tournament) Winner() string {
+	if !t.done {
+		return ""
+	}
+	return t.Standings()[0].ClientId
+}
+
+// Report records the result of a match, an empty winner is a draw. A drawn elimination match isn't done,
+// it has to be played again. Once all matches of the round are done the next round is paired,
+// its matches to play are returned.
+func (t *Tournament) Report(id int, winner string) ([]Match, error) {
+	if !t.started {
+		return nil, ErrNotStarted
+	}
+	if id < 0 || id >= len(t.matches) {
+		return nil, ErrUnknownMatch
+	}
+	m := t.matches[id]
+	if m.Done {
+		return nil, ErrMatchDone
+	}
+	if winner != "" && !m.has(winner) {
+		return nil, ErrNotInMatch
+	}
+
+	if winner == "" && t.format.Elimination() {
+		m.Draws++
+		return nil, nil
+	}
+	t.finish(m, winner)
+
+	for _, m := range t.matches {
+		if m.Round == t.round && !m.Done {
+			return nil, nil
+		}
+	}
+	return t.nextRound(), nil
+}
+
+func (m *Match) has(clientId string) bool {
+	for _, p := range m.Players {
+		if p == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+// finish records the match result in the standings.
+func (t *Tournament) finish(m *Match, winner string) {
+	m.Done = true
+	if winner == "" {
+		for _, p := range m.Players {
+			t.standings[p].Draws++
+			t.standings[p].Points += 0.5
+		}
+	} else {
+		m.Winner = winner
+		for _, p := range m.Players {
+			if p == winner {
+				t.standings[p].Wins++
+				t.standings[p].Points++
+			} else {
+				t.standings[p].Losses++
+			}
+		}
+	}
+
+	if len(m.Players) == 2 {
+		a, b := m.Players[0], m.Players[1]
+		t.played[a][b] = true
+		t.played[b][a] = true
+	}
+}
+
+// addMatch pairs the players in the current round, a single player gets a bye.
+func (t *Tournament) addMatch(bracket string, players ...string) *Match {
+	m := &Match{Id: len(t.matches), Round: t.round, Bracket: bracket, Players: players}
+	t.matches = append(t.matches, m)
+	if m.Bye() {
+		t.finish(m, players[0])
+	}
+	return m
+}
+
+// nextRound advances the brackets with the last round's results and pairs the next round.
+// Returns the matches to play, none if the tournament is finished.
+func (t *Tournament) nextRound() []Match {
+	for !t.done {
+		if t.round > 0 && t.format.Elimination() {
+			t.advanceBrackets()
+		}
+		if t.finished() {
+			t.done = true
+			break
+		}
+
+		t.round++
+		switch t.format {
+		case SingleElimination:
+			t.pairSingleElimination()
+		case DoubleElimination:
+			t.pairDoubleElimination()
+		case Swiss:
+			t.pairSwiss()
+		case RoundRobin:
+			t.pairRoundRobin()
+		}
+
+		toPlay := []Match{}
+		for _, m := range t.matches {
+			if m.Round == t.round && !m.Done {
+				toPlay = append(toPlay, t.copyMatch(m))
+			}
+		}
+		if len(toPlay) > 0 {
+			return toPlay
+		}
+		// A round of byes only, go on
+	}
+	return []Match{}
+}
+
+// finished reports whether the last round decided the tournament.
+func (t *Tournament) finished() bool {
+	switch t.format {
+	case Swiss, RoundRobin:
+		return t.round >= t.rounds
+	default:
+		return len(t.winners)+len(t.losers) <= 1
+	}
+}
+
+// seedBracket places the players in bracket order, so the best seeds meet as late as possible
+// (1 vs 8, 4 vs 5, 2 vs 7, 3 vs 6). The bracket is filled up to a power of two with byes, empty names.
+func seedBracket(players []string) []string {
+	size := 1
+	for size < len(players) {
+		size *= 2
+	}
+	order := []int{1}
+	for len(order) < size {
+		next := []int{}
+		for _, seed := range order {
+			next = append(next, seed, 2*len(order)+1-seed)
+		}
+		order = next
+	}
+
+	bracket := make([]string, size)
+	for i, seed := range order {
+		if seed <= len(players) {
+			bracket[i] = players[seed-1]
+		}
+	}
+	return bracket
+}
+
+// pairBracket pairs neighbours of the bracket, a player next to an empty place gets a bye.
+func (t *Tournament) pairBracket(bracket []string, name string) {
+	for i := 0; i+1 < len(bracket); i += 2 {
+		a, b := bracket[i], bracket[i+1]
+		switch {
+		case a == "" && b == "":
+		case a == "":
+			t.addMatch(name, b)
+		case b == "":
+			t.addMatch(name, a)
+		default:
+			t.addMatch(name, a, b)
+		}
+	}
+}
+
+func (t *Tournament) pairSingleElimination() {
+	t.pairBracket(t.winners, "")
+}
+
+// pairDoubleElimination pairs the winners bracket (no losses) and the losers bracket (one loss) separately.
+// A player left over in the losers bracket waits for the next round. Once each bracket has a single player
+// left they meet in the grand final. If the player from the losers bracket wins it, both have one loss
+// and the final is played again.
+func (t *Tournament) pairDoubleElimination() {
+	if len(t.winners) == 1 && len(t.losers) == 1 {
+		t.addMatch(GrandFinal, t.winners[0], t.losers[0])
+		return
+	}
+	if len(t.winners) == 0 && len(t.losers) == 2 {
+		t.addMatch(GrandFinal, t.losers[0], t.losers[1])
+		return
+	}
+	if len(t.winners) > 1 {
+		t.pairBracket(t.winners, WinnersBracket)
+	}
+	for i := 0; i+1 < len(t.losers); i += 2 {
+		t.addMatch(LosersBracket, t.losers[i], t.losers[i+1])
+	}
+}
+
+// advanceBrackets moves the players of the last round's matches: winners stay in their bracket, losers
+// drop to the losers bracket or are eliminated. This also covers the grand final, its winners bracket
+// player drops to the losers bracket after losing it.
+func (t *Tournament) advanceBrackets() {
+	results := map[string]*Match{}
+	for _, m := range t.matches {
+		if m.Round == t.round {
+			for _, p := range m.Players {
+				results[p] = m
+			}
+		}
+	}
+	lost := func(clientId string) bool {
+		m := results[clientId]
+		return m != nil && m.Winner != clientId
+	}
+
+	// Players that waited keep their place, players dropping from the winners bracket join at the end
+	losers := []string{}
+	for _, p := range t.losers {
+		if lost(p) {
+			t.eliminate(p)
+		} else {
+			losers = append(losers, p)
+		}
+	}
+	winners := []string{}
+	for _, p := range t.winners {
+		switch {
+		case p == "":
+		case !lost(p):
+			winners = append(winners, p)
+		case t.format == DoubleElimination:
+			losers = append(losers, p)
+		default:
+			t.eliminate(p)
+		}
+	}
+	t.winners, t.losers = winners, losers
+}
+
+func (t *Tournament) eliminate(clientId string) {
+	t.standings[clientId].Eliminated = true
+	t.standings[clientId].eliminatedRound = t.round
+}
+
+// pairSwiss pairs players with similar points that didn't play each other yet. With an odd number of
+// players the lowest ranked player without a bye gets one.
+func (t *Tournament) pairSwiss() {
+	ranked := []string{}
+	for _, s := range t.Standings() {
+		ranked = append(ranked, s.ClientId)
+	}
+
+	if len(ranked)%2 == 1 {
+		bye := len(ranked) - 1
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if !t.hadBye(ranked[i]) {
+				bye = i
+				break
+			}
+		}
+		t.addMatch("", ranked[bye])
+		ranked = append(ranked[:bye:bye], ranked[bye+1:]...)
+	}
+
+	paired := make([]bool, len(ranked))
+	for i, p := range ranked {
+		if paired[i] {
+			continue
+		}
+		opponent := -1
+		for j := i + 1; j < len(ranked); j++ {
+			if paired[j] {
+				continue
+			}
+			if opponent < 0 {
+				// Rematch if there is nobody else left
+				opponent = j
+			}
+			if !t.played[p][ranked[j]] {
+				opponent = j
+				break
+			}
+		}
+		paired[i], paired[opponent] = true, true
+		t.addMatch("", p, ranked[opponent])
+	}
+}
+
+func (t *Tournament) hadBye(clientId string) bool {
+	for _, m := range t.matches {
+		if m.Bye() && m.Players[0] == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+// pairRoundRobin uses the circle method: the first player stays in place and the others rotate by one
+// every round, players opposite each other play. With an odd number of players the empty place is a bye.
+func (t *Tournament) pairRoundRobin() {
+	circle := t.Players()
+	if len(circle)%2 == 1 {
+		circle = append(circle, "")
+	}
+	n := len(circle)
+	shift := (t.round - 1) % (n - 1)
+	rotated := []string{circle[0]}
+	for i := 0; i < n-1; i++ {
+		rotated = append(rotated, circle[1+(i-shift+n-1)%(n-1)])
+	}
+
+	for i := 0; i < n/2; i++ {
+		a, b := rotated[i], rotated[n-1-i]
+		switch {
+		case a == "":
+			t.addMatch("", b)
+		case b == "":
+			t.addMatch("", a)
+		default:
+			t.addMatch("", a, b)
+		}
+	}
+}
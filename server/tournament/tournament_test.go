@@ -0,0 +1,213 @@
+package tournament
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func newTournament(t *testing.T, format Format, rounds int, players ...string) *Tournament {
+	t.Helper()
+	tr := New(format, rounds)
+	for _, p := range players {
+		if err := tr.Register(p); err != nil {
+			t.Fatalf("Register(%s): %v", p, err)
+		}
+	}
+	return tr
+}
+
+// play reports every match until the tournament is finished, winner picks the winner of a match.
+func play(t *testing.T, tr *Tournament, toPlay []Match, winner func(m Match) string) {
+	t.Helper()
+	for i := 0; len(toPlay) > 0; i++ {
+		if i > 100 {
+			t.Fatal("tournament doesn't finish")
+		}
+		var next []Match
+		for _, m := range toPlay {
+			more, err := tr.Report(m.Id, winner(m))
+			if err != nil {
+				t.Fatalf("Report(%d): %v", m.Id, err)
+			}
+			next = append(next, more...)
+		}
+		toPlay = next
+	}
+	if !tr.Finished() {
+		t.Fatal("no matches left, but the tournament isn't finished")
+	}
+}
+
+// seeded returns the better seed of the match, players are named p1, p2, ... by seed.
+func seeded(m Match) string {
+	best := m.Players[0]
+	for _, p := range m.Players {
+		var a, b int
+		fmt.Sscanf(p, "p%d", &a)
+		fmt.Sscanf(best, "p%d", &b)
+		if a < b {
+			best = p
+		}
+	}
+	return best
+}
+
+func players(n int) []string {
+	p := []string{}
+	for i := 1; i <= n; i++ {
+		p = append(p, fmt.Sprintf("p%d", i))
+	}
+	return p
+}
+
+func TestSeedBracket(t *testing.T) {
+	got := seedBracket(players(6))
+	want := []string{"p1", "", "p4", "p5", "p2", "", "p3", "p6"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("seedBracket = %q, want %q", got, want)
+	}
+}
+
+func TestSingleElimination(t *testing.T) {
+	tr := newTournament(t, SingleElimination, 0, players(6)...)
+	toPlay, err := tr.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toPlay) != 2 {
+		t.Fatalf("%d matches in the first round, want 2 (p1 and p2 get byes)", len(toPlay))
+	}
+
+	play(t, tr, toPlay, seeded)
+	if tr.Winner() != "p1" {
+		t.Errorf("winner %s, want p1", tr.Winner())
+	}
+	if tr.Round() != 3 {
+		t.Errorf("%d rounds played, want 3", tr.Round())
+	}
+	if s := tr.Standings(); s[1].ClientId != "p2" || !s[1].Eliminated {
+		t.Errorf("runner-up %+v, want p2 eliminated in the final", s[1])
+	}
+}
+
+func TestEliminationDrawIsReplayed(t *testing.T) {
+	tr := newTournament(t, SingleElimination, 0, "a", "b")
+	toPlay, _ := tr.Start(nil)
+
+	if next, err := tr.Report(toPlay[0].Id, ""); err != nil || len(next) != 0 {
+		t.Fatalf("Report(draw) = %v, %v", next, err)
+	}
+	if m, _ := tr.Match(toPlay[0].Id); m.Done || m.Draws != 1 {
+		t.Fatalf("drawn match %+v, want it to be played again", m)
+	}
+	if _, err := tr.Report(toPlay[0].Id, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Winner() != "b" {
+		t.Errorf("winner %s, want b", tr.Winner())
+	}
+}
+
+func TestDoubleEliminationGrandFinalReset(t *testing.T) {
+	tr := newTournament(t, DoubleElimination, 0, players(4)...)
+	toPlay, _ := tr.Start(nil)
+
+	// p1 wins the winners bracket, p2 comes back through the losers bracket and wins both finals
+	play(t, tr, toPlay, func(m Match) string {
+		if m.Bracket == GrandFinal {
+			return "p2"
+		}
+		return seeded(m)
+	})
+
+	if tr.Winner() != "p2" {
+		t.Errorf("winner %s, want p2", tr.Winner())
+	}
+	finals := 0
+	for _, m := range tr.Matches() {
+		if m.Bracket == GrandFinal {
+			finals++
+		}
+	}
+	if finals != 2 {
+		t.Errorf("%d grand finals, want 2", finals)
+	}
+	for _, s := range tr.Standings() {
+		if s.ClientId != "p2" && (!s.Eliminated || s.Losses != 2) {
+			t.Errorf("%+v, want 2 losses and eliminated", s)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	tr := newTournament(t, RoundRobin, 0, players(5)...)
+	toPlay, _ := tr.Start(nil)
+	play(t, tr, toPlay, seeded)
+
+	met := map[string]int{}
+	for _, m := range tr.Matches() {
+		if !m.Bye() {
+			met[m.Players[0]+"-"+m.Players[1]]++
+			met[m.Players[1]+"-"+m.Players[0]]++
+		}
+	}
+	for _, a := range players(5) {
+		for _, b := range players(5) {
+			if a != b && met[a+"-"+b] != 1 {
+				t.Errorf("%s played %s %d times, want 1", a, b, met[a+"-"+b])
+			}
+		}
+	}
+	if tr.Rounds() != 5 || tr.Winner() != "p1" {
+		t.Errorf("%d rounds, winner %s, want 5 rounds and p1", tr.Rounds(), tr.Winner())
+	}
+}
+
+func TestSwiss(t *testing.T) {
+	tr := newTournament(t, Swiss, 0, players(5)...)
+	toPlay, _ := tr.Start(nil)
+	play(t, tr, toPlay, func(m Match) string {
+		if m.Round == 2 {
+			// Draws count half a point
+			return ""
+		}
+		return seeded(m)
+	})
+
+	if tr.Rounds() != 3 {
+		t.Errorf("%d rounds, want 3", tr.Rounds())
+	}
+	byes := map[string]bool{}
+	for _, m := range tr.Matches() {
+		if m.Bye() {
+			if byes[m.Players[0]] {
+				t.Errorf("%s got a second bye", m.Players[0])
+			}
+			byes[m.Players[0]] = true
+		}
+	}
+	if s := tr.Standings()[0]; s.ClientId != "p1" || s.Points != 2.5 {
+		t.Errorf("leader %+v, want p1 with 2.5 points", s)
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	tr := newTournament(t, Swiss, 0, "a")
+	if err := tr.Register("a"); err != ErrAlreadyRegistered {
+		t.Errorf("second registration: %v, want ErrAlreadyRegistered", err)
+	}
+	if _, err := tr.Start(nil); err != ErrTooFewPlayers {
+		t.Errorf("Start with one player: %v, want ErrTooFewPlayers", err)
+	}
+	tr.Register("b")
+	if _, err := tr.Start(func(clientId string) float64 { return map[string]float64{"a": 1000, "b": 1200}[clientId] }); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Players(); got[0] != "b" {
+		t.Errorf("seeds %q, want b first by rating", got)
+	}
+	if err := tr.Register("c"); err != ErrStarted {
+		t.Errorf("registration after the start: %v, want ErrStarted", err)
+	}
+}
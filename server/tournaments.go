@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/venom1270/RPS/protocol/messaging"
+	"github.com/venom1270/RPS/server/game"
+	"github.com/venom1270/RPS/server/tournament"
+)
+
+// Most players a tournament takes
+const maxTournamentPlayers = 64
+
+// How long players have to join their tournament match, see the -noShow flag
+const defaultNoShowTimeout = 2 * time.Minute
+
+var (
+	ErrTournamentExists   = messaging.NewError(messaging.ErrTournamentExists, "tournament already exists")
+	ErrTournamentNotFound = messaging.NewError(messaging.ErrTournamentNotFound, "tournament does not exist")
+	ErrTournamentFull     = messaging.NewError(messaging.ErrTournamentFull, "tournament is full")
+	ErrNotOrganizer       = messaging.NewError(messaging.ErrNotOrganizer, "only the organizer can do that")
+	ErrSeatReserved       = messaging.NewError(messaging.ErrNotInMatch, "the lobby is reserved for a tournament match")
+)
+
+// Tournament states
+const (
+	TournamentRegistering = "REGISTERING"
+	TournamentRunning     = "RUNNING"
+	TournamentFinished    = "FINISHED"
+)
+
+// Tournament runs a bracket on the server. Every match gets its own lobby, the bracket advances
+// with the results of the lobbies' games. Changes are sent to the tournament's feed.
+// It is safe for concurrent use.
+type Tournament struct {
+	name      string
+	organizer string        // client id of the player that created the tournament and can start it
+	settings  LobbySettings // of the match lobbies
+	server    *gameServer
+
+	mu      sync.Mutex
+	bracket *tournament.Tournament
+	lobbies map[int]string // lobby of each match by match id
+	feed    []*subscriber  // read-only connections, see tournamentFeed
+}
+
+// tournamentMatch is the match a lobby was opened for.
+type tournamentMatch struct {
+	t       *Tournament
+	id      int
+	players []string
+	// The result was reported and the match is over, only used by the lobby's event loop
+	decided bool
+}
+
+func newTournament(name string, organizer string, bracket *tournament.Tournament, settings LobbySettings, server *gameServer) *Tournament {
+	// Matches are played one on one in lobbies only the match's players can join
+	settings.MaxPlayers = 2
+	settings.Private = true
+	settings.Password = ""
+
+	return &Tournament{
+		name:      name,
+		organizer: organizer,
+		settings:  settings,
+		server:    server,
+		bracket:   bracket,
+		lobbies:   map[int]string{},
+		feed:      []*subscriber{},
+	}
+}
+
+// TournamentRegistry holds all tournaments of the server, finished ones are kept for their results.
+// It is safe for concurrent use.
+type TournamentRegistry struct {
+	mu          sync.RWMutex
+	tournaments []*Tournament
+}
+
+func newTournamentRegistry() *TournamentRegistry {
+	return &TournamentRegistry{tournaments: []*Tournament{}}
+}
+
+// CreateIfAbsent registers the tournament returned by create, unless one with the same name already exists.
+func (r *TournamentRegistry) CreateIfAbsent(name string, create func() *Tournament) (*Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tournaments {
+		if t.name == name {
+			return nil, ErrTournamentExists
+		}
+	}
+	t := create()
+	r.tournaments = append(r.tournaments, t)
+	return t, nil
+}
+
+func (r *TournamentRegistry) Get(name string) *Tournament {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tournaments {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// List returns a snapshot of all tournaments in creation order.
+func (r *TournamentRegistry) List() []*Tournament {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tournaments := make([]*Tournament, len(r.tournaments))
+	copy(tournaments, r.tournaments)
+	return tournaments
+}
+
+// register adds the player until the tournament starts.
+func (t *Tournament) register(clientId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.bracket.Started() && len(t.bracket.Players()) >= maxTournamentPlayers {
+		return ErrTournamentFull
+	}
+	if err := t.bracket.Register(clientId); err != nil {
+		return tournamentError(err)
+	}
+	log.Printf("Player %s registered for tournament %s", clientId, t.name)
+	t.publishState()
+	return nil
+}
+
+// start closes the registration and opens the lobbies of the first round, only the organizer can do that.
+// Players are seeded by rating.
+func (t *Tournament) start(clientId string) error {
+	if clientId != t.organizer {
+		return ErrNotOrganizer
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	matches, err := t.bracket.Start(t.server.rating)
+	if err != nil {
+		return tournamentError(err)
+	}
+	log.Printf("Tournament %s started with %d players", t.name, len(t.bracket.Players()))
+	t.openLobbies(matches)
+	t.publishState()
+	return nil
+}
+
+// report records the winner of the match, empty for a draw. Returns false if the match has to be played again.
+// Called from the event loop of the match's lobby.
+func (t *Tournament) report(id int, winner string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	matches, err := t.bracket.Report(id, winner)
+	if err != nil {
+		return false, tournamentError(err)
+	}
+	m, _ := t.bracket.Match(id)
+	if m.Done {
+		log.Printf("Tournament %s: match %d won by '%s'", t.name, id, winner)
+	}
+
+	t.openLobbies(matches)
+	if t.bracket.Finished() {
+		log.Printf("Tournament %s won by %s", t.name, t.bracket.Winner())
+	}
+	t.publishState()
+	return m.Done, nil
+}
+
+// openLobbies creates a lobby for each match and announces it on the feed. Must be called with mu held.
+func (t *Tournament) openLobbies(matches []tournament.Match) {
+	for _, m := range matches {
+		match := &tournamentMatch{t: t, id: m.Id, players: m.Players}
+
+		var lobby *Lobby
+		name := fmt.Sprintf("%s-m%d", t.name, m.Id)
+		for n := 2; lobby == nil; n++ {
+			lobby, _ = t.server.lobbies.CreateIfAbsent(name, func() *Lobby {
+				l := newLobby(name, t.settings, t.server)
+				l.tournament = match
+				return l
+			})
+			// Somebody took the name for another lobby
+			name = fmt.Sprintf("%s-m%d-%d", t.name, m.Id, n)
+		}
+
+		t.lobbies[m.Id] = lobby.id
+		if d := t.server.opts.noShowTimeout; d > 0 {
+			time.AfterFunc(d, func() {
+				lobby.send(noShowEvent{})
+			})
+		}
+		log.Printf("Tournament %s: %s in lobby %s", t.name, strings.Join(m.Players, " vs "), lobby.id)
+		t.publish(messaging.CreatePayloadMessage(messaging.CommandTournamentMatch, t.matchState(m)))
+	}
+}
+
+// state returns the tournament for CommandTournament. Must be called with mu held.
+func (t *Tournament) state() messaging.Tournament {
+	s := messaging.Tournament{
+		Name:      t.name,
+		Format:    t.bracket.Format().String(),
+		State:     TournamentRegistering,
+		Round:     t.bracket.Round(),
+		Rounds:    t.bracket.Rounds(),
+		Winner:    t.bracket.Winner(),
+		Standings: []messaging.TournamentStanding{},
+		Matches:   []messaging.TournamentMatch{},
+	}
+	if t.bracket.Finished() {
+		s.State = TournamentFinished
+	} else if t.bracket.Started() {
+		s.State = TournamentRunning
+	}
+
+	for _, p := range t.bracket.Standings() {
+		s.Standings = append(s.Standings, messaging.TournamentStanding{
+			ClientId:   p.ClientId,
+			Points:     p.Points,
+			Wins:       p.Wins,
+			Losses:     p.Losses,
+			Draws:      p.Draws,
+			Eliminated: p.Eliminated,
+		})
+	}
+	for _, m := range t.bracket.Matches() {
+		s.Matches = append(s.Matches, t.matchState(m))
+	}
+	return s
+}
+
+// matchState must be called with mu held.
+func (t *Tournament) matchState(m tournament.Match) messaging.TournamentMatch {
+	return messaging.TournamentMatch{
+		Id:      m.Id,
+		Round:   m.Round,
+		Bracket: m.Bracket,
+		Players: m.Players,
+		Lobby:   t.lobbies[m.Id],
+		Winner:  m.Winner,
+		Draws:   m.Draws,
+		Done:    m.Done,
+	}
+}
+
+// snapshot returns the tournament's current state.
+func (t *Tournament) snapshot() messaging.Tournament {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.state()
+}
+
+// publishState sends the tournament to the feed. Must be called with mu held.
+func (t *Tournament) publishState() {
+	t.publish(messaging.CreatePayloadMessage(messaging.CommandTournament, t.state()))
+}
+
+// publish sends the msg to all feed connections without blocking, slow connections are closed.
+// Must be called with mu held.
+func (t *Tournament) publish(msg *messaging.Message) {
+	for _, s := range t.feed {
+		select {
+		case s.msgs <- *msg:
+		default:
+			go s.closeSlow()
+		}
+	}
+}
+
+// tournamentFeed sends the tournament and every change of it to the connection until it closes.
+// The feed is read-only.
+func (t *Tournament) tournamentFeed(c *websocket.Conn) error {
+	s := &subscriber{
+		msgs:  make(chan messaging.Message, 16),
+		codec: messaging.CodecFor(c.Subprotocol()),
+		closeSlow: func() {
+			c.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
+		},
+		c: c,
+	}
+	defer c.CloseNow()
+
+	t.mu.Lock()
+	t.feed = append(t.feed, s)
+	s.msgs <- *messaging.CreatePayloadMessage(messaging.CommandTournament, t.state())
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for i := range t.feed {
+			if t.feed[i] == s {
+				t.feed = append(t.feed[:i], t.feed[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	ctx := c.CloseRead(context.Background())
+	for {
+		select {
+		case msg := <-s.msgs:
+			if err := writeTimeout(ctx, time.Second*5, c, s.codec.Encode(msg)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tournamentListHandler returns all tournaments: GET /tournaments
+func (cs *gameServer) tournamentListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use GET"))
+		return
+	}
+
+	tournaments := []messaging.Tournament{}
+	for _, t := range cs.tournaments.List() {
+		tournaments = append(tournaments, t.snapshot())
+	}
+	writeJSON(w, http.StatusOK, tournaments)
+}
+
+// tournamentHandler serves a tournament:
+//
+//	GET  /tournaments/{name}                      the bracket and standings
+//	GET  /tournaments/{name}/feed                 websocket, the bracket after every change and the lobby of every match
+//	POST /tournaments/{name}/create/{clientId}    creates the tournament, e.g. ?bracket=swiss&rounds=4&rules=classic
+//	POST /tournaments/{name}/register/{clientId}  registers a player
+//	POST /tournaments/{name}/start/{clientId}     starts the tournament, only the organizer can do that
+func (cs *gameServer) tournamentHandler(w http.ResponseWriter, r *http.Request) {
+	params := strings.Split(strings.TrimPrefix(r.URL.Path, "/tournaments/"), "/")
+	name := params[0]
+
+	switch {
+	case name == "" || len(params) > 3 || (len(params) == 3 && params[2] == ""):
+		writeError(w, messaging.NewError(messaging.ErrBadRequest, "expected /tournaments/{name}, /tournaments/{name}/feed or /tournaments/{name}/{create|register|start}/{clientId}"))
+	case len(params) == 1:
+		t := cs.tournaments.Get(name)
+		if r.Method != http.MethodGet {
+			writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use GET"))
+		} else if t == nil {
+			writeError(w, ErrTournamentNotFound)
+		} else {
+			writeJSON(w, http.StatusOK, t.snapshot())
+		}
+	case len(params) == 2 && params[1] == "feed":
+		t := cs.tournaments.Get(name)
+		if t == nil {
+			writeError(w, ErrTournamentNotFound)
+			return
+		}
+		c, err := websocket.Accept(w, r, acceptOptions)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		if err := t.tournamentFeed(c); err != nil && connectionDropped(err) {
+			log.Printf("Feed of tournament %s disconnected: %v", name, err)
+		}
+	case len(params) == 3 && r.Method != http.MethodPost:
+		writeError(w, messaging.NewError(messaging.ErrMethodNotAllowed, "use POST"))
+	case len(params) == 3 && params[1] == "create":
+		cs.createTournament(w, r, name, params[2])
+	case len(params) == 3 && (params[1] == "register" || params[1] == "start"):
+		t := cs.tournaments.Get(name)
+		if t == nil {
+			writeError(w, ErrTournamentNotFound)
+			return
+		}
+		var err error
+		if params[1] == "register" {
+			err = t.register(params[2])
+		} else {
+			err = t.start(params[2])
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t.snapshot())
+	default:
+		writeError(w, messaging.Errorf(messaging.ErrBadRequest, "unknown tournament request '%s'", params[1]))
+	}
+}
+
+// createTournament reads the format from ?bracket= (default single-elimination) and the number of rounds of
+// a swiss tournament from ?rounds=. The other options are the settings of the match lobbies.
+func (cs *gameServer) createTournament(w http.ResponseWriter, r *http.Request, name string, organizer string) {
+	q := r.URL.Query()
+
+	format := tournament.SingleElimination
+	if b := q.Get("bracket"); b != "" {
+		var err error
+		format, err = tournament.ParseFormat(b)
+		if err != nil {
+			writeError(w, messaging.NewError(messaging.ErrInvalidSettings, err.Error()))
+			return
+		}
+	}
+	rounds := 0
+	if n := q.Get("rounds"); n != "" {
+		var err error
+		rounds, err = strconv.Atoi(n)
+		if err != nil || rounds < 1 {
+			writeError(w, messaging.Errorf(messaging.ErrInvalidSettings, "invalid number of rounds '%s'", n))
+			return
+		}
+	}
+	settings, err := parseLobbySettings(q)
+	if err != nil {
+		writeError(w, messaging.NewError(messaging.ErrInvalidSettings, err.Error()))
+		return
+	}
+	if settings.Scoring == game.MINORITY {
+		writeError(w, messaging.NewError(messaging.ErrInvalidSettings, "tournament matches are one on one, minority scoring needs more players"))
+		return
+	}
+
+	t, err := cs.tournaments.CreateIfAbsent(name, func() *Tournament {
+		return newTournament(name, organizer, tournament.New(format, rounds), settings, cs)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Printf("Player %s created %s tournament %s", organizer, format, name)
+	writeJSON(w, http.StatusCreated, t.snapshot())
+}
+
+// reportTournament reports the result of the finished game to the tournament the lobby was opened for.
+// A drawn elimination match is played again in the same lobby. Called from the lobby's event loop.
+func (l *Lobby) reportTournament() {
+	winner := l.gameWinner()
+	decided, err := l.tournament.t.report(l.tournament.id, winner)
+	if err != nil {
+		log.Printf("Reporting the result of lobby %s to tournament %s failed: %v", l.id, l.tournament.t.name, err)
+		return
+	}
+
+	if !decided {
+		l.publish(messaging.CreateTextMessage("Tournament matches need a winner, send rematch to play again"))
+		return
+	}
+	l.tournament.decided = true
+	if winner == "" {
+		l.publish(messaging.CreateTextMessage("The draw was recorded in tournament " + l.tournament.t.name))
+	} else {
+		l.publish(messaging.CreateTextMessage(winner + " won the match of tournament " + l.tournament.t.name))
+	}
+}
+
+// handleNoShow awards the match to the player who joined if the other one didn't show up in time.
+// If nobody joined, the first player of the match advances.
+func (l *Lobby) handleNoShow() {
+	m := l.tournament
+	if m == nil || m.decided || l.game != nil || l.state != LobbyCreated {
+		// The match is being played
+		return
+	}
+
+	present := []string{}
+	for _, id := range m.players {
+		if l.findPlayer(id) != nil {
+			present = append(present, id)
+		}
+	}
+	switch len(present) {
+	case len(m.players):
+		return
+	case 0:
+		l.awardTournament(m.players[0], "Nobody showed up for the match")
+	default:
+		l.awardTournament(present[0], "The opponent didn't show up for the match")
+	}
+}
+
+// forfeitTournament gives the undecided match to the opponent of a player who left the lobby.
+// A running game was already forfeited, see forfeitSeat. Called from the lobby's event loop.
+func (l *Lobby) forfeitTournament(clientId string) {
+	m := l.tournament
+	if m == nil || m.decided || l.stopped {
+		return
+	}
+	winner, left := "", false
+	for _, id := range m.players {
+		if id == clientId {
+			left = true
+		} else {
+			winner = id
+		}
+	}
+	if left && winner != "" {
+		l.awardTournament(winner, clientId+" left the match")
+	}
+}
+
+// awardTournament decides the match without playing it and closes the lobby. Called from the lobby's event loop.
+func (l *Lobby) awardTournament(winner string, reason string) {
+	log.Printf("Tournament match in lobby %s awarded to %s: %s", l.id, winner, reason)
+	l.publish(messaging.CreateTextMessage(reason))
+	if _, err := l.tournament.t.report(l.tournament.id, winner); err != nil {
+		log.Printf("Reporting the result of lobby %s to tournament %s failed: %v", l.id, l.tournament.t.name, err)
+	}
+	l.tournament.decided = true
+	l.publish(messaging.CreateTextMessage(winner + " won the match of tournament " + l.tournament.t.name))
+	l.handleDisband()
+}
+
+// reserved reports whether the lobby's seats are reserved for other players. Called from the lobby's event loop.
+func (l *Lobby) reserved(clientId string) bool {
+	if l.tournament == nil {
+		return false
+	}
+	for _, p := range l.tournament.players {
+		if p == clientId {
+			return false
+		}
+	}
+	return true
+}
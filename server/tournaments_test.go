@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/venom1270/RPS/server/tournament"
+)
+
+// startTestTournament starts a tournament of a and b, it has a single match.
+func startTestTournament(t *testing.T, opts serverOptions) (*gameServer, *Tournament, *Lobby) {
+	t.Helper()
+	cs := newGameServer(opts)
+	tr, err := cs.tournaments.CreateIfAbsent("cup", func() *Tournament {
+		return newTournament("cup", "org", tournament.New(tournament.SingleElimination, 0), defaultLobbySettings(), cs)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.register("a")
+	tr.register("b")
+	if err := tr.start("org"); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, name := range tr.lobbies {
+		return cs, tr, cs.lobbies.Get(name)
+	}
+	t.Fatal("no match lobby opened")
+	return nil, nil, nil
+}
+
+// wantWinner waits until the tournament is won and the match lobby closed.
+func wantWinner(t *testing.T, cs *gameServer, tr *Tournament, lobby *Lobby, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for cs.lobbies.Get(lobby.id) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cs.lobbies.Get(lobby.id) != nil {
+		t.Fatal("match lobby wasn't closed")
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if winner := tr.bracket.Winner(); winner != want {
+		t.Errorf("tournament won by '%s', want %s", winner, want)
+	}
+}
+
+func TestTournamentNoShow(t *testing.T) {
+	cs, tr, lobby := startTestTournament(t, serverOptions{noShowTimeout: 50 * time.Millisecond})
+	if err := lobby.addPlayer(Player{clientId: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	wantWinner(t, cs, tr, lobby, "b")
+}
+
+func TestTournamentLeaveForfeits(t *testing.T) {
+	cs, tr, lobby := startTestTournament(t, serverOptions{})
+	for _, id := range []string{"a", "b"} {
+		if err := lobby.addPlayer(Player{clientId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lobby.exitLobby("a")
+	wantWinner(t, cs, tr, lobby, "b")
+}